@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+
+	pb "nanoray/lib/proto"
+	rt "nanoray/lib/raytrace"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Asset files from uploaded bundles, fetched by workers when preparing a render
+var blobs *rt.BlobStore
+
+func (s *server) UploadBundle(stream pb.Controller_UploadBundleServer) error {
+	var data bytes.Buffer
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if data.Len()+len(chunk.Value) > rt.MaxBundleSize {
+			return status.Errorf(codes.ResourceExhausted, "Bundle is larger than %d bytes", rt.MaxBundleSize)
+		}
+
+		data.Write(chunk.Value)
+	}
+
+	bundle, err := rt.ReadBundle(data.Bytes())
+	if err != nil {
+		log.Printf("Failed to read uploaded bundle\n%s", err.Error())
+		return status.Errorf(codes.InvalidArgument, "Failed to read bundle: %s", err.Error())
+	}
+
+	manifest := rt.AssetManifest{}
+	for name, content := range bundle.Files {
+		digest, err := blobs.Put(content)
+		if err != nil {
+			log.Printf("Failed to store bundle file %s\n%s", name, err.Error())
+			return status.Errorf(codes.Internal, "Failed to store bundle file %s", name)
+		}
+
+		manifest[name] = digest
+	}
+
+	log.Printf("Stored bundle with %d asset files", len(manifest))
+
+	return stream.SendAndClose(&pb.Bundle{
+		SceneData: bundle.SceneData,
+		Assets:    manifest.Proto(),
+	})
+}
+
+func (s *server) GetBlob(in *wrapperspb.StringValue, stream pb.Controller_GetBlobServer) error {
+	f, err := blobs.Open(in.Value)
+	if os.IsNotExist(err) {
+		return status.Errorf(codes.NotFound, "Blob %.12s not found", in.Value)
+	}
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	defer f.Close()
+
+	return sendChunks(f, stream.Send)
+}
+
+// Stream from a reader in chunks, so file size isn't limited by the max message size
+func sendChunks(r io.Reader, send func(*wrapperspb.BytesValue) error) error {
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if sendErr := send(wrapperspb.Bytes(buf[:n])); sendErr != nil {
+				return sendErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -7,14 +7,20 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"time"
 
 	pb "nanoray/lib/proto"
+	rt "nanoray/lib/raytrace"
 
 	"google.golang.org/grpc"
 )
 
 var (
-	portFlag = flag.Int("port", 5000, "The port the controller will listen on")
+	portFlag       = flag.Int("port", 5000, "The port the controller will listen on")
+	jobTimeoutFlag = flag.Duration("jobtimeout", 5*time.Minute, "Time a job can be in flight before it is reissued to another worker")
+	heartbeatFlag  = flag.Duration("heartbeat", 5*time.Second, "Expected interval between worker heartbeats, should match the workers")
+	encodingFlag   = flag.String("encoding", string(rt.TilePNG), "Preferred encoding for job results sent by workers: png or raw")
+	blobsFlag      = flag.String("blobs", "blobs", "Directory to store asset files uploaded in scene bundles")
 )
 
 func main() {
@@ -27,6 +33,12 @@ func main() {
 		port, _ = strconv.Atoi(os.Getenv("PORT"))
 	}
 
+	var err error
+	blobs, err = rt.NewBlobStore(*blobsFlag)
+	if err != nil {
+		log.Fatalf("Failed to open blob store\n%s", err.Error())
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Fatalf("Failed to bind to port\n%s", err.Error())
@@ -36,6 +48,9 @@ func main() {
 
 	pb.RegisterControllerServer(grpcSrv, &server{})
 
+	go watchDeadlines(*jobTimeoutFlag)
+	go watchHeartbeats(*heartbeatFlag)
+
 	log.Printf("Controller started on port %d", port)
 	if err := grpcSrv.Serve(lis); err != nil {
 		log.Fatalf("Failed to serve\n%s", err.Error())
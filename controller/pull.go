@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	pb "nanoray/lib/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Stands in for the worker client of a pull mode worker, calls are sent down the
+// job stream the worker opened rather than made as RPCs to the worker, and wait
+// for the worker to reply on the same stream
+type pullClient struct {
+	sendLock sync.Mutex
+	stream   pb.Controller_RequestJobsServer
+	ctx      context.Context
+	stop     context.CancelFunc
+
+	lock      sync.Mutex
+	nextID    uint64
+	pending   map[uint64]chan *pb.WorkReply // Work items sent and waiting for a reply, by ID
+	requested int                           // Jobs the worker has asked for and not been sent yet
+}
+
+func (s *server) RequestJobs(stream pb.Controller_RequestJobsServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	worker := first.GetRegister()
+	if worker == nil {
+		return status.Errorf(codes.InvalidArgument, "Job stream must start by registering the worker")
+	}
+
+	log.Printf("Worker %s pulling up to %d jobs at a time", worker.Id, worker.MaxJobs)
+
+	ctx, stop := context.WithCancel(stream.Context())
+	defer stop()
+
+	client := &pullClient{
+		stream:  stream,
+		ctx:     ctx,
+		stop:    stop,
+		pending: map[uint64]chan *pb.WorkReply{},
+	}
+
+	addWorker(WorkerConnection{
+		Worker: worker,
+		Client: client,
+	})
+
+	go client.receive(worker.Id)
+
+	// The stream stays open until the worker goes away, or is removed by us
+	<-ctx.Done()
+
+	// Only remove the worker if it hasn't reconnected on a new stream since
+	if w, ok := workers.Load(worker.Id); ok && w.(WorkerConnection).Client == client {
+		log.Printf("Worker %s stopped pulling jobs", worker.Id)
+		removeWorker(worker.Id)
+	}
+
+	return nil
+}
+
+// Read replies & requests for more jobs from the worker, until the stream closes
+func (p *pullClient) receive(workerID string) {
+	defer p.stop()
+
+	for {
+		msg, err := p.stream.Recv()
+		if err != nil {
+			return
+		}
+
+		if reply := msg.GetReply(); reply != nil {
+			p.lock.Lock()
+			waiting, ok := p.pending[reply.Id]
+			p.lock.Unlock()
+
+			if ok {
+				waiting <- reply
+			}
+		}
+
+		if msg.RequestJobs > 0 {
+			p.lock.Lock()
+			p.requested += int(msg.RequestJobs)
+			p.lock.Unlock()
+
+			// Dispatching waits on replies from this loop, so can't run on it
+			if w, ok := workers.Load(workerID); ok && w.(WorkerConnection).Client == p {
+				go fillWorker(w.(WorkerConnection))
+			}
+		}
+	}
+}
+
+// Check if the worker has asked for more jobs than it has been sent
+func (p *pullClient) wantsJobs() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.requested > 0
+}
+
+func (p *pullClient) send(item *pb.WorkItem) error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	if p.ctx.Err() != nil {
+		return status.Errorf(codes.Unavailable, "Worker job stream has closed")
+	}
+
+	return p.stream.Send(item)
+}
+
+// Send a work item and wait for the worker's reply, errors are returned as the
+// status the worker replied with
+func (p *pullClient) call(ctx context.Context, item *pb.WorkItem) (*pb.Void, error) {
+	waiting := make(chan *pb.WorkReply, 1)
+
+	p.lock.Lock()
+	p.nextID++
+	item.Id = p.nextID
+	p.pending[item.Id] = waiting
+	p.lock.Unlock()
+
+	defer func() {
+		p.lock.Lock()
+		delete(p.pending, item.Id)
+		p.lock.Unlock()
+	}()
+
+	if err := p.send(item); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-waiting:
+		if codes.Code(reply.Code) != codes.OK {
+			return nil, status.Error(codes.Code(reply.Code), reply.Message)
+		}
+
+		return &pb.Void{}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-p.ctx.Done():
+		return nil, status.Errorf(codes.Unavailable, "Worker job stream has closed")
+	}
+}
+
+func (p *pullClient) Ping(ctx context.Context, in *pb.Void, opts ...grpc.CallOption) (*pb.Void, error) {
+	return &pb.Void{}, nil
+}
+
+func (p *pullClient) PrepareRender(ctx context.Context, in *pb.PrepRenderRequest, opts ...grpc.CallOption) (*pb.Void, error) {
+	return p.call(ctx, &pb.WorkItem{Prepare: in})
+}
+
+func (p *pullClient) NewJob(ctx context.Context, in *pb.JobRequest, opts ...grpc.CallOption) (*pb.Void, error) {
+	p.lock.Lock()
+	if p.requested <= 0 {
+		p.lock.Unlock()
+		return nil, status.Errorf(codes.ResourceExhausted, "Worker has not asked for more jobs")
+	}
+	p.requested--
+	p.lock.Unlock()
+
+	res, err := p.call(ctx, &pb.WorkItem{Job: in})
+
+	// A job the worker didn't take leaves room for another, unless it says it's full
+	if err != nil && status.Code(err) != codes.ResourceExhausted {
+		p.lock.Lock()
+		p.requested++
+		p.lock.Unlock()
+	}
+
+	return res, err
+}
+
+func (p *pullClient) CancelJob(ctx context.Context, in *pb.JobRequest, opts ...grpc.CallOption) (*pb.Void, error) {
+	return p.call(ctx, &pb.WorkItem{Cancel: in})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	pb "nanoray/lib/proto"
+	rt "nanoray/lib/raytrace"
+)
+
+// How long finished renders are kept around so their progress can still be queried
+const finishedRenderTTL = time.Hour
+
+// All renders the controller knows about, keyed by render ID
+var renders = map[string]*rt.NetworkRender{}
+var renderOrder []string
+var rendersLock sync.Mutex
+
+// Generate a short random ID for a new render
+func newRenderID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Add a render to the registry, dropping any long finished renders at the same time
+func addRender(nr *rt.NetworkRender) {
+	rendersLock.Lock()
+	defer rendersLock.Unlock()
+
+	kept := renderOrder[:0]
+	for _, id := range renderOrder {
+		r := renders[id]
+
+		r.Lock.Lock()
+		expired := !r.IsActive() && time.Since(r.Start) > finishedRenderTTL
+		r.Lock.Unlock()
+
+		if expired {
+			delete(renders, id)
+			continue
+		}
+
+		kept = append(kept, id)
+	}
+
+	renders[nr.ID] = nr
+	renderOrder = append(kept, nr.ID)
+}
+
+// Find a render by ID, an empty ID gives the most recently started render
+func getRender(id string) *rt.NetworkRender {
+	rendersLock.Lock()
+	defer rendersLock.Unlock()
+
+	if id == "" {
+		if len(renderOrder) == 0 {
+			return nil
+		}
+
+		id = renderOrder[len(renderOrder)-1]
+	}
+
+	return renders[id]
+}
+
+// Renders that are running or paused, oldest first
+func activeRenders() []*rt.NetworkRender {
+	rendersLock.Lock()
+	defer rendersLock.Unlock()
+
+	active := []*rt.NetworkRender{}
+	for _, id := range renderOrder {
+		r := renders[id]
+
+		r.Lock.Lock()
+		isActive := r.IsActive()
+		r.Lock.Unlock()
+
+		if isActive {
+			active = append(active, r)
+		}
+	}
+
+	return active
+}
+
+// Progress details for a render
+// The Lock must be held by the caller
+func renderProgress(nr *rt.NetworkRender) *pb.Progress {
+	progress := &pb.Progress{
+		TotalJobs:     int32(nr.JobsTotal),
+		CompletedJobs: int32(nr.JobsComplete),
+		OutputName:    nr.OutputName,
+		RenderID:      nr.ID,
+		State:         string(nr.State),
+		Heatmap:       nr.Heatmap,
+	}
+
+	if p := nr.Progressive; p != nil {
+		progress.Progressive = true
+		progress.Passes = int32(p.Passes)
+		progress.SamplesPerPixel = int32(p.Samples)
+	}
+
+	return progress
+}
+
+// All renders in the registry, oldest first
+func allRenders() []*rt.NetworkRender {
+	rendersLock.Lock()
+	defer rendersLock.Unlock()
+
+	all := make([]*rt.NetworkRender, 0, len(renderOrder))
+	for _, id := range renderOrder {
+		all = append(all, renders[id])
+	}
+
+	return all
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"image"
 	"image/draw"
 	"image/png"
+	"io"
 	"log"
 	"os"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "nanoray/lib/proto"
@@ -23,169 +25,485 @@ type server struct {
 	pb.UnimplementedControllerServer
 }
 
-var netRender *rt.NetworkRender
-var img *image.RGBA
+var jobID atomic.Int32
 
-var jobID int32 = 0
+// Samples per pass for progressive renders with a time limit, that didn't ask for a pass size
+const defaultPassSamples = 4
 
-func (s *server) StartRender(ctx context.Context, in *pb.RenderRequest) (*pb.Void, error) {
+func (s *server) StartRender(ctx context.Context, in *pb.RenderRequest) (*wrapperspb.StringValue, error) {
 	render := rt.NewRender(int(in.Width), in.AspectRatio)
 	render.SamplesPerPixel = int(in.SamplesPerPixel)
 	render.MaxDepth = int(in.MaxDepth)
+	render.MinSamples = int(in.MinSamples)
+	render.MaxSamples = int(in.MaxSamples)
+	render.NoiseThreshold = in.NoiseThreshold
+	render.Seed = in.Seed
+
+	// Passes are uniform so their samples can be accumulated, adaptive sampling would need to span them
+	if in.NoiseThreshold > 0 && (in.PassSamples > 0 || in.TimeLimit > 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "Adaptive sampling can not be used with progressive passes")
+	}
+
+	// Assets must have been uploaded in a bundle first, so workers can fetch them
+	assets := rt.ManifestFromProto(in.Assets)
+	for name, digest := range assets {
+		if !blobs.Has(digest) {
+			return nil, status.Errorf(codes.InvalidArgument, "Asset %s has not been uploaded", name)
+		}
+	}
 
-	// Try to parse the scene data, we don't need the scene or camera, just need to know if it's valid
-	_, _, err := rt.ParseScene(in.SceneData, render.Width, render.Height)
+	if _, err := rt.NewSampler(rt.SamplerType(in.Sampler), 0, 1); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s: %s", err.Error(), in.Sampler)
+	}
+
+	// Try to parse the scene data, we don't need the camera, just need to know if it's valid
+	scene, _, err := rt.ParseScene(in.SceneData, render.Width, render.Height, rt.ManifestResolver{Manifest: assets, Store: blobs})
 	if err != nil {
 		log.Printf("Failed to parse scene data\n%s", err.Error())
 		return nil, status.Errorf(codes.Aborted, "Failed to parse scene data: %s", err.Error())
 	}
 
+	// The request can override the scene's choice of sampler & filter
+	render.Sampler = scene.Sampler
+	if in.Sampler != "" {
+		render.Sampler = rt.SamplerType(in.Sampler)
+	}
+
+	render.Filter, render.FilterRadius, err = rt.ResolveFilter(scene, rt.FilterType(in.Filter), in.FilterRadius)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid filter %s: %s", in.Filter, err.Error())
+	}
+
 	if workerCount == 0 {
 		log.Printf("No workers available to start render")
 		return nil, status.Errorf(codes.FailedPrecondition, "No workers available to start render")
 	}
 
-	slices := int(in.Slices)
-	if slices < 1 {
-		slices = 1
+	tiles, err := rt.MakeTiles(render.Width, render.Height, int(in.TileWidth), int(in.TileHeight), rt.TileOrder(in.TileOrder))
+	if err != nil {
+		log.Printf("Failed to split image into tiles\n%s", err.Error())
+		return nil, status.Errorf(codes.InvalidArgument, "Failed to split image into tiles: %s", err.Error())
 	}
 
-	jobW := int(render.Width)
-	jobH := int(render.Height) / slices
+	jobs := make([]*pb.JobRequest, 0, len(tiles))
+	for _, tile := range tiles {
+		jobs = append(jobs, render.NewJob(int(jobID.Add(1)-1), tile))
+	}
 
-	img = render.MakeImage()
+	id := newRenderID()
+	outputName := time.Now().Format("2006-01-02_15:04:05") + "_" + id
+	nr := rt.NewNetworkRender(id, in.SceneData, assets, render, jobs, outputName)
 
-	jobQueue := sync.Map{}
-	totalJobs := 0
-	for y := 0; y < render.Height; y += jobH {
-		for x := 0; x < render.Width; x += jobW {
-			jobQueue.Store(jobID, &pb.JobRequest{
-				Id:              jobID,
-				Width:           int32(jobW),
-				Height:          int32(jobH),
-				X:               int32(x),
-				Y:               int32(y),
-				SamplesPerPixel: int32(render.SamplesPerPixel),
-				MaxDepth:        int32(render.MaxDepth),
-				ImageDetails:    render.ImageDetails(),
-			})
+	// A time limit only makes sense with passes, so gets some by default
+	passSamples := int(in.PassSamples)
+	if in.TimeLimit > 0 && passSamples <= 0 {
+		passSamples = defaultPassSamples
+	}
 
-			jobID++
-			totalJobs++
-		}
+	if passSamples > 0 {
+		nr.MakeProgressive(passSamples, time.Duration(in.TimeLimit)*time.Second, scene.Gamma)
+		log.Printf("Render %s is progressive, with %d samples per pass", id, passSamples)
 	}
 
-	netRender = &rt.NetworkRender{
-		JobQueue:     jobQueue,
-		JobsTotal:    totalJobs,
-		JobsComplete: 0,
-		Start:        time.Now(),
-		OutputName:   time.Now().Format("2006-01-02_15:04:05"),
+	// Progressive renders already track samples per pixel in the radiance buffer
+	nr.Heatmap = in.Heatmap
+	if nr.Heatmap && nr.Progressive == nil {
+		nr.SampleCounts = make([]int32, render.Width*render.Height)
 	}
 
-	log.Printf("Starting render with %d jobs", totalJobs)
-	netRender.JobsTotal = totalJobs
+	addRender(nr)
 
-	workers.Range(func(_, worker interface{}) bool {
-		w := worker.(WorkerConnection)
-		maxJobs := w.Worker.MaxJobs
-
-		timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		_, err = w.Client.PrepareRender(timeoutCtx, &pb.PrepRenderRequest{
-			SceneData:    in.SceneData,
-			ImageDetails: render.ImageDetails(),
-		})
-		if err != nil {
-			log.Printf("Failed to prepare render on worker %s\n%s", w.Worker.Id, err.Error())
-			return false
-		}
+	log.Printf("Starting render %s with %d jobs", id, len(tiles))
 
-		netRender.JobQueue.Range(func(jobId, job interface{}) bool {
-			if maxJobs == 0 {
-				log.Printf("Initial %d jobs dispatched to worker %s", w.Worker.MaxJobs, w.Worker.Id)
-				return false
-			}
+	// Workers are sent the scene as they pick up their first job from this render
+	dispatchJobs()
 
-			jobReq := job.(*pb.JobRequest)
-			netRender.JobQueue.Delete(jobId)
+	return wrapperspb.String(id), nil
+}
 
-			_, err := w.Client.NewJob(context.Background(), jobReq)
-			if err != nil {
-				log.Printf("Failed to send job %d to worker %s\n%s", jobId, w.Worker.Id, err.Error())
-				return false
-			}
+// Size of each message when streaming rendered images & blobs back to clients
+const streamChunkSize = 1 << 20
 
-			maxJobs--
-			return true
-		})
+func (s *server) JobComplete(stream pb.Controller_JobCompleteServer) error {
+	result, err := receiveResult(stream)
+	if err != nil {
+		log.Printf("Failed to receive job result\n%s", err.Error())
+		return err
+	}
 
-		return true
-	})
+	err = processResult(result)
+	if err != nil {
+		return err
+	}
 
-	return &pb.Void{}, nil
+	return stream.SendAndClose(&pb.Void{})
 }
 
-func (s *server) JobComplete(ctx context.Context, result *pb.JobResult) (*pb.Void, error) {
-	netRender.Lock.Lock()
-	defer netRender.Lock.Unlock()
+// Read a streamed job result, joining the image data & sample counts from all the chunks
+func receiveResult(stream pb.Controller_JobCompleteServer) (*pb.JobResult, error) {
+	result, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result.ImageData = append(result.ImageData, chunk.ImageData...)
+		result.SampleCounts = append(result.SampleCounts, chunk.SampleCounts...)
+	}
+}
 
+// Draw a completed job into its render, and save the image once all jobs are done
+func processResult(result *pb.JobResult) error {
 	job := result.Job
 
-	srcImg := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{int(job.Width), int(job.Height)}})
-	srcImg.Pix = result.ImageData
+	// Lets a worker know it needs to register again, e.g. after the controller restarts
+	if _, ok := workers.Load(result.Worker.GetId()); !ok {
+		log.Printf("Job %d result from unregistered worker %s", job.Id, result.Worker.GetId())
+		return status.Errorf(codes.NotFound, "Worker %s is not registered", result.Worker.GetId())
+	}
+
+	nr := getRender(result.RenderID)
+	if nr == nil || result.RenderID == "" {
+		log.Printf("Job %d result from worker %s is for unknown render '%s'", job.Id, result.Worker.Id, result.RenderID)
+		return status.Errorf(codes.FailedPrecondition, "Render '%s' not found", result.RenderID)
+	}
+
+	// Progressive passes send radiance to accumulate, everything else is a finished tile image
+	var srcImg *image.RGBA
+	var radiance []float32
+	var err error
+	if rt.TileEncoding(result.Encoding) == rt.TileRadiance {
+		radiance, err = rt.DecodeRadiance(result.ImageData, int(job.Width), int(job.Height))
+	} else {
+		srcImg, err = rt.DecodeTile(result.ImageData, rt.TileEncoding(result.Encoding), int(job.Width), int(job.Height))
+	}
+
+	// Only adaptive jobs send sample counts, the rest took the same samples for every pixel
+	var counts []int32
+	if err == nil && len(result.SampleCounts) > 0 {
+		counts, err = rt.DecodeSampleCounts(result.SampleCounts, int(job.Width), int(job.Height))
+	}
+
+	if err != nil {
+		log.Printf("Job %d result from worker %s could not be decoded, requeuing\n%s", job.Id, result.Worker.Id, err.Error())
+
+		nr.Lock.Lock()
+		nr.Requeue(job.Id)
+		nr.Lock.Unlock()
+
+		return status.Errorf(codes.InvalidArgument, "Failed to decode job result: %s", err.Error())
+	}
+
+	nr.Lock.Lock()
+
+	// Cancelled renders and those finished early have no use for late results
+	if !nr.IsActive() {
+		nr.Lock.Unlock()
+		log.Printf("Render %s is %s, ignoring job %d result from worker %s", nr.ID, nr.State, job.Id, result.Worker.Id)
+		return nil
+	}
+
+	// Results for jobs that were requeued and then completed elsewhere are dropped
+	others := nr.OtherCopies(job.Id, result.Worker.Id)
+	accepted := false
+	if nr.Progressive != nil && radiance != nil {
+		accepted = nr.CompletePass(job, radiance)
+	} else if nr.Progressive == nil && srcImg != nil {
+		accepted = nr.CompleteJob(job.Id)
+	}
+
+	if !accepted {
+		nr.Lock.Unlock()
+		log.Printf("Job %d already complete or not expected, ignoring result from worker %s", job.Id, result.Worker.Id)
+		return nil
+	}
 
-	// Update the render image with the job result
-	draw.Draw(img, image.Rect(int(job.X), int(job.Y), int(job.X+job.Width), int(job.Y+job.Height)),
-		srcImg, image.Point{0, 0}, draw.Src)
+	// Update the render image with the job result, progressive renders update it after each pass
+	if srcImg != nil {
+		draw.Draw(nr.Image, image.Rect(int(job.X), int(job.Y), int(job.X+job.Width), int(job.Y+job.Height)),
+			srcImg, image.Point{0, 0}, draw.Src)
+	}
 
-	netRender.JobsComplete++
+	if nr.SampleCounts != nil {
+		rt.AddSampleCounts(nr.SampleCounts, int(nr.Details.Width), job, counts)
+	}
 
-	log.Printf("Job %d complete, %d jobs remaining", job.Id, netRender.JobsTotal-netRender.JobsComplete)
+	log.Printf("Render %s job %d complete, %d jobs remaining", nr.ID, job.Id, nr.JobsTotal-nr.JobsComplete)
 
-	if netRender.JobsComplete == netRender.JobsTotal {
-		log.Printf("Time to complete: %s", time.Since(netRender.Start))
+	if nr.State == rt.RenderComplete {
+		log.Printf("Render %s time to complete: %s", nr.ID, time.Since(nr.Start))
 		log.Printf("All jobs completed, saving file!!!")
 
-		_ = os.Mkdir("output", os.ModePerm)
+		err = saveRender(nr)
+	}
+
+	nr.Lock.Unlock()
 
-		f, err := os.Create(fmt.Sprintf("output/%s.png", netRender.OutputName))
+	// Requeued copies of the job still running elsewhere are no longer needed
+	if len(others) > 0 {
+		log.Printf("Job %d done, cancelling %d other copies", job.Id, len(others))
+		go abortJobs(context.Background(), others)
+	}
+
+	// Top up the worker that finished, requeued jobs may also need a home elsewhere
+	// When this was the last job of a render, its workers can move on to the next one
+	dispatchJobs()
+
+	return err
+}
+
+// Write the finished image to the output directory, and free the memory it used
+// The Lock must be held by the caller
+func saveRender(nr *rt.NetworkRender) error {
+	err := savePNG(nr.OutputName, nr.Image)
+	if err != nil {
+		return err
+	}
+
+	if nr.Heatmap {
+		err = savePNG(nr.OutputName+"_samples", nr.SampleHeatmap())
 		if err != nil {
-			log.Printf("Failed to create render file\n%s", err.Error())
-			return nil, err
+			return err
 		}
-		defer f.Close()
+	}
 
-		err = png.Encode(f, img)
-		if err != nil {
-			log.Printf("Failed to encode render image\n%s", err.Error())
-			return nil, err
+	// The image is on disk now, no need to hold it in memory
+	nr.Image = nil
+	nr.SampleCounts = nil
+	if nr.Progressive != nil {
+		nr.Progressive.Radiance = nil
+	}
+
+	return nil
+}
+
+func savePNG(name string, img image.Image) error {
+	_ = os.Mkdir("output", os.ModePerm)
+
+	f, err := os.Create(fmt.Sprintf("output/%s.png", name))
+	if err != nil {
+		log.Printf("Failed to create render file\n%s", err.Error())
+		return err
+	}
+	defer f.Close()
+
+	err = png.Encode(f, img)
+	if err != nil {
+		log.Printf("Failed to encode render image\n%s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Send queued jobs to a worker until it is running as many as it can handle
+// Older renders are drained first, jobs that fail to send are put back on the queue
+// Renders the worker hasn't been sent yet are prepared in the background, meanwhile
+// the worker gets jobs from the next render, and is filled again once it's ready
+func fillWorker(w WorkerConnection) {
+	if !w.isAlive() {
+		return
+	}
+
+	// The load check and assigning a job must not be split by another dispatch to this worker
+	w.dispatch.Lock()
+	defer w.dispatch.Unlock()
+
+	for _, nr := range activeRenders() {
+		nr.Lock.Lock()
+		queued := nr.State == rt.RenderRunning && len(nr.JobQueue) > 0
+		prepared := nr.Prepared[w.Worker.Id]
+		nr.Lock.Unlock()
+
+		if !queued {
+			continue
 		}
-	} else {
-		var nextJob *pb.JobRequest = nil
-		netRender.JobQueue.Range(func(_, job interface{}) bool {
-			nextJob = job.(*pb.JobRequest)
-			return nextJob != nil
-		})
-
-		if nextJob != nil {
-			log.Printf("Dispatching job: %d to worker %s", nextJob.Id, result.Worker.Id)
-			workerConn, _ := workers.Load(result.Worker.Id)
-			worker := workerConn.(WorkerConnection)
-
-			netRender.JobQueue.Delete(nextJob.Id)
-			_, err := worker.Client.NewJob(context.Background(), nextJob)
+
+		if !prepared {
+			go prepareWorker(w, nr)
+			continue
+		}
+
+		for {
+			if workerLoad(w.Worker.Id) >= int(w.Worker.MaxJobs) || !w.wantsJobs() {
+				return
+			}
+
+			nr.Lock.Lock()
+			jobReq := nr.AssignJob(w.Worker.Id)
+			nr.Lock.Unlock()
+
+			if jobReq == nil {
+				break
+			}
+
+			// Progressive passes always send radiance, so they can be accumulated
+			if nr.Progressive == nil {
+				jobReq.ResultEncoding = string(rt.NegotiateEncoding(rt.TileEncoding(*encodingFlag), w.Worker.Encodings))
+			}
+
+			log.Printf("Dispatching job: %d of render %s to worker %s", jobReq.Id, nr.ID, w.Worker.Id)
+
+			_, err := w.Client.NewJob(context.Background(), jobReq)
+
+			// A requeued job can come back to a worker still running its first copy, which carries on
+			if status.Code(err) == codes.AlreadyExists {
+				log.Printf("Worker %s is already running job %d", w.Worker.Id, jobReq.Id)
+				continue
+			}
+
 			if err != nil {
-				log.Printf("Failed to send job %d to worker %s\n%s", nextJob.Id, worker.Worker.Id, err.Error())
+				log.Printf("Failed to send job %d to worker %s\n%s", jobReq.Id, w.Worker.Id, err.Error())
+
+				// A worker that is full has the job requeued like any other failure, and is
+				// topped up again once it sends back a result
+				nr.Lock.Lock()
+				nr.Requeue(jobReq.Id)
+				// The worker may have dropped the scene to make room for others, so resend it
+				missingScene := status.Code(err) == codes.FailedPrecondition
+				if missingScene {
+					delete(nr.Prepared, w.Worker.Id)
+				}
+				nr.Lock.Unlock()
+
+				if missingScene {
+					go prepareWorker(w, nr)
+				}
+
+				return
 			}
 		}
 	}
+}
 
-	return &pb.Void{}, nil
+// Time allowed for a worker to fetch assets and prepare a render
+const prepareAssetsTimeout = 2 * time.Minute
+
+// Send the scene for a render to a worker, then give the worker jobs from it
+// Runs in the background, as fetching assets can keep the worker busy for minutes
+func prepareWorker(w WorkerConnection, nr *rt.NetworkRender) {
+	nr.Lock.Lock()
+	busy := nr.Prepared[w.Worker.Id] || nr.Preparing[w.Worker.Id]
+	nr.Preparing[w.Worker.Id] = true
+	nr.Lock.Unlock()
+
+	if busy {
+		return
+	}
+
+	ok := sendScene(w, nr)
+
+	nr.Lock.Lock()
+	delete(nr.Preparing, w.Worker.Id)
+	nr.Prepared[w.Worker.Id] = ok
+	nr.Lock.Unlock()
+
+	if ok {
+		fillWorker(w)
+	}
 }
 
-func (s *server) GetProgress(ctx context.Context, in *pb.Void) (*pb.Progress, error) {
-	if netRender == nil {
+// Send the scene for a render to a worker, returns true once the worker has it
+func sendScene(w WorkerConnection, nr *rt.NetworkRender) bool {
+	// Workers may need to fetch large assets before they are ready
+	timeout := 5 * time.Second
+	if len(nr.Assets) > 0 {
+		timeout = prepareAssetsTimeout
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req := &pb.PrepRenderRequest{
+		ImageDetails: nr.Details,
+		RenderID:     nr.ID,
+		SceneHash:    nr.SceneHash,
+		Assets:       nr.Assets.Proto(),
+	}
+
+	// Skip sending the scene if the worker already has it parsed
+	cached := w.hasScene(nr.SceneHash)
+	if !cached {
+		req.SceneData = nr.SceneData
+	}
+
+	_, err := w.Client.PrepareRender(timeoutCtx, req)
+
+	// The worker may have dropped the scene since it last told us, so send it in full
+	if cached && status.Code(err) == codes.NotFound {
+		req.SceneData = nr.SceneData
+		_, err = w.Client.PrepareRender(timeoutCtx, req)
+	}
+
+	if err != nil {
+		log.Printf("Failed to prepare render %s on worker %s\n%s", nr.ID, w.Worker.Id, err.Error())
+		return false
+	}
+
+	w.Health.lock.Lock()
+	w.Health.Scenes[nr.SceneHash] = true
+	w.Health.lock.Unlock()
+
+	return true
+}
+
+// Number of jobs a worker has in flight across all renders
+func workerLoad(workerID string) int {
+	load := 0
+
+	for _, nr := range activeRenders() {
+		nr.Lock.Lock()
+		load += nr.WorkerJobs(workerID)
+		nr.Lock.Unlock()
+	}
+
+	return load
+}
+
+// Hand out queued jobs to all connected workers with spare capacity
+// Each worker is filled in the background, so callers never wait on slow workers
+func dispatchJobs() {
+	workers.Range(func(_, worker interface{}) bool {
+		go fillWorker(worker.(WorkerConnection))
+		return true
+	})
+}
+
+// Periodically requeue jobs that have been in flight past the deadline
+// Covers workers that stop heartbeating, before they are given up on as dead
+func watchDeadlines(timeout time.Duration) {
+	interval := timeout / 4
+	if interval > 10*time.Second {
+		interval = 10 * time.Second
+	}
+
+	for range time.Tick(interval) {
+		count := 0
+
+		for _, nr := range activeRenders() {
+			nr.Lock.Lock()
+			count += nr.RequeueExpired(timeout, isWorkerAlive)
+			nr.Lock.Unlock()
+		}
+
+		if count > 0 {
+			log.Printf("Requeued %d jobs that exceeded the %s deadline", count, timeout)
+			dispatchJobs()
+		}
+	}
+}
+
+func (s *server) GetProgress(ctx context.Context, in *wrapperspb.StringValue) (*pb.Progress, error) {
+	nr := getRender(in.GetValue())
+	if nr == nil {
 		return &pb.Progress{
 			TotalJobs:     0,
 			CompletedJobs: 0,
@@ -193,14 +511,153 @@ func (s *server) GetProgress(ctx context.Context, in *pb.Void) (*pb.Progress, er
 		}, nil
 	}
 
-	netRender.Lock.Lock()
-	defer netRender.Lock.Unlock()
+	nr.Lock.Lock()
+	defer nr.Lock.Unlock()
 
-	return &pb.Progress{
-		TotalJobs:     int32(netRender.JobsTotal),
-		CompletedJobs: int32(netRender.JobsComplete),
-		OutputName:    netRender.OutputName,
-	}, nil
+	return renderProgress(nr), nil
+}
+
+func (s *server) ListRenders(ctx context.Context, in *pb.Void) (*pb.RenderList, error) {
+	list := &pb.RenderList{}
+
+	// Newest first, the same as the rendered images list
+	all := allRenders()
+	for i := len(all) - 1; i >= 0; i-- {
+		all[i].Lock.Lock()
+		list.Renders = append(list.Renders, renderProgress(all[i]))
+		all[i].Lock.Unlock()
+	}
+
+	return list, nil
+}
+
+func (s *server) CancelRender(ctx context.Context, in *wrapperspb.StringValue) (*pb.Void, error) {
+	nr := getRender(in.GetValue())
+	if nr == nil || in.GetValue() == "" {
+		return nil, status.Errorf(codes.NotFound, "Render '%s' not found", in.GetValue())
+	}
+
+	nr.Lock.Lock()
+	if !nr.IsActive() {
+		nr.Lock.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "Render %s is %s and can not be cancelled", nr.ID, nr.State)
+	}
+
+	inFlight := nr.Cancel()
+	nr.Image = nil
+	nr.SampleCounts = nil
+	if nr.Progressive != nil {
+		nr.Progressive.Radiance = nil
+	}
+	nr.Lock.Unlock()
+
+	log.Printf("Render %s cancelled, aborting %d jobs in flight", nr.ID, len(inFlight))
+	abortJobs(ctx, inFlight)
+
+	return &pb.Void{}, nil
+}
+
+func (s *server) FinishRender(ctx context.Context, in *wrapperspb.StringValue) (*pb.Void, error) {
+	nr := getRender(in.GetValue())
+	if nr == nil || in.GetValue() == "" {
+		return nil, status.Errorf(codes.NotFound, "Render '%s' not found", in.GetValue())
+	}
+
+	nr.Lock.Lock()
+	defer nr.Lock.Unlock()
+
+	if !nr.IsActive() || nr.Progressive == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "Render %s is not an active progressive render", nr.ID)
+	}
+
+	inFlight := nr.Finish()
+	log.Printf("Render %s finished early after %d passes, aborting %d jobs in flight", nr.ID, nr.Progressive.Passes, len(inFlight))
+
+	if err := saveRender(nr); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to save render: %s", err.Error())
+	}
+
+	go abortJobs(context.Background(), inFlight)
+
+	return &pb.Void{}, nil
+}
+
+// Tell workers to stop jobs they no longer need to run
+func abortJobs(ctx context.Context, inFlight []*rt.InFlightJob) {
+	for _, f := range inFlight {
+		workerConn, ok := workers.Load(f.WorkerID)
+		if !ok {
+			continue
+		}
+
+		w := workerConn.(WorkerConnection)
+		_, err := w.Client.CancelJob(ctx, f.Job)
+		if err != nil {
+			log.Printf("Failed to cancel job %d on worker %s\n%s", f.Job.Id, f.WorkerID, err.Error())
+		}
+	}
+
+	// Workers that were busy with this render now have room for others
+	dispatchJobs()
+}
+
+func (s *server) GetPreview(in *wrapperspb.StringValue, stream pb.Controller_GetPreviewServer) error {
+	nr := getRender(in.GetValue())
+	if nr == nil {
+		return status.Errorf(codes.NotFound, "Render '%s' not found", in.GetValue())
+	}
+
+	nr.Lock.Lock()
+	if nr.Image == nil {
+		nr.Lock.Unlock()
+		return status.Errorf(codes.NotFound, "Render %s is %s, there is no preview", nr.ID, nr.State)
+	}
+
+	// Encode with the lock held, so the image isn't changing underneath us
+	buf := bytes.Buffer{}
+	err := png.Encode(&buf, nr.Image)
+	nr.Lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return sendChunks(&buf, stream.Send)
+}
+
+func (s *server) PauseRender(ctx context.Context, in *wrapperspb.StringValue) (*pb.Void, error) {
+	return setRenderState(in.GetValue(), rt.RenderRunning, rt.RenderPaused)
+}
+
+func (s *server) ResumeRender(ctx context.Context, in *wrapperspb.StringValue) (*pb.Void, error) {
+	res, err := setRenderState(in.GetValue(), rt.RenderPaused, rt.RenderRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	dispatchJobs()
+
+	return res, nil
+}
+
+// Move a render between paused and running, jobs already in flight are left to finish
+func setRenderState(id string, from, to rt.RenderState) (*pb.Void, error) {
+	nr := getRender(id)
+	if nr == nil || id == "" {
+		return nil, status.Errorf(codes.NotFound, "Render '%s' not found", id)
+	}
+
+	nr.Lock.Lock()
+	defer nr.Lock.Unlock()
+
+	if nr.State != from {
+		return nil, status.Errorf(codes.FailedPrecondition, "Render %s is %s, expected %s", nr.ID, nr.State, from)
+	}
+
+	nr.State = to
+	log.Printf("Render %s is now %s", nr.ID, to)
+
+	return &pb.Void{}, nil
 }
 
 func (s *server) ListRenderedImages(ctx context.Context, in *pb.Void) (*pb.ImageList, error) {
@@ -222,38 +679,32 @@ func (s *server) ListRenderedImages(ctx context.Context, in *pb.Void) (*pb.Image
 	}, nil
 }
 
-func (s *server) GetRenderedImage(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.BytesValue, error) {
+func (s *server) GetRenderedImage(in *wrapperspb.StringValue, stream pb.Controller_GetRenderedImageServer) error {
 	if in.Value == "" {
-		return nil, status.Errorf(codes.InvalidArgument, "No image name provided")
+		return status.Errorf(codes.InvalidArgument, "No image name provided")
 	}
 
 	if in.Value == "latest" {
 		files, err := os.ReadDir("output")
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if len(files) == 0 {
-			return nil, status.Errorf(codes.NotFound, "No images found")
+			return status.Errorf(codes.NotFound, "No images found")
 		}
 
 		in.Value = files[len(files)-1].Name()
 	}
 
-	if _, err := os.Stat(fmt.Sprintf("output/%s", in.Value)); os.IsNotExist(err) {
-		return nil, status.Errorf(codes.NotFound, "Image not found")
-	}
-
 	f, err := os.Open(fmt.Sprintf("output/%s", in.Value))
-	if err != nil {
-		return nil, err
+	if os.IsNotExist(err) {
+		return status.Errorf(codes.NotFound, "Image not found")
 	}
-	defer f.Close()
-
-	rawBytes, err := os.ReadFile(fmt.Sprintf("output/%s", in.Value))
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer f.Close()
 
-	return &wrapperspb.BytesValue{Value: rawBytes}, nil
+	return sendChunks(f, stream.Send)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	pb "nanoray/lib/proto"
+
+	"google.golang.org/grpc"
+)
+
+// Server side of a JobComplete stream, replaying a fixed set of messages
+type resultReplay struct {
+	grpc.ServerStream
+	msgs []*pb.JobResult
+	err  error
+}
+
+func (r *resultReplay) Recv() (*pb.JobResult, error) {
+	if len(r.msgs) == 0 {
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		return nil, io.EOF
+	}
+
+	msg := r.msgs[0]
+	r.msgs = r.msgs[1:]
+
+	return msg, nil
+}
+
+func (r *resultReplay) SendAndClose(*pb.Void) error {
+	return nil
+}
+
+func TestReceiveResult(t *testing.T) {
+	job := &pb.JobRequest{Id: 9, Width: 4, Height: 2}
+
+	stream := &resultReplay{msgs: []*pb.JobResult{
+		{Job: job, RenderID: "r1", Encoding: "raw", ImageData: []byte{1, 2, 3}},
+		{ImageData: []byte{4, 5}, SampleCounts: []byte{7}},
+		{},
+		{ImageData: []byte{6}, SampleCounts: []byte{8, 9}},
+	}}
+
+	res, err := receiveResult(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Job.GetId() != 9 || res.RenderID != "r1" || res.Encoding != "raw" {
+		t.Errorf("job details lost: %v", res)
+	}
+
+	if !bytes.Equal(res.ImageData, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("image data is %v", res.ImageData)
+	}
+
+	if !bytes.Equal(res.SampleCounts, []byte{7, 8, 9}) {
+		t.Errorf("sample counts are %v", res.SampleCounts)
+	}
+
+	// A stream that breaks part way through is an error, not a short result
+	broken := errors.New("connection reset")
+	stream = &resultReplay{msgs: []*pb.JobResult{{Job: job}, {ImageData: []byte{1}}}, err: broken}
+	if _, err := receiveResult(stream); !errors.Is(err, broken) {
+		t.Errorf("broken stream gave error %v", err)
+	}
+
+	if _, err := receiveResult(&resultReplay{}); err != io.EOF {
+		t.Errorf("empty stream gave error %v", err)
+	}
+}
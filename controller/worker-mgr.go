@@ -7,18 +7,48 @@ import (
 	pb "nanoray/lib/proto"
 	"sort"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type WorkerConnection struct {
 	Worker *pb.WorkerInfo
 	Client pb.WorkerClient
-	Conn   *grpc.ClientConn
+	Conn   *grpc.ClientConn // Not set for pull mode workers
+	Health *WorkerHealth
+
+	// Held while sending jobs to the worker, so concurrent dispatches can't both see a
+	// free slot and over-commit it
+	dispatch *sync.Mutex
+}
+
+// Liveness of a worker, updated by heartbeats
+type WorkerHealth struct {
+	lock     sync.Mutex
+	LastSeen time.Time
+	Load     *pb.WorkerLoad
+	State    string
+	Scenes   map[string]bool // Scene hashes the worker has cached
 }
 
+const (
+	workerAlive   = "alive"
+	workerSuspect = "suspect"
+	workerDead    = "dead"
+)
+
+// Heartbeats missed before a worker is suspect and gets no new jobs, or is dead and removed
+const (
+	suspectAfterMissed = 3
+	deadAfterMissed    = 6
+)
+
 var workers sync.Map
 var workerCount = 0
 var workerCountLock sync.Mutex
@@ -51,45 +81,222 @@ func (s *server) RegisterWorker(ctx context.Context, worker *pb.WorkerInfo) (*pb
 			}
 
 			currentState := conn.GetState()
+			if currentState == connectivity.Shutdown {
+				// Closed by us, the worker has already been removed
+				return
+			}
+
 			if currentState == connectivity.Idle {
 				log.Printf("Worker %s disconnected", worker.Id)
-				w, ok := workers.Load(worker.Id)
-				if !ok {
-					return
-				}
-				w.(WorkerConnection).Conn.Close()
-				workers.Delete(worker.Id)
-
-				workerCountLock.Lock()
-				defer workerCountLock.Unlock()
-				workerCount--
-
-				log.Printf("Workers online: %d", workerCount)
+				removeWorker(worker.Id)
 				return
 			}
 		}
 	}()
 
-	workers.Store(worker.Id, WorkerConnection{
+	addWorker(WorkerConnection{
 		Worker: worker,
 		Client: newClient,
 		Conn:   conn,
 	})
 
+	return &pb.Void{}, nil
+}
+
+// Add a newly connected worker and give it work, replacing any old connection with the same ID
+func addWorker(w WorkerConnection) {
+	// A worker restarting quickly can register again before the old connection is noticed as gone
+	if _, exists := workers.Load(w.Worker.Id); exists {
+		log.Printf("Worker %s registered again, replacing old connection", w.Worker.Id)
+		removeWorker(w.Worker.Id)
+	}
+
+	w.Health = &WorkerHealth{
+		LastSeen: time.Now(),
+		Load:     &pb.WorkerLoad{},
+		State:    workerAlive,
+	}
+	w.Health.setScenes(w.Worker.SceneHashes)
+	w.dispatch = &sync.Mutex{}
+	workers.Store(w.Worker.Id, w)
+
 	workerCountLock.Lock()
 	workerCount++
 	workerCountLock.Unlock()
 
 	log.Printf("Workers online: %d", workerCount)
 
+	// A worker rejoining with the same ID has lost its scenes and jobs, clear those before giving it work
+	go func() {
+		requeueWorker(w.Worker.Id)
+		dispatchJobs()
+	}()
+}
+
+func (s *server) Heartbeat(ctx context.Context, in *pb.WorkerHeartbeat) (*pb.Void, error) {
+	w, ok := workers.Load(in.WorkerID)
+	if !ok {
+		// Tells the worker it needs to register again
+		return nil, status.Errorf(codes.NotFound, "Worker %s is not registered", in.WorkerID)
+	}
+
+	health := w.(WorkerConnection).Health
+
+	health.lock.Lock()
+	revived := health.State != workerAlive
+	health.LastSeen = time.Now()
+	health.Load = in.Load
+	health.State = workerAlive
+	health.setScenes(in.SceneHashes)
+	health.lock.Unlock()
+
+	// Suspect workers are skipped when dispatching, so this one may have nothing to do
+	if revived {
+		log.Printf("Worker %s is alive again", in.WorkerID)
+		go fillWorker(w.(WorkerConnection))
+	}
+
 	return &pb.Void{}, nil
 }
 
+// Replace the set of scenes a worker has cached, with what it last reported
+// The lock must be held by the caller
+func (h *WorkerHealth) setScenes(hashes []string) {
+	h.Scenes = make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		h.Scenes[hash] = true
+	}
+}
+
+// Check if a worker has reported having a scene cached
+func (w WorkerConnection) hasScene(hash string) bool {
+	w.Health.lock.Lock()
+	defer w.Health.lock.Unlock()
+
+	return w.Health.Scenes[hash]
+}
+
+// Check when each worker was last heard from, marking them suspect then removing them as dead
+// Catches half-open connections which never go idle
+func watchHeartbeats(interval time.Duration) {
+	for range time.Tick(interval) {
+		workers.Range(func(id, value interface{}) bool {
+			health := value.(WorkerConnection).Health
+
+			health.lock.Lock()
+			missed := int(time.Since(health.LastSeen) / interval)
+			wasAlive := health.State == workerAlive
+
+			switch {
+			case missed >= deadAfterMissed:
+				health.State = workerDead
+			case missed >= suspectAfterMissed:
+				health.State = workerSuspect
+			}
+
+			state := health.State
+			health.lock.Unlock()
+
+			if state == workerSuspect && wasAlive {
+				log.Printf("Worker %s has missed %d heartbeats, no new jobs will be sent", id, missed)
+			}
+
+			if state == workerDead {
+				log.Printf("Worker %s has missed %d heartbeats, removing it", id, missed)
+				removeWorker(id.(string))
+			}
+
+			return true
+		})
+	}
+}
+
+// Check if a worker is healthy enough to be sent new jobs
+func (w WorkerConnection) isAlive() bool {
+	w.Health.lock.Lock()
+	defer w.Health.lock.Unlock()
+
+	return w.Health.State == workerAlive
+}
+
+// Check if a worker is registered and still sending heartbeats
+func isWorkerAlive(workerID string) bool {
+	w, ok := workers.Load(workerID)
+	return ok && w.(WorkerConnection).isAlive()
+}
+
+// Check if a worker has room for another job, beyond not running its maximum
+// Pull mode workers ask for jobs as they have room, so they are only sent what they asked for
+func (w WorkerConnection) wantsJobs() bool {
+	pull, ok := w.Client.(*pullClient)
+	return !ok || pull.wantsJobs()
+}
+
+// Close the connection to a worker, pull mode workers have their job stream ended
+func (w WorkerConnection) close() {
+	if w.Conn != nil {
+		w.Conn.Close()
+	}
+
+	if pull, ok := w.Client.(*pullClient); ok {
+		pull.stop()
+	}
+}
+
+// Drop a worker which has gone away, and reissue any jobs it was running
+func removeWorker(workerID string) {
+	w, ok := workers.LoadAndDelete(workerID)
+	if !ok {
+		return
+	}
+
+	w.(WorkerConnection).close()
+
+	workerCountLock.Lock()
+	workerCount--
+	log.Printf("Workers online: %d", workerCount)
+	workerCountLock.Unlock()
+
+	requeueWorker(workerID)
+}
+
+// Put any jobs a lost worker was running back on the queue and reissue them
+// The worker no longer holds any scenes, so it must be prepared again if it returns
+func requeueWorker(workerID string) {
+	count := 0
+
+	for _, nr := range activeRenders() {
+		nr.Lock.Lock()
+		count += nr.RequeueWorker(workerID)
+		delete(nr.Prepared, workerID)
+		nr.Lock.Unlock()
+	}
+
+	if count > 0 {
+		log.Printf("Requeued %d jobs from worker %s", count, workerID)
+		dispatchJobs()
+	}
+}
+
 func (s *server) GetWorkers(ctx context.Context, in *pb.Void) (*pb.WorkerList, error) {
 	workerList := []*pb.WorkerInfo{}
 
 	workers.Range(func(key, value interface{}) bool {
-		workerList = append(workerList, value.(WorkerConnection).Worker)
+		w := value.(WorkerConnection)
+
+		w.Health.lock.Lock()
+		workerList = append(workerList, &pb.WorkerInfo{
+			Id:       w.Worker.Id,
+			Host:     w.Worker.Host,
+			Port:     w.Worker.Port,
+			Index:    w.Worker.Index,
+			MaxJobs:  w.Worker.MaxJobs,
+			State:    w.Health.State,
+			LastSeen: timestamppb.New(w.Health.LastSeen),
+			Load:     w.Health.Load,
+		})
+		w.Health.lock.Unlock()
+
 		return true
 	})
 
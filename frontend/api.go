@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
+	"io"
 	"log"
 	"nanoray/lib/controller"
 	"nanoray/lib/proto"
 	"net/http"
 	"strconv"
 
-	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -23,10 +24,11 @@ func addAPIRoutes(mux *http.ServeMux, templates *HTMLRenderer) {
 	})
 
 	mux.HandleFunc("GET /api/render/progress", func(w http.ResponseWriter, r *http.Request) {
-		data, _ := controller.Client.GetProgress(r.Context(), nil)
+		// No render ID gives the most recently started render
+		data, _ := controller.Client.GetProgress(r.Context(), wrapperspb.String(r.URL.Query().Get("id")))
 
-		// Special case for when the render is complete
-		if data.CompletedJobs == data.TotalJobs {
+		// Special case for when the render is complete, finished early or has been cancelled
+		if data.CompletedJobs == data.TotalJobs || data.State == "complete" || data.State == "cancelled" {
 			_ = templates.Render(w, "api/render-end", data)
 			return
 		}
@@ -37,19 +39,49 @@ func addAPIRoutes(mux *http.ServeMux, templates *HTMLRenderer) {
 	mux.HandleFunc("POST /api/render", func(w http.ResponseWriter, r *http.Request) {
 		sceneData := r.FormValue("sceneData")
 
+		// A bundle holds its own scene file, which is used instead of the editor
+		var assets []*proto.Asset
+		if file, _, err := r.FormFile("bundle"); err == nil {
+			defer file.Close()
+
+			bundle, err := uploadBundle(r.Context(), file)
+			if err != nil {
+				log.Println("Failed to upload bundle: ", err)
+				http.Error(w, "Bundle upload failed: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			sceneData = bundle.SceneData
+			assets = bundle.Assets
+		}
+
 		width, _ := strconv.Atoi(r.FormValue("width"))
 		depth, _ := strconv.Atoi(r.FormValue("depth"))
-		slices, _ := strconv.Atoi(r.FormValue("slices"))
+		tileSize, _ := strconv.Atoi(r.FormValue("tileSize"))
 		aspectRatio, _ := strconv.ParseFloat(r.FormValue("aspect"), 64)
 		samplesPerPixel, _ := strconv.Atoi(r.FormValue("samples"))
+		passSamples, _ := strconv.Atoi(r.FormValue("passSamples"))
+		timeLimit, _ := strconv.Atoi(r.FormValue("timeLimit"))
+		noise, _ := strconv.ParseFloat(r.FormValue("noise"), 64)
+		seed, _ := strconv.ParseInt(r.FormValue("seed"), 10, 64)
 
-		_, err := controller.Client.StartRender(r.Context(), &proto.RenderRequest{
+		renderID, err := controller.Client.StartRender(r.Context(), &proto.RenderRequest{
 			SceneData:       sceneData,
 			Width:           int32(width),
 			AspectRatio:     aspectRatio,
 			SamplesPerPixel: int32(samplesPerPixel),
 			MaxDepth:        int32(depth),
-			Slices:          int32(slices),
+			TileWidth:       int32(tileSize),
+			TileHeight:      int32(tileSize),
+			TileOrder:       r.FormValue("tileOrder"),
+			Assets:          assets,
+			PassSamples:     int32(passSamples),
+			TimeLimit:       int32(timeLimit),
+			NoiseThreshold:  noise,
+			Heatmap:         r.FormValue("heatmap") == "true",
+			Seed:            seed,
+			Sampler:         r.FormValue("sampler"),
+			Filter:          r.FormValue("filter"),
 		})
 
 		if err != nil {
@@ -58,7 +90,7 @@ func addAPIRoutes(mux *http.ServeMux, templates *HTMLRenderer) {
 			return
 		}
 
-		_ = templates.Render(w, "api/render-start", nil)
+		_ = templates.Render(w, "api/render-start", &proto.Progress{RenderID: renderID.Value})
 	})
 
 	mux.HandleFunc("GET /api/render", func(w http.ResponseWriter, r *http.Request) {
@@ -67,17 +99,119 @@ func addAPIRoutes(mux *http.ServeMux, templates *HTMLRenderer) {
 		_ = templates.Render(w, "api/renders", data)
 	})
 
+	mux.HandleFunc("GET /api/render/active", func(w http.ResponseWriter, r *http.Request) {
+		data, err := controller.Client.ListRenders(r.Context(), &proto.Void{})
+		if err != nil {
+			http.Error(w, "Failed to list renders", http.StatusInternalServerError)
+			return
+		}
+
+		_ = templates.Render(w, "api/render-list", data)
+	})
+
+	mux.HandleFunc("POST /api/render/{id}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		id := wrapperspb.String(r.PathValue("id"))
+
+		var err error
+		switch r.PathValue("action") {
+		case "cancel":
+			_, err = controller.Client.CancelRender(r.Context(), id)
+		case "pause":
+			_, err = controller.Client.PauseRender(r.Context(), id)
+		case "resume":
+			_, err = controller.Client.ResumeRender(r.Context(), id)
+		case "finish":
+			_, err = controller.Client.FinishRender(r.Context(), id)
+		default:
+			http.Error(w, "Unknown render action", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			log.Printf("Failed to %s render %s: %s", r.PathValue("action"), id.Value, err)
+			http.Error(w, "Render "+r.PathValue("action")+" failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, _ := controller.Client.ListRenders(r.Context(), &proto.Void{})
+		_ = templates.Render(w, "api/render-list", data)
+	})
+
+	mux.HandleFunc("GET /api/render/{id}/preview", func(w http.ResponseWriter, r *http.Request) {
+		stream, err := controller.Client.GetPreview(r.Context(), wrapperspb.String(r.PathValue("id")))
+		if err != nil {
+			http.Error(w, "Failed to get render preview "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		streamImage(w, stream)
+	})
+
 	mux.HandleFunc("GET /api/render/{name}", func(w http.ResponseWriter, r *http.Request) {
 		name := r.PathValue("name")
-		// Set max message size to 64MB
-		maxSizeOption := grpc.MaxCallRecvMsgSize(64 * 10e6)
-		data, err := controller.Client.GetRenderedImage(r.Context(), wrapperspb.String(name), maxSizeOption)
+		stream, err := controller.Client.GetRenderedImage(r.Context(), wrapperspb.String(name))
 		if err != nil {
 			http.Error(w, "Failed to get rendered image "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Content-Type", "image/png")
-		_, _ = w.Write(data.Value)
+		streamImage(w, stream)
 	})
 }
+
+// Any of the controller streams sending a file in chunks
+type bytesStream interface {
+	Recv() (*wrapperspb.BytesValue, error)
+}
+
+// Copy a PNG image streamed from the controller in chunks to the response
+func streamImage(w http.ResponseWriter, stream bytesStream) {
+	// Errors such as a missing image only show up on the first receive
+	chunk, err := stream.Recv()
+	if err != nil {
+		http.Error(w, "Failed to get image "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+
+	for err == nil {
+		_, _ = w.Write(chunk.Value)
+		chunk, err = stream.Recv()
+	}
+
+	if err != io.EOF {
+		log.Printf("Image stream failed: %s", err)
+	}
+}
+
+// Size of each message when uploading a bundle to the controller
+const uploadChunkSize = 1 << 20
+
+// Stream a scene bundle to the controller, which unpacks & stores the assets
+func uploadBundle(ctx context.Context, r io.Reader) (*proto.Bundle, error) {
+	stream, err := controller.Client.UploadBundle(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, uploadChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if sendErr := stream.Send(wrapperspb.Bytes(buf[:n])); sendErr != nil {
+				// The real error comes back from CloseAndRecv
+				break
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stream.CloseAndRecv()
+}
@@ -2,7 +2,9 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"time"
 
@@ -15,7 +17,14 @@ import (
 
 var Client pb.ControllerClient
 
-// Connect to the controller, waiting for the connection to be ready
+// Limits for the delay between retries when the controller can't be reached
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// Connect to the controller, retrying with backoff until the connection is ready
+// Gives up after waitTime, or keeps trying forever when waitTime is zero
 func Connect(waitTime time.Duration) error {
 	controllerAddr := os.Getenv("CONTROLLER_ADDR")
 	if controllerAddr == "" {
@@ -29,23 +38,56 @@ func Connect(waitTime time.Duration) error {
 		return err
 	}
 
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), waitTime)
-		defer cancel()
-
-		change := conn.WaitForStateChange(ctx, conn.GetState())
-		if !change {
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
+	start := time.Now()
+	for attempt := 0; !waitForReady(conn, maxBackoff); attempt++ {
+		if waitTime > 0 && time.Since(start) > waitTime {
+			conn.Close()
+			return fmt.Errorf("controller at %s not ready after %s", controllerAddr, waitTime)
 		}
 
-		if conn.GetState() == connectivity.Ready {
-			break
-		}
+		delay := Backoff(attempt)
+		log.Printf("Controller not ready, retrying in %s", delay)
+		time.Sleep(delay)
 	}
 
 	log.Printf("Connected to controller")
 	Client = pb.NewControllerClient(conn)
 	return nil
 }
+
+// Wait for the connection to become ready, returns false if it doesn't within the timeout
+func waitForReady(conn *grpc.ClientConn, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Kick the connection out of idle or a previous failure
+	conn.Connect()
+
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return true
+		}
+
+		if !conn.WaitForStateChange(ctx, state) {
+			return false
+		}
+
+		if conn.GetState() == connectivity.TransientFailure {
+			return false
+		}
+	}
+}
+
+// Delay before the given retry attempt, doubling each time up to a limit
+// Some jitter is added so many workers don't all retry at the same moment
+func Backoff(attempt int) time.Duration {
+	delay := maxBackoff
+	if attempt < 16 {
+		delay = min(minBackoff<<attempt, maxBackoff)
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay) / 5))
+
+	return delay + jitter
+}
@@ -0,0 +1,176 @@
+package raytrace
+
+import (
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"log"
+	"math"
+
+	"nanoray/lib/proto"
+	t "nanoray/lib/tuples"
+)
+
+const (
+	DefaultMinSamples = 4 // Used when adaptive sampling is on, but no minimum is given
+	adaptiveBatch     = 4 // Samples added at a time to a pixel that is still noisy
+)
+
+// Running totals for one pixel, luminance variance is tracked with Welford's method
+type pixelStats struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+func (p *pixelStats) add(sample t.RGB) {
+	p.n++
+
+	lum := luminance(sample)
+	delta := lum - p.mean
+	p.mean += delta / float64(p.n)
+	p.m2 += delta * (lum - p.mean)
+}
+
+// -
+// Estimate of the noise left in the pixel, as the standard error of the mean relative
+// to its brightness. Dark pixels are floored, so they don't soak up samples
+// -
+func (p *pixelStats) noise() float64 {
+	if p.n < 2 {
+		return math.Inf(1)
+	}
+
+	stdErr := math.Sqrt(p.m2 / float64(p.n-1) / float64(p.n))
+	return stdErr / math.Max(p.mean, 0.01)
+}
+
+// -
+// Resolve the adaptive sample limits for a job, filling in defaults
+// -
+func adaptiveLimits(job *proto.JobRequest) (int, int) {
+	spp := int(job.SamplesPerPixel)
+
+	minSamples := int(job.MinSamples)
+	if minSamples <= 0 {
+		minSamples = min(DefaultMinSamples, spp)
+	}
+
+	// Samples per pixel is a budget, adaptive sampling only spends less of it
+	maxSamples := int(job.MaxSamples)
+	if maxSamples <= 0 {
+		maxSamples = spp
+	}
+
+	return max(minSamples, 1), max(maxSamples, minSamples, 1)
+}
+
+// -
+// Render a job spending samples where they are needed. Every pixel gets the minimum,
+// then more in batches until its noise is below the threshold or it reaches the max
+// Each pixel stops on its own noise alone, so the samples it takes don't depend on how
+// the image was split into jobs, and seeded renders stay repeatable
+// Pixels in the film's apron around the tile are included, as their samples spill into it
+// -
+func renderAdaptive(ctx context.Context, job *proto.JobRequest, s Scene, c Camera, smp Sampler, film *tileFilm) ([]float32, []int32, error) {
+	minSamples, maxSamples := adaptiveLimits(job)
+	counts := make([]int32, 0, film.tile.Dx()*film.tile.Dy())
+
+	for y := film.region.Min.Y; y < film.region.Max.Y; y++ {
+		if ctx.Err() != nil {
+			log.Printf("Job %d cancelled", job.Id)
+			return nil, nil, ctx.Err()
+		}
+
+		for x := film.region.Min.X; x < film.region.Max.X; x++ {
+			var stats pixelStats
+
+			for target := minSamples; stats.n < target; {
+				filmX, filmY, radiance := renderSample(job, s, c, smp, x, y, int(job.SampleOffset)+stats.n)
+				stats.add(radiance)
+				film.addSample(filmX, filmY, radiance)
+
+				// Check the noise after each batch, and carry on if it's still too high
+				if stats.n == target && stats.n < maxSamples && stats.noise() > job.NoiseThreshold {
+					target = min(target+adaptiveBatch, maxSamples)
+				}
+			}
+
+			if image.Pt(x, y).In(film.tile) {
+				counts = append(counts, int32(stats.n))
+			}
+		}
+	}
+
+	return film.radiance(), counts, nil
+}
+
+// -
+// Encode per pixel sample counts for sending, as little endian uint32 values
+// -
+func EncodeSampleCounts(counts []int32) []byte {
+	data := make([]byte, len(counts)*4)
+	for i, n := range counts {
+		binary.LittleEndian.PutUint32(data[i*4:], uint32(n))
+	}
+
+	return data
+}
+
+// -
+// Decode sample counts sent by EncodeSampleCounts, checking they match the tile size
+// -
+func DecodeSampleCounts(data []byte, width, height int) ([]int32, error) {
+	if len(data) != width*height*4 {
+		return nil, ErrTileSize
+	}
+
+	counts := make([]int32, width*height)
+	for i := range counts {
+		counts[i] = int32(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+
+	return counts, nil
+}
+
+// -
+// Copy a tile of sample counts into counts for the whole image, nil tile counts
+// mean every pixel had the same number of samples
+// -
+func AddSampleCounts(counts []int32, imgW int, job *proto.JobRequest, tile []int32) {
+	for y := 0; y < int(job.Height); y++ {
+		for x := 0; x < int(job.Width); x++ {
+			n := job.SamplesPerPixel
+			if tile != nil {
+				n = tile[y*int(job.Width)+x]
+			}
+
+			counts[(int(job.Y)+y)*imgW+int(job.X)+x] = n
+		}
+	}
+}
+
+// -
+// Visualise samples per pixel, scaled to the most sampled pixel, going from
+// black through red and yellow to white
+// -
+func SampleHeatmap(counts []int32, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	maxCount := int32(1)
+	for _, n := range counts {
+		maxCount = max(maxCount, n)
+	}
+
+	ramp := func(v float64) uint8 {
+		return uint8(math.Min(1, math.Max(0, v)) * 255)
+	}
+
+	for i, n := range counts {
+		v := float64(n) / float64(maxCount) * 3
+		img.Set(i%width, i/width, color.RGBA{ramp(v), ramp(v - 1), ramp(v - 2), 255})
+	}
+
+	return img
+}
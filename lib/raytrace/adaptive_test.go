@@ -0,0 +1,117 @@
+package raytrace
+
+import (
+	"context"
+	"testing"
+
+	"nanoray/lib/proto"
+)
+
+func TestAdaptiveLimits(t *testing.T) {
+	cases := []struct {
+		name     string
+		spp      int32
+		min, max int32
+		wantMin  int
+		wantMax  int
+	}{
+		{"defaults", 64, 0, 0, DefaultMinSamples, 64},
+		{"fewer samples than the default minimum", 2, 0, 0, 2, 2},
+		{"minimum given", 64, 16, 0, 16, 64},
+		{"maximum given", 64, 0, 256, DefaultMinSamples, 256},
+		{"maximum below the minimum", 64, 16, 8, 16, 16},
+		{"minimum above samples per pixel", 8, 16, 0, 16, 16},
+		{"no samples", 0, 0, 0, 1, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			job := &proto.JobRequest{SamplesPerPixel: tc.spp, MinSamples: tc.min, MaxSamples: tc.max}
+
+			if gotMin, gotMax := adaptiveLimits(job); gotMin != tc.wantMin || gotMax != tc.wantMax {
+				t.Errorf("limits are %d to %d, want %d to %d", gotMin, gotMax, tc.wantMin, tc.wantMax)
+			}
+		})
+	}
+}
+
+const flatScene = `
+name: Flat
+background: [0.5, 0.5, 0.5]
+
+camera:
+  position: [0, 0, 10]
+  lookAt: [0, 0, 0]
+  fov: 40
+
+objects:
+  - type: sphere
+    position: [0, 0, -1000]
+    radius: 1
+    material:
+      diffuse:
+        albedo: [0.5, 0.5, 0.5]
+`
+
+func TestRenderAdaptiveStopsEarly(t *testing.T) {
+	cases := []struct {
+		name     string
+		scene    string
+		noise    float64
+		allMin   bool // Every pixel should stop at the minimum
+		someMax  bool // At least one pixel should reach the maximum
+		someLess bool // At least one pixel should stop before the maximum
+	}{
+		{"flat background", flatScene, 0.02, true, false, true},
+		{"noisy scene, loose threshold", testScene, 0.05, false, false, true},
+		// The background has no noise, so it still stops early
+		{"noisy scene, tight threshold", testScene, 1e-9, false, true, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			render := NewRender(32, 4.0/3)
+			render.SamplesPerPixel = 32
+			render.MinSamples = 4
+			render.NoiseThreshold = tc.noise
+			render.Seed = 1
+
+			scene, camera, err := ParseScene(tc.scene, render.Width, render.Height, DirResolver("."))
+			if err != nil {
+				t.Fatalf("parsing scene: %v", err)
+			}
+
+			tile := Tile{X: 0, Y: 0, Width: render.Width, Height: render.Height}
+			_, counts, err := RenderRadiance(context.Background(), render.NewJob(0, tile), *scene, *camera)
+			if err != nil {
+				t.Fatalf("rendering: %v", err)
+			}
+
+			if len(counts) != render.Width*render.Height {
+				t.Fatalf("got %d sample counts, want one per pixel", len(counts))
+			}
+
+			less, reachedMax := false, false
+			for i, n := range counts {
+				if n < 4 || n > 32 {
+					t.Fatalf("pixel %d took %d samples, outside 4 to 32", i, n)
+				}
+
+				if tc.allMin && n != 4 {
+					t.Fatalf("pixel %d took %d samples, want the minimum", i, n)
+				}
+
+				less = less || n < 32
+				reachedMax = reachedMax || n == 32
+			}
+
+			if tc.someMax && !reachedMax {
+				t.Error("no pixel reached the maximum samples")
+			}
+
+			if less != tc.someLess {
+				t.Errorf("some pixels stopped early is %v, want %v", less, tc.someLess)
+			}
+		})
+	}
+}
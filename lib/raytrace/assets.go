@@ -0,0 +1,323 @@
+package raytrace
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"nanoray/lib/proto"
+)
+
+// AssetResolver opens files referenced by a scene, such as meshes, textures & HDRIs
+// Names are exactly as written in the scene file
+type AssetResolver interface {
+	Open(name string) (io.ReadCloser, error)
+}
+
+// DirResolver resolves asset names on the local filesystem, relative to a directory
+type DirResolver string
+
+func (d DirResolver) Open(name string) (io.ReadCloser, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(string(d), name)
+	}
+
+	return os.Open(name)
+}
+
+// AssetManifest maps asset names, as used in the scene, to the content hash of the file
+type AssetManifest map[string]string
+
+// -
+// Convert a manifest to protobuf assets, sorted by name so the order is stable
+// -
+func (m AssetManifest) Proto() []*proto.Asset {
+	assets := make([]*proto.Asset, 0, len(m))
+	for name, digest := range m {
+		assets = append(assets, &proto.Asset{Path: name, Digest: digest})
+	}
+
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].Path < assets[j].Path
+	})
+
+	return assets
+}
+
+// -
+// Convert protobuf assets to a manifest, names are cleaned so lookups match the scene
+// -
+func ManifestFromProto(assets []*proto.Asset) AssetManifest {
+	m := AssetManifest{}
+	for _, a := range assets {
+		m[cleanAssetName(a.Path)] = a.Digest
+	}
+
+	return m
+}
+
+func cleanAssetName(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// BlobStore is a directory of files each named by the content hash of the data
+type BlobStore struct {
+	Dir string
+}
+
+// -
+// Open a blob store, creating the directory if needed
+// -
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &BlobStore{Dir: dir}, nil
+}
+
+// -
+// File path of a blob, digests are checked so they can't escape the store
+// -
+func (b *BlobStore) path(digest string) (string, error) {
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 64 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDigest, digest)
+	}
+
+	return filepath.Join(b.Dir, digest), nil
+}
+
+// -
+// Check if the store holds a blob
+// -
+func (b *BlobStore) Has(digest string) bool {
+	p, err := b.path(digest)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// -
+// Add data to the store, returning its digest
+// Written to a temp file first, so a blob is never seen half written
+// -
+func (b *BlobStore) Put(data []byte) (string, error) {
+	digest := ContentHash(data)
+	if b.Has(digest) {
+		return digest, nil
+	}
+
+	f, err := os.CreateTemp(b.Dir, "upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	p, _ := b.path(digest)
+	return digest, os.Rename(f.Name(), p)
+}
+
+// -
+// Open a blob for reading
+// -
+func (b *BlobStore) Open(digest string) (io.ReadCloser, error) {
+	p, err := b.path(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p)
+}
+
+// ManifestResolver resolves asset names through a manifest to blobs in a store
+type ManifestResolver struct {
+	Manifest AssetManifest
+	Store    *BlobStore
+}
+
+func (r ManifestResolver) Open(name string) (io.ReadCloser, error) {
+	digest, ok := r.Manifest[cleanAssetName(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAssetNotFound, name)
+	}
+
+	return r.Store.Open(digest)
+}
+
+// Upper limit on the size of a bundle, both as uploaded and once its files are unpacked
+// It's all held in memory while unpacking
+const MaxBundleSize = 1 << 30
+
+// Bundle is a scene file packed with the assets it references
+type Bundle struct {
+	SceneData string
+	Files     map[string][]byte // Keyed by name relative to the scene file
+}
+
+// -
+// Read a zip, tar or gzipped tar bundle. It must hold a single scene YAML file,
+// or have one named scene.yaml at the top level if there are several
+// -
+func ReadBundle(data []byte) (*Bundle, error) {
+	files, err := readArchive(data, MaxBundleSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+
+	var candidates []string
+	for name := range files {
+		ext := strings.ToLower(path.Ext(name))
+		if ext == ".yaml" || ext == ".yml" {
+			candidates = append(candidates, name)
+		}
+	}
+
+	sceneName := ""
+	if len(candidates) == 1 {
+		sceneName = candidates[0]
+	} else {
+		for _, name := range candidates {
+			if name == "scene.yaml" || name == "scene.yml" {
+				sceneName = name
+			}
+		}
+	}
+
+	if sceneName == "" {
+		return nil, ErrNoBundleScene
+	}
+
+	bundle := &Bundle{
+		SceneData: string(files[sceneName]),
+		Files:     map[string][]byte{},
+	}
+
+	// Scenes refer to assets relative to where the scene file is
+	sceneDir := path.Dir(sceneName)
+	for name, content := range files {
+		if name == sceneName {
+			continue
+		}
+
+		rel, err := filepath.Rel(filepath.FromSlash(sceneDir), filepath.FromSlash(name))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+		}
+
+		bundle.Files[filepath.ToSlash(rel)] = content
+	}
+
+	return bundle, nil
+}
+
+func (b *Bundle) Open(name string) (io.ReadCloser, error) {
+	content, ok := b.Files[cleanAssetName(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAssetNotFound, name)
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// -
+// Read all regular files from a zip or tar archive, tar can be gzip compressed
+// Stops once the files add up to more than the limit, however small the archive
+// -
+func readArchive(data []byte, limit int64) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	total := int64(0)
+
+	add := func(name string, r io.Reader) error {
+		name = cleanAssetName(name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("file outside of bundle: %s", name)
+		}
+
+		// Read one byte past the limit, to tell a file that fits exactly from one that doesn't
+		content, err := io.ReadAll(io.LimitReader(r, limit-total+1))
+		total += int64(len(content))
+		if total > limit {
+			return fmt.Errorf("files unpack to more than %d bytes", limit)
+		}
+
+		files[name] = content
+
+		return err
+	}
+
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+
+			err = add(f.Name, r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return files, nil
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := add(hdr.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+}
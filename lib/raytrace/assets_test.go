@@ -0,0 +1,349 @@
+package raytrace
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Archive entry for building test bundles
+type testEntry struct {
+	name    string
+	content string
+}
+
+func makeZip(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Write([]byte(e.content))
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func makeTar(t *testing.T, entries []testEntry, compress bool) []byte {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	var w io.Writer = &buf
+
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		tw.Write([]byte(e.content))
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if gz != nil {
+		gz.Close()
+	}
+
+	return buf.Bytes()
+}
+
+// Build the same entries as every archive format readArchive accepts
+func makeArchives(t *testing.T, entries []testEntry) map[string][]byte {
+	return map[string][]byte{
+		"zip":    makeZip(t, entries),
+		"tar":    makeTar(t, entries, false),
+		"tar.gz": makeTar(t, entries, true),
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testEntry
+		limit   int64 // Unpacked size limit, MaxBundleSize when zero
+		want    map[string][]byte
+		wantErr string
+	}{
+		{
+			name:    "plain files",
+			entries: []testEntry{{"scene.yaml", "name: x"}, {"models/cube.obj", "v 0 0 0"}},
+			want:    map[string][]byte{"scene.yaml": []byte("name: x"), "models/cube.obj": []byte("v 0 0 0")},
+		},
+		{
+			name:    "names are cleaned",
+			entries: []testEntry{{"./a/../b.png", "b"}, {"c//d.png", "d"}},
+			want:    map[string][]byte{"b.png": []byte("b"), "c/d.png": []byte("d")},
+		},
+		{
+			name:    "parent directory",
+			entries: []testEntry{{"scene.yaml", "name: x"}, {"../x", "evil"}},
+			wantErr: "outside of bundle",
+		},
+		{
+			name:    "parent directory in the middle",
+			entries: []testEntry{{"models/../../x", "evil"}},
+			wantErr: "outside of bundle",
+		},
+		{
+			name:    "absolute path",
+			entries: []testEntry{{"/etc/x", "evil"}},
+			wantErr: "outside of bundle",
+		},
+		{
+			name:    "parent only",
+			entries: []testEntry{{"..", "evil"}},
+			wantErr: "outside of bundle",
+		},
+		{
+			name:    "exactly the limit",
+			entries: []testEntry{{"a.png", strings.Repeat("a", 40)}, {"b.png", strings.Repeat("b", 24)}},
+			limit:   64,
+			want:    map[string][]byte{"a.png": []byte(strings.Repeat("a", 40)), "b.png": []byte(strings.Repeat("b", 24))},
+		},
+		{
+			name:    "one file over the limit",
+			entries: []testEntry{{"a.png", strings.Repeat("a", 65)}},
+			limit:   64,
+			wantErr: "more than 64 bytes",
+		},
+		{
+			name:    "files add up over the limit",
+			entries: []testEntry{{"a.png", strings.Repeat("a", 40)}, {"b.png", strings.Repeat("b", 25)}},
+			limit:   64,
+			wantErr: "more than 64 bytes",
+		},
+		{
+			name:    "compresses far below the limit",
+			entries: []testEntry{{"zeros.hdr", strings.Repeat("\x00", 1<<20)}},
+			limit:   1 << 16,
+			wantErr: "more than 65536 bytes",
+		},
+	}
+
+	for _, test := range tests {
+		limit := test.limit
+		if limit == 0 {
+			limit = MaxBundleSize
+		}
+
+		for format, data := range makeArchives(t, test.entries) {
+			files, err := readArchive(data, limit)
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("%s %s: got error %v, want %q", test.name, format, err, test.wantErr)
+				}
+
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%s %s: %v", test.name, format, err)
+				continue
+			}
+
+			if !reflect.DeepEqual(files, test.want) {
+				t.Errorf("%s %s: got files %v, want %v", test.name, format, files, test.want)
+			}
+		}
+	}
+
+	if _, err := readArchive([]byte("not an archive at all, but long enough to not be a short read of a tar header"), MaxBundleSize); err == nil {
+		t.Error("garbage data read as an archive")
+	}
+}
+
+func TestReadBundle(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testEntry
+		scene   string
+		files   []string
+		wantErr error
+	}{
+		{
+			name:    "single scene",
+			entries: []testEntry{{"room.yml", "name: room"}, {"tex/wood.png", "png"}},
+			scene:   "name: room",
+			files:   []string{"tex/wood.png"},
+		},
+		{
+			name:    "scene in a folder",
+			entries: []testEntry{{"room/scene.yaml", "name: room"}, {"room/tex/wood.png", "png"}, {"shared/sky.hdr", "hdr"}},
+			scene:   "name: room",
+			files:   []string{"../shared/sky.hdr", "tex/wood.png"},
+		},
+		{
+			name:    "several scenes with scene.yaml",
+			entries: []testEntry{{"scene.yaml", "name: main"}, {"other.yaml", "name: other"}},
+			scene:   "name: main",
+			files:   []string{"other.yaml"},
+		},
+		{
+			name:    "several scenes",
+			entries: []testEntry{{"a.yaml", "name: a"}, {"b.yaml", "name: b"}},
+			wantErr: ErrNoBundleScene,
+		},
+		{
+			name:    "no scene",
+			entries: []testEntry{{"model.obj", "v 0 0 0"}},
+			wantErr: ErrNoBundleScene,
+		},
+		{
+			name:    "escaping file",
+			entries: []testEntry{{"scene.yaml", "name: x"}, {"../../etc/passwd", "evil"}},
+			wantErr: ErrInvalidBundle,
+		},
+	}
+
+	for _, test := range tests {
+		for format, data := range makeArchives(t, test.entries) {
+			bundle, err := ReadBundle(data)
+
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("%s %s: got error %v, want %v", test.name, format, err, test.wantErr)
+				}
+
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%s %s: %v", test.name, format, err)
+				continue
+			}
+
+			if bundle.SceneData != test.scene {
+				t.Errorf("%s %s: scene is %q, want %q", test.name, format, bundle.SceneData, test.scene)
+			}
+
+			names := []string{}
+			for name := range bundle.Files {
+				names = append(names, name)
+			}
+
+			if len(names) != len(test.files) {
+				t.Errorf("%s %s: files are %v, want %v", test.name, format, names, test.files)
+			}
+
+			// Assets open by the name the scene uses for them
+			for _, name := range test.files {
+				r, err := bundle.Open(name)
+				if err != nil {
+					t.Errorf("%s %s: %v", test.name, format, err)
+					continue
+				}
+
+				r.Close()
+			}
+		}
+	}
+
+	bundle, _ := ReadBundle(makeZip(t, []testEntry{{"scene.yaml", "name: x"}}))
+	if _, err := bundle.Open("missing.png"); !errors.Is(err, ErrAssetNotFound) {
+		t.Errorf("missing asset gave error %v", err)
+	}
+}
+
+func TestBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBlobStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("some mesh data")
+	digest, err := store.Put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != ContentHash(data) || !store.Has(digest) {
+		t.Fatalf("stored blob %s is missing", digest)
+	}
+
+	// Storing again is a no-op with the same digest
+	if again, err := store.Put(data); err != nil || again != digest {
+		t.Errorf("second put gave %s, %v", again, err)
+	}
+
+	r, err := store.Open(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("blob content is %q", got)
+	}
+
+	// No temp files are left behind
+	entries, _ := os.ReadDir(store.Dir)
+	if len(entries) != 1 {
+		t.Errorf("store holds %d files, want 1", len(entries))
+	}
+
+	// A file outside the store that a bad digest might try to reach
+	os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644)
+
+	invalid := []string{
+		"",
+		"abc",
+		"../secret",
+		"../../../../etc/passwd",
+		strings.Repeat("g", 64),
+		strings.Repeat("a", 63),
+		strings.Repeat("a", 65),
+		"../" + digest[3:],
+		"/" + digest[1:],
+	}
+
+	for _, bad := range invalid {
+		if store.Has(bad) {
+			t.Errorf("store has invalid digest %q", bad)
+		}
+
+		if _, err := store.Open(bad); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("open %q gave error %v, want %v", bad, err, ErrInvalidDigest)
+		}
+	}
+
+	resolver := ManifestResolver{Manifest: AssetManifest{"models/mesh.obj": digest}, Store: store}
+	if r, err := resolver.Open("./models//mesh.obj"); err != nil {
+		t.Errorf("manifest lookup failed: %v", err)
+	} else {
+		r.Close()
+	}
+
+	if _, err := resolver.Open("other.obj"); !errors.Is(err, ErrAssetNotFound) {
+		t.Errorf("unknown asset gave error %v", err)
+	}
+}
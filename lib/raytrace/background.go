@@ -0,0 +1,227 @@
+package raytrace
+
+import (
+	"math"
+	t "nanoray/lib/tuples"
+)
+
+// Background gives the light arriving from rays that miss every object
+type Background interface {
+	// Radiance arriving from the given direction, which need not be normalized
+	colour(dir t.Vec3) t.RGB
+
+	Type() string
+}
+
+// SampledBackground is a background that can be sampled for direct lighting
+// Other backgrounds still light the scene, but only via rays that miss by chance
+type SampledBackground interface {
+	Background
+
+	// Sample a normalized direction towards the background, and its solid angle pdf
+	sampleDir(smp Sampler) (t.Vec3, float64)
+
+	// Solid angle pdf that sampleDir would have picked the given direction
+	pdfDir(dir t.Vec3) float64
+}
+
+// -
+// Direct lighting from the background, sample a direction and shoot a shadow ray
+// The result is weighted against the chance the BSDF sampled ray misses in the
+// same direction when misWeight is set, as with sampleEmitters
+// -
+func (s Scene) sampleBackground(r Ray, hit Hit, misWeight bool, smp Sampler) t.RGB {
+	bg, ok := s.Background.(SampledBackground)
+	if !ok {
+		return t.Black()
+	}
+
+	dir, bgPdf := bg.sampleDir(smp)
+	if bgPdf <= 0 {
+		return t.Black()
+	}
+
+	// Zero pdf means a specular material, or the direction is behind the surface
+	bsdfPdf := hit.Obj.Material.pdf(r, hit, dir)
+	if bsdfPdf <= 0 {
+		return t.Black()
+	}
+
+	shadowRay := NewRay(hit.Pos, dir)
+	if s.closestHit(shadowRay, Interval{0.001, math.MaxFloat64}) != nil {
+		return t.Black()
+	}
+
+	weight := 1.0
+	if misWeight {
+		weight = powerHeuristic(bgPdf, bsdfPdf)
+	}
+
+	radiance := bg.colour(dir)
+	radiance.Mult(hit.Obj.Material.eval(r, hit, dir))
+	radiance.MultScalar(weight / bgPdf)
+
+	return radiance
+}
+
+// -
+// Light from the background for a ray that missed everything, weighted with MIS
+// when the background was also sampled directly at the previous bounce
+// -
+func (s Scene) missColour(r Ray, bsdfPdf float64) t.RGB {
+	colour := s.Background.colour(r.Dir)
+
+	if bg, ok := s.Background.(SampledBackground); ok && bsdfPdf > 0 {
+		colour.MultScalar(powerHeuristic(bsdfPdf, bg.pdfDir(r.Dir)))
+	}
+
+	return colour
+}
+
+// ============================================================
+// Solid background, a single flat colour in every direction
+// ============================================================
+
+type SolidBackground struct {
+	Colour t.RGB
+}
+
+func (b SolidBackground) colour(dir t.Vec3) t.RGB {
+	return b.Colour
+}
+
+func (b SolidBackground) Type() string {
+	return "solid"
+}
+
+// ============================================================
+// Environment map, an equirectangular HDR image surrounding the scene
+// ============================================================
+
+type EnvironmentMap struct {
+	Image     *HDRImage
+	Rotation  float64 // Rotation in degrees around the Y axis
+	Intensity float64
+
+	sinRot, cosRot float64
+	dist           distribution2D // Importance sampling by brightness
+}
+
+// -
+// Create a new environment map, the centre of the image faces down -Z
+// -
+func NewEnvironmentMap(image *HDRImage, rotation, intensity float64) *EnvironmentMap {
+	rad := rotation * math.Pi / 180.0
+
+	// Brightness of each pixel, scaled by sin(theta) to account for the
+	// squashing of the image towards the poles
+	values := make([]float64, image.Width*image.Height)
+	for y := 0; y < image.Height; y++ {
+		sinTheta := math.Sin(math.Pi * (float64(y) + 0.5) / float64(image.Height))
+		for x := 0; x < image.Width; x++ {
+			values[y*image.Width+x] = luminance(image.At(x, y)) * sinTheta
+		}
+	}
+
+	return &EnvironmentMap{
+		Image:     image,
+		Rotation:  rotation,
+		Intensity: intensity,
+		sinRot:    math.Sin(rad),
+		cosRot:    math.Cos(rad),
+		dist:      newDistribution2D(values, image.Width, image.Height),
+	}
+}
+
+// -
+// Convert a world direction to image coordinates, both in [0,1]
+// -
+func (e EnvironmentMap) dirToUV(dir t.Vec3) (float64, float64) {
+	dir = dir.NormalizeNew()
+
+	// Undo the rotation of the map around Y
+	x := dir.X*e.cosRot - dir.Z*e.sinRot
+	z := dir.X*e.sinRot + dir.Z*e.cosRot
+
+	u := 0.5 + math.Atan2(x, -z)/(2*math.Pi)
+	v := math.Acos(math.Max(-1, math.Min(1, dir.Y))) / math.Pi
+
+	return u, v
+}
+
+// -
+// Convert image coordinates to a world direction, the inverse of dirToUV
+// -
+func (e EnvironmentMap) uvToDir(u, v float64) t.Vec3 {
+	theta := v * math.Pi
+	phi := (u - 0.5) * 2 * math.Pi
+	sinTheta := math.Sin(theta)
+
+	x := sinTheta * math.Sin(phi)
+	z := -sinTheta * math.Cos(phi)
+
+	return t.Vec3{
+		X: x*e.cosRot + z*e.sinRot,
+		Y: math.Cos(theta),
+		Z: -x*e.sinRot + z*e.cosRot,
+	}
+}
+
+// -
+// Look up the map with bilinear filtering, wrapping around horizontally
+// -
+func (e EnvironmentMap) colour(dir t.Vec3) t.RGB {
+	u, v := e.dirToUV(dir)
+
+	fx := u*float64(e.Image.Width) - 0.5
+	fy := v*float64(e.Image.Height) - 0.5
+	x0 := int(math.Floor(fx))
+	y0 := int(math.Floor(fy))
+	tx := fx - float64(x0)
+	ty := fy - float64(y0)
+
+	wrapX := func(x int) int {
+		return ((x % e.Image.Width) + e.Image.Width) % e.Image.Width
+	}
+	clampY := func(y int) int {
+		return min(max(y, 0), e.Image.Height-1)
+	}
+
+	top := e.Image.At(wrapX(x0), clampY(y0)).Blend(e.Image.At(wrapX(x0+1), clampY(y0)), tx)
+	bottom := e.Image.At(wrapX(x0), clampY(y0+1)).Blend(e.Image.At(wrapX(x0+1), clampY(y0+1)), tx)
+
+	return top.Blend(bottom, ty).MultScalarNew(e.Intensity)
+}
+
+func (e EnvironmentMap) Type() string {
+	return "environment"
+}
+
+// -
+// Implement SampledBackground, picking directions in proportion to brightness
+// -
+func (e EnvironmentMap) sampleDir(smp Sampler) (t.Vec3, float64) {
+	u, v, pdf := e.dist.sample(smp.Get2D())
+	if pdf <= 0 {
+		return t.Zero(), 0
+	}
+
+	sinTheta := math.Sin(v * math.Pi)
+	if sinTheta <= 0 {
+		return t.Zero(), 0
+	}
+
+	// Convert from a pdf over the image to one over solid angle
+	return e.uvToDir(u, v), pdf / (2 * math.Pi * math.Pi * sinTheta)
+}
+
+func (e EnvironmentMap) pdfDir(dir t.Vec3) float64 {
+	u, v := e.dirToUV(dir)
+
+	sinTheta := math.Sin(v * math.Pi)
+	if sinTheta <= 0 {
+		return 0
+	}
+
+	return e.dist.pdf(u, v) / (2 * math.Pi * math.Pi * sinTheta)
+}
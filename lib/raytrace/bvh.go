@@ -0,0 +1,97 @@
+package raytrace
+
+import (
+	t "nanoray/lib/tuples"
+	"sort"
+)
+
+// BVHNode is a node in a bounding volume hierarchy, it is itself Hitable
+// Leaf nodes hold a single object in Left, with Right left as nil
+type BVHNode struct {
+	Left  Hitable
+	Right Hitable
+	Box   AABB
+}
+
+// Used while building, saves recalculating boxes for every comparison
+type bvhItem struct {
+	obj      Hitable
+	box      AABB
+	centroid t.Vec3
+}
+
+// -
+// Build a BVH tree over a set of objects, returns nil if there are no objects
+// -
+func NewBVH(objects []Hitable) Hitable {
+	if len(objects) == 0 {
+		return nil
+	}
+
+	items := make([]bvhItem, len(objects))
+	for i, obj := range objects {
+		box := obj.BoundingBox()
+		items[i] = bvhItem{obj, box, box.Centroid()}
+	}
+
+	return buildBVH(items)
+}
+
+func buildBVH(items []bvhItem) *BVHNode {
+	if len(items) == 1 {
+		return &BVHNode{
+			Left: items[0].obj,
+			Box:  items[0].box,
+		}
+	}
+
+	// Split at the median along the longest axis of the box around the centroids
+	centroidBox := AABB{items[0].centroid, items[0].centroid}
+	for _, item := range items[1:] {
+		centroidBox = centroidBox.SurroundingBox(AABB{item.centroid, item.centroid})
+	}
+
+	axis := centroidBox.LongestAxis()
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].centroid.Axis(axis) < items[j].centroid.Axis(axis)
+	})
+
+	mid := len(items) / 2
+	left := buildBVH(items[:mid])
+	right := buildBVH(items[mid:])
+
+	return &BVHNode{
+		Left:  left,
+		Right: right,
+		Box:   left.Box.SurroundingBox(right.Box),
+	}
+}
+
+// -
+// Implement the Hitable interface for a BVH node, returns the closest hit
+// -
+func (n *BVHNode) Hit(r Ray, interval Interval) (bool, Hit) {
+	if !n.Box.Hit(r, interval) {
+		return false, Hit{}
+	}
+
+	hitLeft, leftHit := n.Left.Hit(r, interval)
+	if n.Right == nil {
+		return hitLeft, leftHit
+	}
+
+	if hitLeft {
+		interval.Max = leftHit.T
+	}
+
+	hitRight, rightHit := n.Right.Hit(r, interval)
+	if hitRight {
+		return true, rightHit
+	}
+
+	return hitLeft, leftHit
+}
+
+func (n *BVHNode) BoundingBox() AABB {
+	return n.Box
+}
@@ -0,0 +1,94 @@
+package raytrace
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+)
+
+func randomVec(rng *rand.Rand, scale float64) tu.Vec3 {
+	return tu.Vec3{
+		X: (rng.Float64()*2 - 1) * scale,
+		Y: (rng.Float64()*2 - 1) * scale,
+		Z: (rng.Float64()*2 - 1) * scale,
+	}
+}
+
+// Random spheres, boxes and the odd plane scattered through a cube around the origin
+func randomObjects(t *testing.T, rng *rand.Rand, count int) []Hitable {
+	t.Helper()
+
+	objects := []Hitable{}
+	for i := 0; i < count; i++ {
+		var obj Hitable
+		var err error
+
+		switch {
+		case i%25 == 0:
+			obj, err = NewPlane(randomVec(rng, 20), randomVec(rng, 1))
+		case i%2 == 1:
+			obj, err = NewBoxCentered(randomVec(rng, 20), tu.Vec3{X: 0.1 + rng.Float64()*3, Y: 0.1 + rng.Float64()*3, Z: 0.1 + rng.Float64()*3})
+		default:
+			obj, err = NewSphere(randomVec(rng, 20), 0.1+rng.Float64()*2)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		objects = append(objects, obj)
+	}
+
+	return objects
+}
+
+func TestBVHMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, count := range []int{1, 2, 7, 300} {
+		scene := &Scene{}
+		for _, obj := range randomObjects(t, rng, count) {
+			scene.AddObject(obj)
+		}
+
+		// Rays from inside and outside the cloud of objects
+		rays := make([]Ray, 2000)
+		for i := range rays {
+			rays[i] = Ray{Origin: randomVec(rng, 30), Dir: randomVec(rng, 1)}
+		}
+
+		interval := Interval{Min: 0.001, Max: math.Inf(1)}
+
+		linear := make([]*Hit, len(rays))
+		for i, r := range rays {
+			linear[i] = scene.closestHit(r, interval)
+		}
+
+		scene.BuildBVH()
+
+		hits := 0
+		for i, r := range rays {
+			got := scene.closestHit(r, interval)
+			want := linear[i]
+
+			if (got == nil) != (want == nil) {
+				t.Fatalf("%d objects, ray %d: BVH hit %v, linear hit %v", count, i, got, want)
+			}
+
+			if got == nil {
+				continue
+			}
+
+			hits++
+			if got.T != want.T || got.Obj.ID != want.Obj.ID || got.Normal != want.Normal {
+				t.Fatalf("%d objects, ray %d: BVH hit %s at %v, linear hit %s at %v", count, i, got.Obj.ID, got.T, want.Obj.ID, want.T)
+			}
+		}
+
+		if count > 100 && hits == 0 {
+			t.Fatalf("%d objects: no rays hit anything", count)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package raytrace
 import (
 	"log"
 	"math"
-	"math/rand"
 	t "nanoray/lib/tuples"
 )
 
@@ -52,7 +51,7 @@ func NewCamera(imgW, imgH int, position t.Vec3, lookAt t.Vec3, fov float64, focu
 
 	// Calculate the basis vectors for the camera
 	w := (position.SubNew(lookAt)).NormalizeNew()
-	upVector := t.Vec3{0, 1, 0}
+	upVector := t.Vec3{X: 0, Y: 1, Z: 0}
 	u := upVector.Cross(w).NormalizeNew()
 	v := w.Cross(u)
 
@@ -79,18 +78,14 @@ func NewCamera(imgW, imgH int, position t.Vec3, lookAt t.Vec3, fov float64, focu
 	return c
 }
 
-func (c Camera) MakeRay(pixelX, pixelY int) Ray {
-	// Randomly sample within the pixel
-	offsetX := rand.Float64() - 0.5
-	offsetY := rand.Float64() - 0.5
-	pX := float64(pixelX) + offsetX
-	pY := float64(pixelY) + offsetY
-
-	pixelSample := c.pixel00.AddNew(c.pixelDeltaU.MultNew(pX)).AddNew(c.pixelDeltaV.MultNew(pY))
+// Ray through a point on the film in pixels, pixel centres are at whole numbers
+func (c Camera) MakeRay(filmX, filmY float64, smp Sampler) Ray {
+	pixelSample := c.pixel00.AddNew(c.pixelDeltaU.MultNew(filmX)).AddNew(c.pixelDeltaV.MultNew(filmY))
 
 	origin := c.Position
 	if c.focusDist > 0 {
-		diskRandom := t.RandVecDisk(true)
+		u, v := smp.Get2D()
+		diskRandom := t.RandVecDisk(u, v, true)
 		diskOffset := c.defocusDiskU.MultNew(diskRandom.X).AddNew(c.defocusDiskV.MultNew(diskRandom.Y))
 		origin = origin.AddNew(diskOffset)
 	}
@@ -0,0 +1,113 @@
+package raytrace
+
+import (
+	"sort"
+)
+
+// Piecewise constant 1D distribution, used to importance sample by value
+type distribution1D struct {
+	values   []float64
+	cdf      []float64
+	integral float64
+}
+
+func newDistribution1D(values []float64) distribution1D {
+	n := len(values)
+	cdf := make([]float64, n+1)
+
+	for i := 1; i <= n; i++ {
+		cdf[i] = cdf[i-1] + values[i-1]/float64(n)
+	}
+
+	integral := cdf[n]
+
+	// With nothing to go on, fall back to a uniform distribution
+	for i := 1; i <= n; i++ {
+		if integral == 0 {
+			cdf[i] = float64(i) / float64(n)
+		} else {
+			cdf[i] /= integral
+		}
+	}
+
+	return distribution1D{values, cdf, integral}
+}
+
+// -
+// Sample a continuous value in [0,1) from a uniform random number, also
+// returns the pdf of the value, and the index of the piece it falls in
+// -
+func (d distribution1D) sample(u float64) (float64, float64, int) {
+	n := len(d.values)
+
+	i := sort.SearchFloat64s(d.cdf, u) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i > n-1 {
+		i = n - 1
+	}
+
+	du := u - d.cdf[i]
+	if width := d.cdf[i+1] - d.cdf[i]; width > 0 {
+		du /= width
+	}
+
+	return (float64(i) + du) / float64(n), d.pdf(i), i
+}
+
+func (d distribution1D) pdf(i int) float64 {
+	if d.integral == 0 {
+		return 1
+	}
+
+	return d.values[i] / d.integral
+}
+
+// Piecewise constant 2D distribution, a marginal over rows then a conditional per row
+type distribution2D struct {
+	rows     []distribution1D
+	marginal distribution1D
+}
+
+// -
+// Create a 2D distribution from a function of width x height values, row by row
+// -
+func newDistribution2D(values []float64, width, height int) distribution2D {
+	rows := make([]distribution1D, height)
+	rowIntegrals := make([]float64, height)
+
+	for y := 0; y < height; y++ {
+		rows[y] = newDistribution1D(values[y*width : (y+1)*width])
+		rowIntegrals[y] = rows[y].integral
+	}
+
+	return distribution2D{rows, newDistribution1D(rowIntegrals)}
+}
+
+// -
+// Sample a point (u, v) in the unit square, returns the point and its pdf
+// -
+func (d distribution2D) sample(u1, u2 float64) (float64, float64, float64) {
+	v, pdfV, row := d.marginal.sample(u2)
+	u, pdfU, _ := d.rows[row].sample(u1)
+
+	return u, v, pdfU * pdfV
+}
+
+// -
+// Pdf of sampling the point (u, v) in the unit square
+// -
+func (d distribution2D) pdf(u, v float64) float64 {
+	width := len(d.rows[0].values)
+	height := len(d.rows)
+
+	x := min(max(int(u*float64(width)), 0), width-1)
+	y := min(max(int(v*float64(height)), 0), height-1)
+
+	if d.marginal.integral == 0 {
+		return 1
+	}
+
+	return d.rows[y].values[x] / d.marginal.integral
+}
@@ -0,0 +1,148 @@
+package raytrace
+
+import (
+	"log"
+	"math"
+	t "nanoray/lib/tuples"
+)
+
+// Emitter is implemented by objects that can be sampled for direct lighting
+// Emissive objects which don't implement it are still lit, but only by chance
+type Emitter interface {
+	Hitable
+
+	// Sample a point on this object as seen from a point, returns the normalized
+	// direction to it, its distance and the solid angle pdf of choosing it
+	sampleDir(from t.Vec3, smp Sampler) (t.Vec3, float64, float64)
+
+	// Solid angle pdf that sampleDir would have picked the given hit from a point
+	pdfDir(from t.Vec3, hit Hit) float64
+}
+
+// -
+// Find all emissive objects in the scene that can be sampled as lights
+// Each is numbered, so hits on it can be told apart from other objects with the same ID
+// -
+func (s *Scene) CollectEmitters() {
+	s.emitters = []Emitter{}
+
+	for _, obj := range s.Objects {
+		emitter, ok := obj.(Emitter)
+		if !ok {
+			continue
+		}
+
+		base, ok := obj.(objectBase)
+		if !ok {
+			continue
+		}
+
+		if !emitsLight(base.base().Material) {
+			continue
+		}
+
+		s.emitters = append(s.emitters, emitter)
+		base.base().emitter = len(s.emitters)
+	}
+
+	log.Printf("Found %d emissive objects for direct sampling", len(s.emitters))
+}
+
+// -
+// Only light & PBR materials emit, and only if their emission isn't black everywhere
+// -
+func emitsLight(m Material) bool {
+	switch light := m.(type) {
+	case LightMaterial:
+		return !isBlack(light.Emission)
+	case *LightMaterial:
+		return !isBlack(light.Emission)
+	case PBRMaterial:
+		return !isBlack(light.Emission)
+	case *PBRMaterial:
+		return !isBlack(light.Emission)
+	}
+
+	return false
+}
+
+// -
+// Next event estimation, pick one emissive object at random and shoot a shadow ray to it
+// When misWeight is set the result is weighted against the chance the BSDF
+// sampled ray would also find this light, using multiple importance sampling
+// -
+func (s Scene) sampleEmitters(r Ray, hit Hit, misWeight bool, smp Sampler) t.RGB {
+	if len(s.emitters) == 0 {
+		return t.Black()
+	}
+
+	index := min(int(smp.Get1D()*float64(len(s.emitters))), len(s.emitters)-1)
+
+	dir, dist, lightPdf := s.emitters[index].sampleDir(hit.Pos, smp)
+	if lightPdf <= 0 {
+		return t.Black()
+	}
+
+	lightPdf /= float64(len(s.emitters))
+
+	// Zero pdf means a specular material, or the light is behind the surface
+	bsdfPdf := hit.Obj.Material.pdf(r, hit, dir)
+	if bsdfPdf <= 0 {
+		return t.Black()
+	}
+
+	shadowRay := NewRay(hit.Pos, dir)
+	shadowHit := s.closestHit(shadowRay, Interval{0.001, math.MaxFloat64})
+
+	// Something else is in the way, so the point is in shadow. That includes the light
+	// itself, when the sampled point is on a face turned away behind another of its faces
+	if shadowHit == nil || shadowHit.Obj.emitter != index+1 || math.Abs(shadowHit.T-dist) > 1e-4*max(1, dist) {
+		return t.Black()
+	}
+
+	weight := 1.0
+	if misWeight {
+		weight = powerHeuristic(lightPdf, bsdfPdf)
+	}
+
+	radiance := shadowHit.Obj.Material.emitted(shadowRay, *shadowHit)
+	radiance.Mult(hit.Obj.Material.eval(r, hit, dir))
+	radiance.MultScalar(weight / lightPdf)
+
+	return radiance
+}
+
+// -
+// Pdf that sampleEmitters would have chosen the given hit from a point, zero
+// for objects that are not sampled as lights
+// -
+func (s Scene) emitterPdf(from t.Vec3, hit Hit) float64 {
+	if hit.Obj.emitter == 0 {
+		return 0
+	}
+
+	return s.emitters[hit.Obj.emitter-1].pdfDir(from, hit) / float64(len(s.emitters))
+}
+
+// -
+// Power heuristic for MIS, weight for a sample from strategy with pdf a
+// -
+func powerHeuristic(a, b float64) float64 {
+	a2 := a * a
+	return a2 / (a2 + b*b)
+}
+
+// -
+// Convert a pdf with respect to area on a surface into solid angle from a point
+// -
+func areaToSolidAngle(pdfArea float64, from, point, normal t.Vec3) float64 {
+	toPoint := point.SubNew(from)
+	dist2 := toPoint.SquaredLength()
+
+	cosine := math.Abs(normal.Dot(toPoint.DivNew(math.Sqrt(dist2))))
+	if cosine < 1e-8 {
+		return 0
+	}
+
+	return pdfArea * dist2 / cosine
+}
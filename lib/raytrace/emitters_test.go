@@ -0,0 +1,84 @@
+package raytrace
+
+import (
+	"math"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+)
+
+func TestPowerHeuristic(t *testing.T) {
+	tests := []struct {
+		a, b float64
+		want float64
+	}{
+		{1, 1, 0.5},
+		{1, 0, 1},
+		{0, 1, 0},
+		{2, 1, 0.8},
+		{3, 4, 0.36},
+	}
+
+	for _, test := range tests {
+		got := powerHeuristic(test.a, test.b)
+		if math.Abs(got-test.want) > 1e-12 {
+			t.Errorf("powerHeuristic(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+
+		// The weights of both strategies must always sum to one
+		if sum := got + powerHeuristic(test.b, test.a); math.Abs(sum-1) > 1e-12 {
+			t.Errorf("powerHeuristic(%v, %v) weights sum to %v", test.a, test.b, sum)
+		}
+	}
+}
+
+func TestEmitterPdf(t *testing.T) {
+	light := NewLightMaterial(NewSolidTexture(tu.RGB{R: 4, G: 4, B: 4}))
+
+	sphere, _ := NewSphere(tu.Vec3{X: 10}, 2)
+	sphere.Material = light
+
+	box, _ := NewBoxCentered(tu.Vec3{Z: -10}, tu.Vec3{X: 2, Y: 4, Z: 6})
+	box.Material = light
+
+	dull, _ := NewSphere(tu.Vec3{Y: 10}, 2)
+	dull.Material = NewDiffuseMaterial(NewSolidTexture(tu.RGB{R: 0.5, G: 0.5, B: 0.5}))
+
+	scene := &Scene{}
+	scene.AddObject(sphere)
+	scene.AddObject(box)
+	scene.AddObject(dull)
+	scene.BuildBVH()
+	scene.CollectEmitters()
+
+	if len(scene.emitters) != 2 {
+		t.Fatalf("collected %d emitters, want 2", len(scene.emitters))
+	}
+
+	// Sphere subtends a cone with cos 0.96^0.5 from the origin, the box face
+	// at distance 7 is hit head on, each light is picked half of the time
+	conePdf := 1 / (2 * math.Pi * (1 - math.Sqrt(0.96)))
+	boxPdf := 7.0 * 7.0 / 88.0
+
+	tests := []struct {
+		name string
+		dir  tu.Vec3
+		want float64
+	}{
+		{"sphere light", tu.Vec3{X: 1}, conePdf / 2},
+		{"box light", tu.Vec3{Z: -1}, boxPdf / 2},
+		{"not a light", tu.Vec3{Y: 1}, 0},
+	}
+
+	for _, test := range tests {
+		hit := scene.closestHit(NewRay(tu.Vec3{}, test.dir), Interval{Min: 0.001, Max: math.Inf(1)})
+		if hit == nil {
+			t.Fatalf("%s: ray missed", test.name)
+		}
+
+		got := scene.emitterPdf(tu.Vec3{}, *hit)
+		if math.Abs(got-test.want) > 1e-9*test.want {
+			t.Errorf("%s: emitterPdf = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -5,5 +5,35 @@ type RaytraceError string
 func (e RaytraceError) Error() string { return string(e) }
 
 const (
-	ErrInvalidRadius = RaytraceError("invalid radius")
+	ErrInvalidRadius      = RaytraceError("invalid radius")
+	ErrInvalidScale       = RaytraceError("invalid scale, must be non-zero on all axes")
+	ErrDegenerateTriangle = RaytraceError("degenerate triangle, vertices are collinear")
+	ErrEmptyMesh          = RaytraceError("mesh has no triangles")
+	ErrInvalidNormal      = RaytraceError("invalid normal, must be non-zero")
+	ErrInvalidSize        = RaytraceError("invalid size, must be greater than zero on all axes")
+	ErrNoMaterial         = RaytraceError("object has no valid material")
+	ErrNoMeshFile         = RaytraceError("mesh has no file")
+	ErrUnknownObject      = RaytraceError("unknown object type")
+	ErrUnknownDefinition  = RaytraceError("instance references unknown definition")
+	ErrInstanceCycle      = RaytraceError("instance definitions reference each other in a cycle")
+	ErrInvalidDirection   = RaytraceError("invalid direction, must be non-zero")
+	ErrInvalidAngle       = RaytraceError("invalid angle, must be between 0 and 180 degrees")
+	ErrUnknownLight       = RaytraceError("unknown light type")
+	ErrInvalidHDR         = RaytraceError("invalid or unsupported HDR image")
+	ErrNoEnvironmentFile  = RaytraceError("environment has no file")
+	ErrInvalidTurbidity   = RaytraceError("invalid turbidity, must be between 1.7 and 10")
+	ErrUnknownSky         = RaytraceError("unknown sky mode")
+	ErrUnknownTexture     = RaytraceError("unknown texture type")
+	ErrNoImageFile        = RaytraceError("image texture has no file")
+	ErrUnknownWrapMode    = RaytraceError("unknown texture wrap mode")
+	ErrUnknownNoiseStyle  = RaytraceError("unknown noise style")
+	ErrUnknownTileOrder   = RaytraceError("unknown tile order")
+	ErrUnknownEncoding    = RaytraceError("unknown tile encoding")
+	ErrTileSize           = RaytraceError("tile image data does not match the tile size")
+	ErrAssetNotFound      = RaytraceError("asset not found")
+	ErrInvalidDigest      = RaytraceError("invalid content digest")
+	ErrInvalidBundle      = RaytraceError("invalid scene bundle")
+	ErrNoBundleScene      = RaytraceError("bundle must hold one scene file, or a scene.yaml at the top level")
+	ErrUnknownSampler     = RaytraceError("unknown sampler")
+	ErrUnknownFilter      = RaytraceError("unknown reconstruction filter")
 )
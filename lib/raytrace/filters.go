@@ -0,0 +1,246 @@
+package raytrace
+
+import (
+	"image"
+	"math"
+
+	"nanoray/lib/proto"
+	t "nanoray/lib/tuples"
+)
+
+// Filter weights samples by how far they land from a pixel centre, so each sample can
+// count towards every pixel within the filter radius rather than only the one it's in
+type Filter interface {
+	// How far the filter reaches from the pixel centre, in pixels
+	Radius() float64
+
+	// Weight of a sample offset from the pixel centre by x & y, zero beyond the radius
+	Weight(x, y float64) float64
+}
+
+// FilterType names a reconstruction filter, for scene files and render requests
+type FilterType string
+
+const (
+	FilterBox            FilterType = "box"             // Plain average of the samples in each pixel, the default
+	FilterTent           FilterType = "tent"            // Linear falloff, slightly soft
+	FilterGaussian       FilterType = "gaussian"        // Smooth falloff, soft but free of ringing
+	FilterMitchell       FilterType = "mitchell"        // Mitchell-Netravali, sharp with a little ringing
+	FilterBlackmanHarris FilterType = "blackman-harris" // Windowed falloff, between Gaussian and Mitchell
+)
+
+// Radius used for each filter when none is given
+var defaultFilterRadius = map[FilterType]float64{
+	FilterBox:            0.5,
+	FilterTent:           1,
+	FilterGaussian:       1.5,
+	FilterMitchell:       2,
+	FilterBlackmanHarris: 2,
+}
+
+// -
+// Create a reconstruction filter, a radius of zero picks the default for the type
+// -
+func NewFilter(kind FilterType, radius float64) (Filter, error) {
+	if kind == "" {
+		kind = FilterBox
+	}
+
+	if _, ok := defaultFilterRadius[kind]; !ok {
+		return nil, ErrUnknownFilter
+	}
+
+	if radius < 0 {
+		return nil, ErrInvalidRadius
+	}
+
+	if radius == 0 {
+		radius = defaultFilterRadius[kind]
+	}
+
+	var eval func(x float64) float64
+
+	switch kind {
+	case FilterBox:
+		eval = func(x float64) float64 {
+			return 1
+		}
+
+	case FilterTent:
+		eval = func(x float64) float64 {
+			return radius - math.Abs(x)
+		}
+
+	case FilterGaussian:
+		// Shifted down so it reaches zero at the radius, rather than being cut off
+		sigma := radius / 3
+		edge := gaussian(radius, sigma)
+		eval = func(x float64) float64 {
+			return gaussian(x, sigma) - edge
+		}
+
+	case FilterMitchell:
+		eval = func(x float64) float64 {
+			return mitchell(2*x/radius, 1.0/3, 1.0/3)
+		}
+
+	case FilterBlackmanHarris:
+		eval = func(x float64) float64 {
+			return blackmanHarris(x/(2*radius) + 0.5)
+		}
+	}
+
+	return separableFilter{radius: radius, eval: eval}, nil
+}
+
+// -
+// Pick the filter for a render, a filter or radius from the render settings override the
+// scene's. A different filter doesn't keep the scene's radius, as it was meant for another
+// -
+func ResolveFilter(scene *Scene, filter FilterType, radius float64) (FilterType, float64, error) {
+	if filter == "" {
+		filter = scene.Filter
+		if radius == 0 {
+			radius = scene.FilterRadius
+		}
+	}
+
+	if _, err := NewFilter(filter, radius); err != nil {
+		return "", 0, err
+	}
+
+	return filter, radius, nil
+}
+
+// All the filters are a 1D curve applied along each axis and multiplied
+type separableFilter struct {
+	radius float64
+	eval   func(x float64) float64
+}
+
+func (f separableFilter) Radius() float64 {
+	return f.radius
+}
+
+func (f separableFilter) Weight(x, y float64) float64 {
+	// Half open, so a box filter gives every sample to exactly one pixel
+	if x < -f.radius || x >= f.radius || y < -f.radius || y >= f.radius {
+		return 0
+	}
+
+	return f.eval(x) * f.eval(y)
+}
+
+func gaussian(x, sigma float64) float64 {
+	return math.Exp(-x * x / (2 * sigma * sigma))
+}
+
+// Mitchell-Netravali cubic over [-2, 2], B = C = 1/3 is the recommended balance
+func mitchell(x, b, c float64) float64 {
+	x = math.Abs(x)
+
+	if x < 1 {
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	}
+	if x < 2 {
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+
+	return 0
+}
+
+// Four term Blackman-Harris window over [0, 1]
+func blackmanHarris(x float64) float64 {
+	return 0.35875 - 0.48829*math.Cos(2*math.Pi*x) + 0.14128*math.Cos(4*math.Pi*x) - 0.01168*math.Cos(6*math.Pi*x)
+}
+
+// -
+// Pixels around a tile whose samples land within the filter radius of the tile's own pixels
+// -
+func filterApron(f Filter) int {
+	return max(int(math.Ceil(f.Radius()-0.5)), 0)
+}
+
+// Weighted radiance for the pixels of one job, built by splatting samples through the
+// filter. Samples are taken over the whole region, but only splatted into the tile
+// Pixels in the region are always visited in image order, so each pixel adds up its
+// samples in the same order whatever the tiling, and renders stay repeatable
+type tileFilm struct {
+	filter Filter
+	tile   image.Rectangle // Pixels the job returns
+	region image.Rectangle // Pixels sampled, the tile plus an apron around it, clipped to the image
+	sum    []t.RGB
+	weight []float64
+}
+
+func newTileFilm(job *proto.JobRequest, filter Filter) *tileFilm {
+	tile := image.Rect(int(job.X), int(job.Y), int(job.X+job.Width), int(job.Y+job.Height))
+	bounds := image.Rect(0, 0, int(job.ImageDetails.GetWidth()), int(job.ImageDetails.GetHeight()))
+
+	// Jobs without image details have nothing to clip the apron to, so go without
+	region := tile
+	if !bounds.Empty() {
+		region = tile.Inset(-filterApron(filter)).Intersect(bounds).Union(tile)
+	}
+
+	return &tileFilm{
+		filter: filter,
+		tile:   tile,
+		region: region,
+		sum:    make([]t.RGB, tile.Dx()*tile.Dy()),
+		weight: make([]float64, tile.Dx()*tile.Dy()),
+	}
+}
+
+// -
+// Add a sample at a position on the film to every tile pixel the filter reaches
+// Pixel centres are at whole numbers, so pixel X covers X-0.5 to X+0.5
+// -
+func (f *tileFilm) addSample(filmX, filmY float64, radiance t.RGB) {
+	r := f.filter.Radius()
+	x0 := max(int(math.Floor(filmX-r))+1, f.tile.Min.X)
+	x1 := min(int(math.Floor(filmX+r)), f.tile.Max.X-1)
+	y0 := max(int(math.Floor(filmY-r))+1, f.tile.Min.Y)
+	y1 := min(int(math.Floor(filmY+r)), f.tile.Max.Y-1)
+
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			w := f.filter.Weight(filmX-float64(x), filmY-float64(y))
+			if w == 0 {
+				continue
+			}
+
+			i := (y-f.tile.Min.Y)*f.tile.Dx() + x - f.tile.Min.X
+			f.sum[i].AddSome(radiance, w)
+			f.weight[i] += w
+		}
+	}
+}
+
+// -
+// Weighted radiance sums and filter weights for the tile, as RGBW quads
+// -
+func (f *tileFilm) radiance() []float32 {
+	out := make([]float32, 0, len(f.sum)*4)
+	for i, s := range f.sum {
+		out = append(out, float32(s.R), float32(s.G), float32(s.B), float32(f.weight[i]))
+	}
+
+	return out
+}
+
+// -
+// Resolve a pixel from its weighted radiance sum, filters with negative lobes can
+// push values below zero around sharp edges, so they are clamped
+// -
+func resolvePixel(r, g, b, weight float64) t.RGB {
+	if weight <= 0 {
+		return t.Black()
+	}
+
+	return t.RGB{
+		R: math.Max(0, r/weight),
+		G: math.Max(0, g/weight),
+		B: math.Max(0, b/weight),
+	}
+}
@@ -0,0 +1,122 @@
+package raytrace
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+var testFilterTypes = []FilterType{FilterBox, FilterTent, FilterGaussian, FilterMitchell, FilterBlackmanHarris}
+
+func TestNewFilterDefaults(t *testing.T) {
+	for _, kind := range testFilterTypes {
+		f, err := NewFilter(kind, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+
+		if f.Radius() != defaultFilterRadius[kind] {
+			t.Errorf("%s: default radius is %v", kind, f.Radius())
+		}
+	}
+
+	// No filter at all is a box
+	f, err := NewFilter("", 0)
+	if err != nil || f.Radius() != 0.5 || f.Weight(0.25, -0.25) != 1 {
+		t.Errorf("empty filter type is not a box: %v", err)
+	}
+
+	f, _ = NewFilter(FilterGaussian, 3)
+	if f.Radius() != 3 {
+		t.Errorf("radius given as 3 is %v", f.Radius())
+	}
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	if _, err := NewFilter("lanczos", 0); !errors.Is(err, ErrUnknownFilter) {
+		t.Errorf("unknown filter gave %v", err)
+	}
+
+	if _, err := NewFilter(FilterTent, -1); !errors.Is(err, ErrInvalidRadius) {
+		t.Errorf("negative radius gave %v", err)
+	}
+}
+
+func TestFilterWeights(t *testing.T) {
+	cases := []struct {
+		kind   FilterType
+		x, y   float64
+		weight float64
+	}{
+		{FilterBox, 0, 0, 1},
+		{FilterBox, -0.5, 0.49, 1},
+		{FilterBox, 0.5, 0, 0}, // Half open, the edge belongs to the next pixel
+		{FilterTent, 0, 0, 1},
+		{FilterTent, 0.5, 0, 0.5},
+		{FilterTent, 0.5, -0.5, 0.25},
+		{FilterTent, -1, 0, 0},
+		{FilterGaussian, 1.4999, 0, 0}, // Shifted down to meet zero at the radius
+		{FilterMitchell, 0, 0, 8.0 / 9 * 8.0 / 9},
+		{FilterMitchell, 1, 0, 1.0 / 18 * 8.0 / 9},
+		{FilterBlackmanHarris, 0, 0, 1},
+		{FilterBlackmanHarris, 2, 0, 0},
+		{FilterBlackmanHarris, 5, 5, 0},
+	}
+
+	for _, tc := range cases {
+		f, _ := NewFilter(tc.kind, 0)
+		if w := f.Weight(tc.x, tc.y); math.Abs(w-tc.weight) > 1e-4 {
+			t.Errorf("%s: weight at %v,%v is %v, want %v", tc.kind, tc.x, tc.y, w, tc.weight)
+		}
+	}
+
+	// Mitchell has negative lobes, which is why resolved pixels are clamped
+	mitchell, _ := NewFilter(FilterMitchell, 0)
+	if w := mitchell.Weight(1.5, 0); w >= 0 {
+		t.Errorf("mitchell weight at 1.5 is %v, expected negative", w)
+	}
+}
+
+func TestFilterShape(t *testing.T) {
+	for _, kind := range testFilterTypes {
+		f, _ := NewFilter(kind, 0)
+		r := f.Radius()
+		centre := f.Weight(0, 0)
+
+		for _, x := range []float64{0.1, 0.3, r / 2, r * 0.9} {
+			w := f.Weight(x, 0)
+
+			// Symmetric and separable, and never more than at the centre
+			if math.Abs(w-f.Weight(-x, 0)) > 1e-12 || math.Abs(w-f.Weight(0, x)) > 1e-12 {
+				t.Errorf("%s: not symmetric at %v", kind, x)
+			}
+			if diag := f.Weight(x, x); math.Abs(diag-w*w/centre) > 1e-12 {
+				t.Errorf("%s: weight at %v,%v is %v, not separable", kind, x, x, diag)
+			}
+			if w > centre {
+				t.Errorf("%s: weight at %v is above the centre", kind, x)
+			}
+		}
+
+		if f.Weight(r+0.01, 0) != 0 || f.Weight(0, -r-0.01) != 0 {
+			t.Errorf("%s: non-zero weight beyond the radius", kind)
+		}
+	}
+}
+
+func TestFilterApron(t *testing.T) {
+	cases := map[FilterType]int{
+		FilterBox:            0,
+		FilterTent:           1,
+		FilterGaussian:       1,
+		FilterMitchell:       2,
+		FilterBlackmanHarris: 2,
+	}
+
+	for kind, want := range cases {
+		f, _ := NewFilter(kind, 0)
+		if got := filterApron(f); got != want {
+			t.Errorf("%s: apron is %d, want %d", kind, got, want)
+		}
+	}
+}
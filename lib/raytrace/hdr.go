@@ -0,0 +1,183 @@
+package raytrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	t "nanoray/lib/tuples"
+	"strings"
+)
+
+// HDRImage is a high dynamic range image, with float RGB pixels stored row by row
+type HDRImage struct {
+	Width  int
+	Height int
+	Pix    []float32 // Three values per pixel, R, G, B
+}
+
+// -
+// Get the colour of a pixel, coordinates must be within the image
+// -
+func (img HDRImage) At(x, y int) t.RGB {
+	i := (y*img.Width + x) * 3
+	return t.RGB{R: float64(img.Pix[i]), G: float64(img.Pix[i+1]), B: float64(img.Pix[i+2])}
+}
+
+// -
+// Decode Radiance RGBE image data, both flat and run length encoded scanlines
+// are supported, but only the standard -Y +X orientation
+// -
+func DecodeHDR(reader io.Reader) (*HDRImage, error) {
+	r := bufio.NewReader(reader)
+
+	// Header is a set of text lines, ending with a blank line
+	magic, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(magic, "#?") {
+		return nil, ErrInvalidHDR
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("%w: unsupported %s", ErrInvalidHDR, line)
+		}
+	}
+
+	resolution, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var width, height int
+	if _, err := fmt.Sscanf(resolution, "-Y %d +X %d", &height, &width); err != nil {
+		return nil, fmt.Errorf("%w: unsupported resolution %q", ErrInvalidHDR, strings.TrimSpace(resolution))
+	}
+
+	if width <= 0 || height <= 0 {
+		return nil, ErrInvalidHDR
+	}
+
+	img := &HDRImage{
+		Width:  width,
+		Height: height,
+		Pix:    make([]float32, width*height*3),
+	}
+
+	scanline := make([]byte, width*4)
+	for y := 0; y < height; y++ {
+		if err := readHDRScanline(r, scanline, width); err != nil {
+			return nil, fmt.Errorf("scanline %d: %w", y, err)
+		}
+
+		for x := 0; x < width; x++ {
+			red, green, blue := rgbeToFloat(scanline[x*4], scanline[x*4+1], scanline[x*4+2], scanline[x*4+3])
+			i := (y*width + x) * 3
+			img.Pix[i] = red
+			img.Pix[i+1] = green
+			img.Pix[i+2] = blue
+		}
+	}
+
+	return img, nil
+}
+
+// -
+// Read one scanline of RGBE pixels into out, which is 4 bytes per pixel
+// -
+func readHDRScanline(r *bufio.Reader, out []byte, width int) error {
+	header, err := r.Peek(4)
+	if err != nil {
+		return err
+	}
+
+	// Run length encoded scanlines start with 2, 2 then the width
+	isRLE := width >= 8 && width < 32768 && header[0] == 2 && header[1] == 2 && header[2]&0x80 == 0
+	if !isRLE {
+		_, err := io.ReadFull(r, out)
+		return err
+	}
+
+	if int(header[2])<<8|int(header[3]) != width {
+		return fmt.Errorf("%w: scanline width mismatch", ErrInvalidHDR)
+	}
+
+	if _, err := r.Discard(4); err != nil {
+		return err
+	}
+
+	// Each of the four channels is encoded separately
+	for channel := 0; channel < 4; channel++ {
+		x := 0
+		for x < width {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if count > 128 {
+				// A run of the same value
+				run := int(count) - 128
+				if x+run > width {
+					return fmt.Errorf("%w: bad run length", ErrInvalidHDR)
+				}
+
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+
+				for i := 0; i < run; i++ {
+					out[(x+i)*4+channel] = value
+				}
+
+				x += run
+			} else {
+				// A sequence of different values
+				run := int(count)
+				if run == 0 || x+run > width {
+					return fmt.Errorf("%w: bad run length", ErrInvalidHDR)
+				}
+
+				for i := 0; i < run; i++ {
+					value, err := r.ReadByte()
+					if err != nil {
+						return err
+					}
+
+					out[(x+i)*4+channel] = value
+				}
+
+				x += run
+			}
+		}
+	}
+
+	return nil
+}
+
+// -
+// Convert a shared exponent RGBE pixel to floats
+// -
+func rgbeToFloat(r, g, b, e byte) (float32, float32, float32) {
+	if e == 0 {
+		return 0, 0, 0
+	}
+
+	f := float32(math.Ldexp(1, int(e)-(128+8)))
+
+	return float32(r) * f, float32(g) * f, float32(b) * f
+}
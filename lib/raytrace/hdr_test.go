@@ -0,0 +1,145 @@
+package raytrace
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testHDRHeader = "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1.0\n\n"
+
+func TestRGBEToFloat(t *testing.T) {
+	cases := []struct {
+		r, g, b, e byte
+		want       [3]float32
+	}{
+		{128, 64, 0, 129, [3]float32{1, 0.5, 0}},
+		{128, 128, 128, 136, [3]float32{128, 128, 128}},
+		{255, 1, 16, 128, [3]float32{255.0 / 256, 1.0 / 256, 16.0 / 256}},
+		{200, 200, 200, 0, [3]float32{0, 0, 0}}, // A zero exponent is black
+	}
+
+	for _, tc := range cases {
+		r, g, b := rgbeToFloat(tc.r, tc.g, tc.b, tc.e)
+		if [3]float32{r, g, b} != tc.want {
+			t.Errorf("rgbe %d,%d,%d,%d gave %v %v %v, want %v", tc.r, tc.g, tc.b, tc.e, r, g, b, tc.want)
+		}
+	}
+}
+
+func TestDecodeHDRFlat(t *testing.T) {
+	// Too narrow for run length encoding, so pixels are stored as plain RGBE
+	data := testHDRHeader + "-Y 2 +X 2\n" +
+		"\x80\x40\x00\x81" + "\x00\x00\x00\x00" +
+		"\x80\x80\x80\x82" + "\x40\x00\x80\x80"
+
+	img, err := DecodeHDR(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Width != 2 || img.Height != 2 {
+		t.Fatalf("size is %dx%d", img.Width, img.Height)
+	}
+
+	want := []float32{1, 0.5, 0, 0, 0, 0, 2, 2, 2, 0.25, 0, 0.5}
+	for i, v := range want {
+		if img.Pix[i] != v {
+			t.Fatalf("pixel %d channel %d is %v, want %v", i/3, i%3, img.Pix[i], v)
+		}
+	}
+
+	if c := img.At(1, 1); c.R != 0.25 || c.G != 0 || c.B != 0.5 {
+		t.Errorf("At(1, 1) is %v", c)
+	}
+}
+
+func TestDecodeHDRRunLength(t *testing.T) {
+	// One scanline of 8 pixels, each channel encoded separately
+	var data bytes.Buffer
+	data.WriteString(testHDRHeader + "-Y 1 +X 8\n")
+	data.Write([]byte{2, 2, 0, 8})
+	data.Write([]byte{128 + 8, 128})                      // Red, a run of 8
+	data.Write([]byte{8, 0, 16, 32, 48, 64, 80, 96, 112}) // Green, 8 different values
+	data.Write([]byte{128 + 4, 0, 4, 1, 2, 3, 4})         // Blue, a run then different values
+	data.Write([]byte{128 + 8, 129})                      // Exponent, a run of 8
+
+	img, err := DecodeHDR(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for x := 0; x < 8; x++ {
+		c := img.At(x, 0)
+
+		blue := 0.0
+		if x >= 4 {
+			blue = float64(x-3) / 128
+		}
+
+		if c.R != 1 || c.G != float64(x*16)/128 || c.B != blue {
+			t.Errorf("pixel %d is %v", x, c)
+		}
+	}
+}
+
+func TestDecodeHDRErrors(t *testing.T) {
+	cases := map[string]string{
+		"bad magic":       "P6\n\n-Y 1 +X 1\n\x00\x00\x00\x00",
+		"bad format":      "#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n\x00\x00\x00\x00",
+		"flipped":         testHDRHeader + "+Y 1 +X 1\n\x00\x00\x00\x00",
+		"zero size":       testHDRHeader + "-Y 0 +X 1\n",
+		"width mismatch":  testHDRHeader + "-Y 1 +X 8\n\x02\x02\x00\x09",
+		"run too long":    testHDRHeader + "-Y 1 +X 8\n\x02\x02\x00\x08\x89\x00",
+		"zero length run": testHDRHeader + "-Y 1 +X 8\n\x02\x02\x00\x08\x00",
+	}
+
+	for name, data := range cases {
+		_, err := DecodeHDR(strings.NewReader(data))
+		if !errors.Is(err, ErrInvalidHDR) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidHDR)
+		}
+	}
+
+	// Data ending early is an error, but not necessarily an invalid file
+	_, err := DecodeHDR(strings.NewReader(testHDRHeader + "-Y 2 +X 2\n\x80\x40\x00\x81"))
+	if err == nil {
+		t.Errorf("truncated data decoded without error")
+	}
+}
+
+func TestParseEnvironmentIntensity(t *testing.T) {
+	assets := &Bundle{Files: map[string][]byte{
+		"sky.hdr": []byte(testHDRHeader + "-Y 1 +X 1\n\x80\x80\x80\x81"),
+	}}
+
+	tests := []struct {
+		name      string
+		source    string
+		intensity float64
+	}{
+		{"missing", "environment:\n  file: sky.hdr", 1},
+		{"zero", "environment:\n  file: sky.hdr\n  intensity: 0", 0},
+		{"given", "environment:\n  file: sky.hdr\n  intensity: 2", 2},
+	}
+
+	for _, test := range tests {
+		var fileBackground FileBackground
+		if err := yaml.Unmarshal([]byte(test.source), &fileBackground); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		background, err := parseBackground(fileBackground, assets)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		env := background.(*EnvironmentMap)
+		if env.Intensity != test.intensity {
+			t.Errorf("%s: intensity is %v, want %v", test.name, env.Intensity, test.intensity)
+		}
+	}
+}
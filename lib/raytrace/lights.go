@@ -0,0 +1,171 @@
+package raytrace
+
+import (
+	"math"
+	t "nanoray/lib/tuples"
+)
+
+// Light is a simple light source with no geometry, so it can't be hit by rays
+// These lights are only seen through shadow rays cast from each hit point
+type Light interface {
+	// Direction & distance from a point to the light, and the light arriving there
+	illuminate(from t.Vec3) (dir t.Vec3, dist float64, radiance t.RGB)
+
+	Type() string
+}
+
+// -
+// Direct lighting from all lights, each one is tested with a shadow ray
+// -
+func (s Scene) sampleLights(r Ray, hit Hit) t.RGB {
+	total := t.Black()
+
+	for _, light := range s.Lights {
+		dir, dist, radiance := light.illuminate(hit.Pos)
+		if radiance.IsBlack() {
+			continue
+		}
+
+		// Zero pdf means a specular material, or the light is behind the surface
+		if hit.Obj.Material.pdf(r, hit, dir) <= 0 {
+			continue
+		}
+
+		shadowRay := NewRay(hit.Pos, dir)
+		if s.closestHit(shadowRay, Interval{0.001, dist}) != nil {
+			continue
+		}
+
+		radiance.Mult(hit.Obj.Material.eval(r, hit, dir))
+		total.Add(radiance)
+	}
+
+	return total
+}
+
+// ============================================================
+// Point light, shines equally in all directions
+// ============================================================
+
+type PointLight struct {
+	Position  t.Vec3
+	Colour    t.RGB
+	Intensity float64
+}
+
+func NewPointLight(position t.Vec3, colour t.RGB, intensity float64) PointLight {
+	return PointLight{
+		Position:  position,
+		Colour:    colour,
+		Intensity: intensity,
+	}
+}
+
+func (l PointLight) illuminate(from t.Vec3) (t.Vec3, float64, t.RGB) {
+	toLight := l.Position.SubNew(from)
+	dist := toLight.Length()
+
+	// Inverse square falloff
+	radiance := l.Colour.MultScalarNew(l.Intensity / (dist * dist))
+
+	return toLight.DivNew(dist), dist, radiance
+}
+
+func (l PointLight) Type() string {
+	return "point"
+}
+
+// ============================================================
+// Spot light, a point light limited to a cone with a soft edge
+// ============================================================
+
+type SpotLight struct {
+	Position  t.Vec3
+	Direction t.Vec3 // Direction the spot is pointing
+	Colour    t.RGB
+	Intensity float64
+
+	cosOuter float64 // Cosine of the cone half angle, no light outside this
+	cosInner float64 // Cosine of the angle where the falloff starts
+}
+
+// -
+// Create a new spot light, angle is the cone half angle in degrees, falloff is
+// the width in degrees of the soft edge, measured inwards from the cone edge
+// -
+func NewSpotLight(position, direction t.Vec3, colour t.RGB, intensity, angle, falloff float64) (SpotLight, error) {
+	if direction.IsNearZero() {
+		return SpotLight{}, ErrInvalidDirection
+	}
+
+	if angle <= 0 || angle > 180 {
+		return SpotLight{}, ErrInvalidAngle
+	}
+
+	falloff = math.Max(0, math.Min(falloff, angle))
+
+	return SpotLight{
+		Position:  position,
+		Direction: direction.NormalizeNew(),
+		Colour:    colour,
+		Intensity: intensity,
+		cosOuter:  math.Cos(angle * math.Pi / 180.0),
+		cosInner:  math.Cos((angle - falloff) * math.Pi / 180.0),
+	}, nil
+}
+
+func (l SpotLight) illuminate(from t.Vec3) (t.Vec3, float64, t.RGB) {
+	toLight := l.Position.SubNew(from)
+	dist := toLight.Length()
+	dir := toLight.DivNew(dist)
+
+	cosAngle := l.Direction.Dot(dir.NegateNew())
+	if cosAngle <= l.cosOuter {
+		return dir, dist, t.Black()
+	}
+
+	// Smoothstep between the inner and outer edges of the cone
+	edge := 1.0
+	if cosAngle < l.cosInner {
+		x := (cosAngle - l.cosOuter) / (l.cosInner - l.cosOuter)
+		edge = x * x * (3 - 2*x)
+	}
+
+	radiance := l.Colour.MultScalarNew(l.Intensity * edge / (dist * dist))
+
+	return dir, dist, radiance
+}
+
+func (l SpotLight) Type() string {
+	return "spot"
+}
+
+// ============================================================
+// Directional light, e.g. the sun, infinitely far away
+// ============================================================
+
+type DirectionalLight struct {
+	Direction t.Vec3 // Direction the light is travelling
+	Colour    t.RGB
+	Intensity float64
+}
+
+func NewDirectionalLight(direction t.Vec3, colour t.RGB, intensity float64) (DirectionalLight, error) {
+	if direction.IsNearZero() {
+		return DirectionalLight{}, ErrInvalidDirection
+	}
+
+	return DirectionalLight{
+		Direction: direction.NormalizeNew(),
+		Colour:    colour,
+		Intensity: intensity,
+	}, nil
+}
+
+func (l DirectionalLight) illuminate(from t.Vec3) (t.Vec3, float64, t.RGB) {
+	return l.Direction.NegateNew(), math.MaxFloat64, l.Colour.MultScalarNew(l.Intensity)
+}
+
+func (l DirectionalLight) Type() string {
+	return "directional"
+}
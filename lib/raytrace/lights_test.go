@@ -0,0 +1,170 @@
+package raytrace
+
+import (
+	"math"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPointLightFalloff(t *testing.T) {
+	light := NewPointLight(tu.Vec3{Y: 10}, tu.RGB{R: 1, G: 0.5, B: 0.25}, 50)
+
+	tests := []struct {
+		from tu.Vec3
+		dist float64
+	}{
+		{tu.Vec3{Y: 5}, 5},
+		{tu.Vec3{}, 10},
+		{tu.Vec3{X: 6, Y: 2}, 10},
+		{tu.Vec3{Y: 30}, 20},
+	}
+
+	for _, test := range tests {
+		dir, dist, radiance := light.illuminate(test.from)
+
+		if math.Abs(dist-test.dist) > 1e-9 {
+			t.Errorf("from %v: dist = %v, want %v", test.from, dist, test.dist)
+		}
+
+		if !vecNear(test.from.AddNew(dir.MultScalarNew(dist)), light.Position) {
+			t.Errorf("from %v: dir %v doesn't point at the light", test.from, dir)
+		}
+
+		// Inverse square law
+		want := 50 / (test.dist * test.dist)
+		if math.Abs(radiance.R-want) > 1e-9 || math.Abs(radiance.G-want/2) > 1e-9 || math.Abs(radiance.B-want/4) > 1e-9 {
+			t.Errorf("from %v: radiance = %v, want %v scaled by colour", test.from, radiance, want)
+		}
+	}
+}
+
+func TestSpotLightFalloff(t *testing.T) {
+	// Pointing straight down, 30 degree cone, soft edge from 20 to 30 degrees
+	light, err := NewSpotLight(tu.Vec3{Y: 10}, tu.Vec3{Y: -3}, tu.White(), 100, 30, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Point at a given angle off the spot axis, always 10 away from the light
+	at := func(degrees float64) tu.Vec3 {
+		s, c := math.Sincos(degrees * math.Pi / 180)
+		return tu.Vec3{X: 10 * s, Y: 10 - 10*c}
+	}
+
+	smooth := func(degrees float64) float64 {
+		cos := math.Cos(degrees * math.Pi / 180)
+		x := (cos - math.Cos(30*math.Pi/180)) / (math.Cos(20*math.Pi/180) - math.Cos(30*math.Pi/180))
+		return x * x * (3 - 2*x)
+	}
+
+	tests := []struct {
+		degrees float64
+		want    float64
+	}{
+		{0, 1},
+		{15, 1},
+		{19.9, 1},
+		{22, smooth(22)},
+		{25, smooth(25)},
+		{29, smooth(29)},
+		{30.1, 0},
+		{90, 0},
+		{150, 0},
+	}
+
+	last := 2.0
+	for _, test := range tests {
+		_, dist, radiance := light.illuminate(at(test.degrees))
+
+		if math.Abs(dist-10) > 1e-9 {
+			t.Errorf("%v degrees: dist = %v, want 10", test.degrees, dist)
+		}
+
+		got := radiance.R
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%v degrees: radiance = %v, want %v", test.degrees, got, test.want)
+		}
+
+		// Light must fade out smoothly, never getting brighter towards the edge
+		if got > last {
+			t.Errorf("%v degrees: radiance %v is brighter than %v closer to the axis", test.degrees, got, last)
+		}
+		last = got
+	}
+
+	// Half way through the soft edge, in terms of cosine, is exactly half brightness
+	if got := smooth(math.Acos((math.Cos(20*math.Pi/180)+math.Cos(30*math.Pi/180))/2) * 180 / math.Pi); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("smoothstep mid point = %v, want 0.5", got)
+	}
+
+	// Further away the same angle is dimmer by the inverse square
+	_, _, near := light.illuminate(tu.Vec3{Y: 5})
+	_, _, far := light.illuminate(tu.Vec3{Y: -10})
+	if math.Abs(near.R-4) > 1e-9 || math.Abs(far.R-0.25) > 1e-9 {
+		t.Errorf("radiance at 5 and 20 = %v and %v, want 4 and 0.25", near.R, far.R)
+	}
+}
+
+func TestDirectionalLight(t *testing.T) {
+	light, err := NewDirectionalLight(tu.Vec3{X: 1, Y: -1}, tu.RGB{R: 0.5, G: 0.5, B: 0.5}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, from := range []tu.Vec3{{}, {X: 100, Y: -50}, {Z: 1e6}} {
+		dir, dist, radiance := light.illuminate(from)
+
+		if !vecNear(dir, tu.Vec3{X: -1, Y: 1}.NormalizeNew()) {
+			t.Errorf("from %v: dir = %v, want towards the light", from, dir)
+		}
+
+		if dist != math.MaxFloat64 {
+			t.Errorf("from %v: dist = %v, want infinitely far", from, dist)
+		}
+
+		// No falloff at all
+		if math.Abs(radiance.R-1.5) > 1e-9 {
+			t.Errorf("from %v: radiance = %v, want 1.5", from, radiance.R)
+		}
+	}
+
+	if _, err := NewDirectionalLight(tu.Vec3{}, tu.White(), 1); err != ErrInvalidDirection {
+		t.Errorf("zero direction: got error %v, want %v", err, ErrInvalidDirection)
+	}
+}
+
+func TestParseLightIntensity(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		intensity float64
+	}{
+		{"missing", "type: point", 1},
+		{"zero", "type: point\nintensity: 0", 0},
+		{"given", "type: point\nintensity: 3.5", 3.5},
+	}
+
+	for _, test := range tests {
+		var fileLight FileLight
+		if err := yaml.Unmarshal([]byte(test.source), &fileLight); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		light, err := parseLight(fileLight)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		point := light.(PointLight)
+		if point.Intensity != test.intensity {
+			t.Errorf("%s: intensity is %v, want %v", test.name, point.Intensity, test.intensity)
+		}
+
+		if _, _, radiance := point.illuminate(tu.Vec3{Y: -2}); radiance.IsBlack() != (test.intensity == 0) {
+			t.Errorf("%s: radiance is %v", test.name, radiance)
+		}
+	}
+}
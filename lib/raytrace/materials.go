@@ -2,18 +2,25 @@ package raytrace
 
 import (
 	"math"
-	"math/rand"
 	t "nanoray/lib/tuples"
 )
 
 // Material interface for all materials
 type Material interface {
 	// Used when shading a hit point on this material
-	scatter(r Ray, hit Hit) (didScatter bool, scattedRay Ray, attenuation t.RGB)
+	scatter(r Ray, hit Hit, smp Sampler) (didScatter bool, scattedRay Ray, attenuation t.RGB)
 
 	// Used when calculating emitted light from this material
 	emitted(r Ray, hit Hit) t.RGB
 
+	// Evaluate the BSDF for light arriving from direction wi, including the cosine term
+	// Specular materials can't be evaluated for a given direction, and return black
+	eval(r Ray, hit Hit, wi t.Vec3) t.RGB
+
+	// Probability density that scatter would choose direction wi, in solid angle
+	// Zero for specular materials, so they are skipped when sampling lights
+	pdf(r Ray, hit Hit, wi t.Vec3) float64
+
 	Type() string
 }
 
@@ -22,13 +29,13 @@ type Material interface {
 // ============================================================
 
 type DiffuseMaterial struct {
-	Albedo t.RGB
+	Albedo Texture
 }
 
 // -
-// Create a new DiffuseMaterial with given albedo color
+// Create a new DiffuseMaterial with given albedo texture
 // -
-func NewDiffuseMaterial(albedo t.RGB) DiffuseMaterial {
+func NewDiffuseMaterial(albedo Texture) DiffuseMaterial {
 	return DiffuseMaterial{
 		Albedo: albedo,
 	}
@@ -37,22 +44,46 @@ func NewDiffuseMaterial(albedo t.RGB) DiffuseMaterial {
 // -
 // Scattering function for a diffuse material
 // -
-func (m DiffuseMaterial) scatter(r Ray, hit Hit) (bool, Ray, t.RGB) {
+func (m DiffuseMaterial) scatter(r Ray, hit Hit, smp Sampler) (bool, Ray, t.RGB) {
 	// Scatter in a random direction in unit sphere around the normal
-	scatterDir := hit.Normal.AddNew(t.RandVecSphere(true))
+	scatterDir := hit.Normal.AddNew(t.RandVecSphere(smp.Get2D()))
 	if scatterDir.IsNearZero() {
 		scatterDir = hit.Normal
 	}
 
 	scatterRay := NewRay(hit.Pos, scatterDir)
 
-	return true, scatterRay, m.Albedo
+	return true, scatterRay, m.Albedo.value(hit.UV, hit.Pos)
 }
 
 func (m DiffuseMaterial) emitted(r Ray, hit Hit) t.RGB {
 	return t.Black()
 }
 
+// -
+// Lambertian BSDF is albedo/π, multiplied here by the cosine term
+// -
+func (m DiffuseMaterial) eval(r Ray, hit Hit, wi t.Vec3) t.RGB {
+	cosine := hit.Normal.Dot(wi.NormalizeNew())
+	if cosine <= 0 {
+		return t.Black()
+	}
+
+	return m.Albedo.value(hit.UV, hit.Pos).MultScalarNew(cosine / math.Pi)
+}
+
+// -
+// Scatter picks directions with a cosine distribution around the normal
+// -
+func (m DiffuseMaterial) pdf(r Ray, hit Hit, wi t.Vec3) float64 {
+	cosine := hit.Normal.Dot(wi.NormalizeNew())
+	if cosine <= 0 {
+		return 0
+	}
+
+	return cosine / math.Pi
+}
+
 func (m DiffuseMaterial) Type() string {
 	return "diffuse"
 }
@@ -62,40 +93,52 @@ func (m DiffuseMaterial) Type() string {
 // ============================================================
 
 type MetalMaterial struct {
-	Albedo t.RGB
+	Albedo Texture
 	Fuzz   float64 // Brushed or fuzzy look of the metal
 }
 
 // -
-// Create a new MetalMaterial with given albedo color and fuzziness
+// Create a new MetalMaterial with given albedo texture and fuzziness
 // -
-func NewMetalMaterial(albedo t.RGB, fuzz float64) MetalMaterial {
+func NewMetalMaterial(albedo Texture, fuzz float64) MetalMaterial {
 	return MetalMaterial{
 		Albedo: albedo,
 		Fuzz:   math.Max(0, math.Min(fuzz, 1)),
 	}
 }
 
-func (m MetalMaterial) scatter(r Ray, hit Hit) (bool, Ray, t.RGB) {
+func (m MetalMaterial) scatter(r Ray, hit Hit, smp Sampler) (bool, Ray, t.RGB) {
 	// Metal is reflective
 	scatterDir := r.Dir.Reflect(hit.Normal)
 	scatterDir.Normalize()
 
 	// Add some randomness to the reflected ray
-	fuzz := t.RandVecSphere(false)
+	u, v := smp.Get2D()
+	fuzz := t.RandVecBall(u, v, smp.Get1D())
 	fuzz.MultScalar(m.Fuzz)
 	scatterDir.Add(fuzz)
 
 	scatterRay := NewRay(hit.Pos, scatterDir)
 
 	didScatter := scatterRay.Dir.Dot(hit.Normal) > 0
-	return didScatter, scatterRay, m.Albedo
+	return didScatter, scatterRay, m.Albedo.value(hit.UV, hit.Pos)
 }
 
 func (m MetalMaterial) emitted(r Ray, hit Hit) t.RGB {
 	return t.Black()
 }
 
+// -
+// Metal is treated as specular, even with fuzz, so isn't sampled with lights
+// -
+func (m MetalMaterial) eval(r Ray, hit Hit, wi t.Vec3) t.RGB {
+	return t.Black()
+}
+
+func (m MetalMaterial) pdf(r Ray, hit Hit, wi t.Vec3) float64 {
+	return 0
+}
+
 func (m MetalMaterial) Type() string {
 	return "metal"
 }
@@ -107,13 +150,13 @@ func (m MetalMaterial) Type() string {
 type DielectricMaterial struct {
 	IOR  float64
 	Fuzz float64
-	Tint t.RGB
+	Tint Texture
 }
 
 // -
 // Create a new DielectricMaterial with given index of refraction
 // -
-func NewDielectricMaterial(ior float64, fuzz float64, tint t.RGB) DielectricMaterial {
+func NewDielectricMaterial(ior float64, fuzz float64, tint Texture) DielectricMaterial {
 	if fuzz < 0 {
 		fuzz = 0
 	}
@@ -125,8 +168,8 @@ func NewDielectricMaterial(ior float64, fuzz float64, tint t.RGB) DielectricMate
 	}
 }
 
-func (m DielectricMaterial) scatter(r Ray, hit Hit) (bool, Ray, t.RGB) {
-	attenuation := m.Tint
+func (m DielectricMaterial) scatter(r Ray, hit Hit, smp Sampler) (bool, Ray, t.RGB) {
+	attenuation := m.Tint.value(hit.UV, hit.Pos)
 	ri := m.IOR
 	if hit.Front {
 		ri = 1.0 / m.IOR
@@ -137,7 +180,7 @@ func (m DielectricMaterial) scatter(r Ray, hit Hit) (bool, Ray, t.RGB) {
 	reflect := ri*sinTheta > 1.0
 
 	var scatterDir t.Vec3
-	if reflect || reflectance(cosTheta, ri) > rand.Float64() {
+	if choice := smp.Get1D(); reflect || reflectance(cosTheta, ri) > choice {
 		// Reflect the ray
 		scatterDir = r.Dir.Reflect(hit.Normal)
 	} else {
@@ -145,7 +188,8 @@ func (m DielectricMaterial) scatter(r Ray, hit Hit) (bool, Ray, t.RGB) {
 		scatterDir = r.Dir.Refract(hit.Normal, ri)
 		// Fuzz can give us a frosted glass effect
 		if m.Fuzz > 0 {
-			fuzz := t.RandVecSphere(false)
+			u, v := smp.Get2D()
+			fuzz := t.RandVecBall(u, v, smp.Get1D())
 			fuzz.MultScalar(m.Fuzz)
 			scatterDir.Add(fuzz)
 		}
@@ -159,6 +203,17 @@ func (m DielectricMaterial) emitted(r Ray, hit Hit) t.RGB {
 	return t.Black()
 }
 
+// -
+// Dielectrics are specular, light only arrives through scattered rays
+// -
+func (m DielectricMaterial) eval(r Ray, hit Hit, wi t.Vec3) t.RGB {
+	return t.Black()
+}
+
+func (m DielectricMaterial) pdf(r Ray, hit Hit, wi t.Vec3) float64 {
+	return 0
+}
+
 func (m DielectricMaterial) Type() string {
 	return "dielectric"
 }
@@ -168,23 +223,226 @@ func (m DielectricMaterial) Type() string {
 // ============================================================
 
 type LightMaterial struct {
-	Emission t.RGB
+	Emission Texture
 }
 
-func NewLightMaterial(emission t.RGB) LightMaterial {
+func NewLightMaterial(emission Texture) LightMaterial {
 	return LightMaterial{
 		Emission: emission,
 	}
 }
 
-func (m LightMaterial) scatter(r Ray, hit Hit) (bool, Ray, t.RGB) {
+func (m LightMaterial) scatter(r Ray, hit Hit, smp Sampler) (bool, Ray, t.RGB) {
 	return false, Ray{}, t.Black()
 }
 
 func (m LightMaterial) emitted(r Ray, hit Hit) t.RGB {
-	return m.Emission
+	return m.Emission.value(hit.UV, hit.Pos)
+}
+
+// -
+// Lights don't scatter, so there is nothing to evaluate
+// -
+func (m LightMaterial) eval(r Ray, hit Hit, wi t.Vec3) t.RGB {
+	return t.Black()
+}
+
+func (m LightMaterial) pdf(r Ray, hit Hit, wi t.Vec3) float64 {
+	return 0
 }
 
 func (m LightMaterial) Type() string {
 	return "light"
 }
+
+// ============================================================
+// Physically based material, metallic/roughness workflow with a GGX microfacet
+// specular lobe over a diffuse base
+// ============================================================
+
+type PBRMaterial struct {
+	BaseColour Texture
+	Metallic   float64 // 0 for dielectrics like plastic, 1 for bare metal
+	Roughness  float64 // 0 is a perfect mirror, 1 is fully rough
+	Specular   float64 // Reflectance of dielectrics, 0.5 is a typical 4%
+	Emission   Texture
+}
+
+// -
+// Create a new PBRMaterial, parameters are clamped to the range 0 to 1
+// -
+func NewPBRMaterial(baseColour Texture, metallic, roughness, specular float64, emission Texture) PBRMaterial {
+	if emission == nil {
+		emission = NewSolidTexture(t.Black())
+	}
+
+	return PBRMaterial{
+		BaseColour: baseColour,
+		Metallic:   math.Max(0, math.Min(metallic, 1)),
+		Roughness:  math.Max(0, math.Min(roughness, 1)),
+		Specular:   math.Max(0, math.Min(specular, 1)),
+		Emission:   emission,
+	}
+}
+
+// Values derived from the material parameters at a hit point
+type pbrLobes struct {
+	normal    t.Vec3
+	wo        t.Vec3 // Direction back towards where the ray came from
+	diffuse   t.RGB
+	f0        t.RGB   // Fresnel reflectance at normal incidence
+	alpha     float64 // GGX width, the square of roughness
+	probSpec  float64 // Chance of sampling the specular lobe rather than diffuse
+	cosThetaO float64
+}
+
+func (m PBRMaterial) lobes(r Ray, hit Hit) pbrLobes {
+	base := m.BaseColour.value(hit.UV, hit.Pos)
+	wo := r.Dir.NegateNew().NormalizeNew()
+
+	// Metals have a coloured specular and no diffuse
+	f0 := t.RGB{R: 0.08, G: 0.08, B: 0.08}.MultScalarNew(m.Specular).Blend(base, m.Metallic)
+	diffuse := base.MultScalarNew(1 - m.Metallic)
+
+	// Pick lobes in proportion to roughly how much light each one reflects
+	cosThetaO := math.Max(hit.Normal.Dot(wo), 1e-4)
+	specWeight := luminance(schlickFresnel(f0, cosThetaO))
+	diffWeight := luminance(diffuse)
+	probSpec := 1.0
+	if specWeight+diffWeight > 0 {
+		probSpec = specWeight / (specWeight + diffWeight)
+	}
+
+	return pbrLobes{
+		normal:    hit.Normal,
+		wo:        wo,
+		diffuse:   diffuse,
+		f0:        f0,
+		alpha:     math.Max(m.Roughness*m.Roughness, 1e-3),
+		probSpec:  probSpec,
+		cosThetaO: cosThetaO,
+	}
+}
+
+func (m PBRMaterial) scatter(r Ray, hit Hit, smp Sampler) (bool, Ray, t.RGB) {
+	l := m.lobes(r, hit)
+	basis := newONB(l.normal)
+
+	var wi t.Vec3
+	if smp.Get1D() < l.probSpec {
+		// Sample a microfacet normal from the GGX distribution and reflect about it
+		u1, u2 := smp.Get2D()
+		cosTheta := math.Sqrt((1 - u1) / (1 + (l.alpha*l.alpha-1)*u1))
+		sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+		phi := 2 * math.Pi * u2
+
+		h := basis.local(math.Cos(phi)*sinTheta, math.Sin(phi)*sinTheta, cosTheta)
+		wi = h.MultScalarNew(2 * l.wo.Dot(h)).SubNew(l.wo)
+	} else {
+		// Cosine weighted direction for the diffuse lobe
+		u1, u2 := smp.Get2D()
+		radius := math.Sqrt(u1)
+		phi := 2 * math.Pi * u2
+
+		wi = basis.local(radius*math.Cos(phi), radius*math.Sin(phi), math.Sqrt(math.Max(0, 1-u1)))
+	}
+
+	pdf := l.pdf(wi)
+	if pdf <= 0 {
+		return false, Ray{}, t.Black()
+	}
+
+	return true, NewRay(hit.Pos, wi), l.eval(wi).MultScalarNew(1 / pdf)
+}
+
+func (m PBRMaterial) emitted(r Ray, hit Hit) t.RGB {
+	return m.Emission.value(hit.UV, hit.Pos)
+}
+
+func (m PBRMaterial) eval(r Ray, hit Hit, wi t.Vec3) t.RGB {
+	return m.lobes(r, hit).eval(wi.NormalizeNew())
+}
+
+func (m PBRMaterial) pdf(r Ray, hit Hit, wi t.Vec3) float64 {
+	return m.lobes(r, hit).pdf(wi.NormalizeNew())
+}
+
+func (m PBRMaterial) Type() string {
+	return "pbr"
+}
+
+// -
+// BRDF times the cosine term, for a normalized incoming direction
+// -
+func (l pbrLobes) eval(wi t.Vec3) t.RGB {
+	cosThetaI := l.normal.Dot(wi)
+	if cosThetaI <= 0 {
+		return t.Black()
+	}
+
+	h := wi.AddNew(l.wo).NormalizeNew()
+	cosThetaH := math.Max(l.normal.Dot(h), 0)
+	cosThetaD := math.Max(wi.Dot(h), 0)
+
+	fresnel := schlickFresnel(l.f0, cosThetaD)
+
+	// Cook-Torrance specular, D * G * F / (4 * cosI * cosO)
+	spec := fresnel.MultScalarNew(ggxD(cosThetaH, l.alpha) *
+		smithG1(cosThetaI, l.alpha) * smithG1(l.cosThetaO, l.alpha) / (4 * cosThetaI * l.cosThetaO))
+
+	// Light reflected by the specular layer never reaches the diffuse base
+	diffuse := l.diffuse.MultNew(t.White().SubNew(fresnel)).MultScalarNew(1 / math.Pi)
+
+	return spec.AddNew(diffuse).MultScalarNew(cosThetaI)
+}
+
+// -
+// Pdf of scatter choosing a normalized direction, mixing both lobes
+// -
+func (l pbrLobes) pdf(wi t.Vec3) float64 {
+	cosThetaI := l.normal.Dot(wi)
+	if cosThetaI <= 0 {
+		return 0
+	}
+
+	h := wi.AddNew(l.wo).NormalizeNew()
+	cosThetaH := math.Max(l.normal.Dot(h), 0)
+	cosThetaD := math.Abs(wi.Dot(h))
+	if cosThetaD < 1e-8 {
+		return 0
+	}
+
+	pdfSpec := ggxD(cosThetaH, l.alpha) * cosThetaH / (4 * cosThetaD)
+	pdfDiff := cosThetaI / math.Pi
+
+	return l.probSpec*pdfSpec + (1-l.probSpec)*pdfDiff
+}
+
+// -
+// GGX (Trowbridge-Reitz) distribution of microfacet normals
+// -
+func ggxD(cosThetaH, alpha float64) float64 {
+	a2 := alpha * alpha
+	d := cosThetaH*cosThetaH*(a2-1) + 1
+	return a2 / (math.Pi * d * d)
+}
+
+// -
+// Smith masking for GGX, the fraction of microfacets visible from a direction
+// -
+func smithG1(cosTheta, alpha float64) float64 {
+	a2 := alpha * alpha
+	return 2 * cosTheta / (cosTheta + math.Sqrt(a2+(1-a2)*cosTheta*cosTheta))
+}
+
+// -
+// Schlick's approximation of Fresnel reflectance, for a coloured f0
+// -
+func schlickFresnel(f0 t.RGB, cosine float64) t.RGB {
+	weight := math.Pow(1-math.Max(0, math.Min(cosine, 1)), 5)
+	return f0.Blend(t.White(), weight)
+}
+
+func luminance(c t.RGB) float64 {
+	return 0.2126*c.R + 0.7152*c.G + 0.0722*c.B
+}
@@ -0,0 +1,112 @@
+package raytrace
+
+import (
+	"math"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+)
+
+// Direction on the unit hemisphere around +Z
+func hemisphereDir(theta, phi float64) tu.Vec3 {
+	sinTheta, cosTheta := math.Sincos(theta)
+	return tu.Vec3{X: sinTheta * math.Cos(phi), Y: sinTheta * math.Sin(phi), Z: cosTheta}
+}
+
+// -
+// Integrate a function over the hemisphere around +Z with the midpoint rule
+// -
+func integrateHemisphere(steps int, f func(wi tu.Vec3) float64) float64 {
+	dTheta := math.Pi / 2 / float64(steps)
+	dPhi := 2 * math.Pi / float64(steps*2)
+	total := 0.0
+
+	for i := 0; i < steps; i++ {
+		theta := (float64(i) + 0.5) * dTheta
+		for j := 0; j < steps*2; j++ {
+			phi := (float64(j) + 0.5) * dPhi
+			total += f(hemisphereDir(theta, phi)) * math.Sin(theta) * dTheta * dPhi
+		}
+	}
+
+	return total
+}
+
+func TestPBREvalPdfConsistent(t *testing.T) {
+	base := NewSolidTexture(tu.RGB{R: 0.9, G: 0.6, B: 0.3})
+	hit := Hit{Normal: tu.Vec3{Z: 1}, Front: true}
+
+	tests := []struct {
+		name      string
+		metallic  float64
+		roughness float64
+		viewAngle float64 // Degrees from the normal
+	}{
+		{"rough plastic", 0, 0.8, 0},
+		{"rough plastic grazing", 0, 0.8, 60},
+		{"satin plastic", 0, 0.5, 30},
+		{"rough metal", 1, 0.7, 20},
+		{"mixed", 0.5, 0.6, 45},
+	}
+
+	for _, test := range tests {
+		m := NewPBRMaterial(base, test.metallic, test.roughness, 0.5, nil)
+		wo := hemisphereDir(test.viewAngle*math.Pi/180, 0.3)
+		r := Ray{Dir: wo.NegateNew()}
+
+		// Scatter reflects about GGX sampled microfacet normals, the ones reflecting
+		// below the horizon are lost, so the pdf integrates to what is left
+		l := m.lobes(r, hit)
+		kept := integrateHemisphere(200, func(h tu.Vec3) float64 {
+			if h.MultScalarNew(2*wo.Dot(h)).SubNew(wo).Z <= 0 {
+				return 0
+			}
+
+			return ggxD(h.Z, l.alpha) * h.Z
+		})
+
+		pdfTotal := integrateHemisphere(200, func(wi tu.Vec3) float64 {
+			return m.pdf(r, hit, wi)
+		})
+
+		want := l.probSpec*kept + (1 - l.probSpec)
+		if math.Abs(pdfTotal-want) > 0.005 {
+			t.Errorf("%s: pdf integrates to %v, want %v", test.name, pdfTotal, want)
+		}
+
+		// Reflected light can never exceed what arrives, in any channel
+		albedo := integrateHemisphere(200, func(wi tu.Vec3) float64 {
+			return m.eval(r, hit, wi).R
+		})
+
+		if albedo > 1.001 || albedo <= 0 {
+			t.Errorf("%s: red albedo is %v", test.name, albedo)
+		}
+
+		for i := 0; i < 90; i += 7 {
+			for j := 0; j < 360; j += 31 {
+				wi := hemisphereDir(float64(i)*math.Pi/180, float64(j)*math.Pi/180)
+				f := m.eval(r, hit, wi)
+				pdf := m.pdf(r, hit, wi)
+
+				// Any direction that reflects light must be reachable by scatter
+				if !f.IsBlack() && pdf <= 0 {
+					t.Errorf("%s: eval %v with zero pdf towards %v", test.name, f, wi)
+				}
+
+				// BRDF is reciprocal, swapping the directions divides out the cosines
+				swapped := m.eval(Ray{Dir: wi.NegateNew()}, hit, wo)
+				cosI, cosO := wi.Z, wo.Z
+				if cosI > 0.05 && math.Abs(f.G/cosI-swapped.G/cosO) > 1e-9*math.Max(1, f.G/cosI) {
+					t.Errorf("%s: BRDF not reciprocal between %v and %v: %v vs %v", test.name, wi, wo, f.G/cosI, swapped.G/cosO)
+				}
+			}
+		}
+
+		// Nothing is reflected or sampled below the surface
+		below := tu.Vec3{X: 0.5, Z: -0.5}
+		if !m.eval(r, hit, below).IsBlack() || m.pdf(r, hit, below) != 0 {
+			t.Errorf("%s: light reflected from below the surface", test.name)
+		}
+	}
+}
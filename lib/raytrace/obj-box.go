@@ -0,0 +1,192 @@
+package raytrace
+
+import (
+	t "nanoray/lib/tuples"
+)
+
+// Box is a solid axis-aligned box, defined by min & max corners
+type Box struct {
+	Object
+	Min t.Vec3
+	Max t.Vec3
+}
+
+// -
+// Create a new box from opposite corners, the corners can be given in any order
+// -
+func NewBox(corner1, corner2 t.Vec3) (*Box, error) {
+	box := AABB{corner1, corner2}
+	box = box.SurroundingBox(AABB{corner2, corner1})
+
+	size := box.Max.SubNew(box.Min)
+	if size.X <= 0 || size.Y <= 0 || size.Z <= 0 {
+		return nil, ErrInvalidSize
+	}
+
+	return &Box{
+		Object: Object{
+			Position: box.Centroid(),
+			ID:       "box_" + GenerateID("box"+box.Min.String()+box.Max.String()),
+		},
+
+		Min: box.Min,
+		Max: box.Max,
+	}, nil
+}
+
+// -
+// Create a new box from a center point and size along each axis
+// -
+func NewBoxCentered(center t.Vec3, size t.Vec3) (*Box, error) {
+	half := size.DivNew(2)
+	return NewBox(center.SubNew(half), center.AddNew(half))
+}
+
+// -
+// Implement the Hitable interface for a box, using the slab method
+// Hits on the inside are returned when the ray starts within the box
+// -
+func (b Box) Hit(r Ray, interval Interval) (bool, Hit) {
+	tNear, tFar := interval.Min, interval.Max
+	nearAxis, farAxis := -1, -1
+
+	for axis := 0; axis < 3; axis++ {
+		invD := 1.0 / r.Dir.Axis(axis)
+		t0 := (b.Min.Axis(axis) - r.Origin.Axis(axis)) * invD
+		t1 := (b.Max.Axis(axis) - r.Origin.Axis(axis)) * invD
+		if invD < 0 {
+			t0, t1 = t1, t0
+		}
+
+		if t0 > tNear {
+			tNear = t0
+			nearAxis = axis
+		}
+		if t1 < tFar {
+			tFar = t1
+			farAxis = axis
+		}
+
+		if tFar < tNear {
+			return false, Hit{}
+		}
+	}
+
+	// Entering the box, the near face was hit
+	if nearAxis >= 0 {
+		hit := r.MakeHit(tNear, b.faceNormal(r, nearAxis, -1), b.Object)
+		hit.UV = b.faceUV(hit.Pos, nearAxis)
+		return true, hit
+	}
+
+	// Ray started inside the box, so the far face was hit
+	if farAxis >= 0 {
+		hit := r.MakeHit(tFar, b.faceNormal(r, farAxis, 1), b.Object)
+		hit.UV = b.faceUV(hit.Pos, farAxis)
+		return true, hit
+	}
+
+	return false, Hit{}
+}
+
+// -
+// Texture coordinates for a point on the face at the given axis, each face is
+// mapped to the full 0 to 1 range
+// -
+func (b Box) faceUV(pos t.Vec3, axis int) UV {
+	rel := pos.SubNew(b.Min)
+	size := b.Max.SubNew(b.Min)
+
+	switch axis {
+	case 0:
+		return UV{rel.Z / size.Z, rel.Y / size.Y}
+	case 1:
+		return UV{rel.X / size.X, rel.Z / size.Z}
+	default:
+		return UV{rel.X / size.X, rel.Y / size.Y}
+	}
+}
+
+// -
+// Outward normal for the face on the given axis that the ray crosses
+// Direction is -1 for the face the ray enters and 1 for the face it leaves
+// -
+func (b Box) faceNormal(r Ray, axis int, direction float64) t.Vec3 {
+	sign := direction
+	if r.Dir.Axis(axis) < 0 {
+		sign = -direction
+	}
+
+	switch axis {
+	case 0:
+		return t.Vec3{X: sign}
+	case 1:
+		return t.Vec3{Y: sign}
+	default:
+		return t.Vec3{Z: sign}
+	}
+}
+
+func (b Box) BoundingBox() AABB {
+	return NewAABB(b.Min, b.Max)
+}
+
+// -
+// Total surface area of the box
+// -
+func (b Box) Area() float64 {
+	size := b.Max.SubNew(b.Min)
+	return 2 * (size.X*size.Y + size.Y*size.Z + size.X*size.Z)
+}
+
+// -
+// Implement the Emitter interface, sample a point uniformly over the box surface
+// -
+func (b Box) sampleDir(from t.Vec3, smp Sampler) (t.Vec3, float64, float64) {
+	size := b.Max.SubNew(b.Min)
+	areas := [3]float64{size.Y * size.Z, size.X * size.Z, size.X * size.Y}
+
+	// Pick a pair of faces by area, what's left of the pick chooses one of the two
+	pick := smp.Get1D() * (areas[0] + areas[1] + areas[2])
+	axis := 2
+	if pick < areas[0] {
+		axis = 0
+	} else if pick < areas[0]+areas[1] {
+		axis = 1
+		pick -= areas[0]
+	} else {
+		pick -= areas[0] + areas[1]
+	}
+
+	normal := t.Zero()
+	face := b.Min.Axis(axis)
+	sign := -1.0
+	if pick < areas[axis]/2 {
+		face = b.Max.Axis(axis)
+		sign = 1.0
+	}
+
+	// Then a point on that face, spread over the other two axes
+	u, v := smp.Get2D()
+	var point t.Vec3
+
+	switch axis {
+	case 0:
+		point = t.Vec3{X: face, Y: b.Min.Y + u*size.Y, Z: b.Min.Z + v*size.Z}
+		normal.X = sign
+	case 1:
+		point = t.Vec3{X: b.Min.X + u*size.X, Y: face, Z: b.Min.Z + v*size.Z}
+		normal.Y = sign
+	default:
+		point = t.Vec3{X: b.Min.X + u*size.X, Y: b.Min.Y + v*size.Y, Z: face}
+		normal.Z = sign
+	}
+
+	dir := point.SubNew(from)
+
+	return dir.NormalizeNew(), dir.Length(), areaToSolidAngle(1/b.Area(), from, point, normal)
+}
+
+func (b Box) pdfDir(from t.Vec3, hit Hit) float64 {
+	return areaToSolidAngle(1/b.Area(), from, hit.Pos, hit.Normal)
+}
@@ -0,0 +1,58 @@
+package raytrace
+
+import (
+	"math"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+)
+
+func TestBoxHit(t *testing.T) {
+	box, err := NewBox(tu.Vec3{X: 1, Y: 1, Z: 1}, tu.Vec3{X: -1, Y: -1, Z: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := Interval{Min: 0.001, Max: math.Inf(1)}
+
+	tests := []struct {
+		name     string
+		ray      Ray
+		interval Interval
+		hit      bool
+		t        float64
+		normal   tu.Vec3
+		front    bool
+	}{
+		{"front +z", Ray{Origin: tu.Vec3{Z: 5}, Dir: tu.Vec3{Z: -1}}, all, true, 4, tu.Vec3{Z: 1}, true},
+		{"front -x", Ray{Origin: tu.Vec3{X: -5}, Dir: tu.Vec3{X: 1}}, all, true, 4, tu.Vec3{X: -1}, true},
+		{"front +y", Ray{Origin: tu.Vec3{Y: 3, X: 0.5}, Dir: tu.Vec3{Y: -2}}, all, true, 1, tu.Vec3{Y: 1}, true},
+		{"inside", Ray{Origin: tu.Vec3{}, Dir: tu.Vec3{X: 1}}, all, true, 1, tu.Vec3{X: -1}, false},
+		{"miss beside", Ray{Origin: tu.Vec3{X: 2, Z: 5}, Dir: tu.Vec3{Z: -1}}, all, false, 0, tu.Vec3{}, false},
+		{"miss behind", Ray{Origin: tu.Vec3{Z: 5}, Dir: tu.Vec3{Z: 1}}, all, false, 0, tu.Vec3{}, false},
+		{"beyond interval", Ray{Origin: tu.Vec3{Z: 5}, Dir: tu.Vec3{Z: -1}}, Interval{Min: 0.001, Max: 3}, false, 0, tu.Vec3{}, false},
+	}
+
+	for _, test := range tests {
+		hit, h := box.Hit(test.ray, test.interval)
+		if hit != test.hit {
+			t.Errorf("%s: hit = %v, want %v", test.name, hit, test.hit)
+			continue
+		}
+
+		if !hit {
+			continue
+		}
+
+		if math.Abs(h.T-test.t) > 1e-9 || h.Normal != test.normal || h.Front != test.front {
+			t.Errorf("%s: got t=%v normal=%v front=%v, want t=%v normal=%v front=%v",
+				test.name, h.T, h.Normal, h.Front, test.t, test.normal, test.front)
+		}
+	}
+}
+
+func TestNewBoxInvalid(t *testing.T) {
+	if _, err := NewBox(tu.Vec3{X: 1, Y: 1, Z: 1}, tu.Vec3{X: 1, Y: -1, Z: -1}); err != ErrInvalidSize {
+		t.Errorf("flat box: got error %v, want %v", err, ErrInvalidSize)
+	}
+}
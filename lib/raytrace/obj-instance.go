@@ -0,0 +1,65 @@
+package raytrace
+
+import (
+	t "nanoray/lib/tuples"
+)
+
+// Instance places a shared object in the world with its own transform
+// Rays are transformed into the object's space for hit testing, so the wrapped
+// object can be placed many times without copying its geometry
+type Instance struct {
+	Object
+	Hitable   Hitable
+	Transform Transform
+
+	box AABB
+}
+
+// -
+// Create a new instance of an object, with the given transform
+// The instance material is nil, meaning the wrapped object's material is used
+// -
+func NewInstance(obj Hitable, transform Transform) *Instance {
+	position := transform.Point(t.Zero())
+
+	return &Instance{
+		Object: Object{
+			Position: position,
+			ID:       "instance_" + GenerateID("instance"+position.String()),
+		},
+
+		Hitable:   obj,
+		Transform: transform,
+		box:       transform.Bounds(obj.BoundingBox()),
+	}
+}
+
+// -
+// Implement the Hitable interface for an instance, the ray is moved into
+// object space, and the resulting hit is moved back into world space
+// -
+func (inst Instance) Hit(r Ray, interval Interval) (bool, Hit) {
+	// Note the direction is not normalized, so distance along the ray is unchanged
+	objRay := Ray{
+		Origin: inst.Transform.InversePoint(r.Origin),
+		Dir:    inst.Transform.InverseVector(r.Dir),
+	}
+
+	didHit, hit := inst.Hitable.Hit(objRay, interval)
+	if !didHit {
+		return false, Hit{}
+	}
+
+	hit.Pos = r.GetPoint(hit.T)
+	hit.Normal = inst.Transform.Normal(hit.Normal)
+
+	if inst.Material != nil {
+		hit.Obj = inst.Object
+	}
+
+	return true, hit
+}
+
+func (inst Instance) BoundingBox() AABB {
+	return inst.box
+}
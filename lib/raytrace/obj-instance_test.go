@@ -0,0 +1,75 @@
+package raytrace
+
+import (
+	"math"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+)
+
+func vecNear(a, b tu.Vec3) bool {
+	return a.SubNew(b).Length() < 1e-9
+}
+
+func TestInstanceHitTransformed(t *testing.T) {
+	sphere, err := NewSphere(tu.Vec3{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stretch along X, then rotate so the long axis points along Y, the
+	// result is an ellipsoid at (5,0,0) with semi-axes 1, 2 & 1
+	transform, err := NewTransform(tu.Vec3{X: 5}, tu.Vec3{Z: 90}, tu.Vec3{X: 2, Y: 1, Z: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst := NewInstance(sphere, transform)
+	all := Interval{Min: 0.001, Max: math.Inf(1)}
+	diag := math.Sqrt(0.5)
+
+	tests := []struct {
+		name   string
+		ray    Ray
+		hit    bool
+		t      float64
+		pos    tu.Vec3
+		normal tu.Vec3
+	}{
+		{"top of long axis", Ray{Origin: tu.Vec3{X: 5, Y: 10}, Dir: tu.Vec3{Y: -1}}, true, 8, tu.Vec3{X: 5, Y: 2}, tu.Vec3{Y: 1}},
+		{"side of short axis", Ray{Origin: tu.Vec3{}, Dir: tu.Vec3{X: 1}}, true, 4, tu.Vec3{X: 4}, tu.Vec3{X: -1}},
+		{"front", Ray{Origin: tu.Vec3{X: 5, Z: 10}, Dir: tu.Vec3{Z: -1}}, true, 9, tu.Vec3{X: 5, Z: 1}, tu.Vec3{Z: 1}},
+
+		// Unnormalized direction, t must still be in world units of the ray
+		{"oblique normal", Ray{Origin: tu.Vec3{X: 15 + diag, Y: 2 * diag}, Dir: tu.Vec3{X: -2}}, true, 5,
+			tu.Vec3{X: 5 + diag, Y: 2 * diag}, tu.Vec3{X: 2, Y: 1}.NormalizeNew()},
+
+		{"miss scaled away", Ray{Origin: tu.Vec3{X: 6.5, Y: 10}, Dir: tu.Vec3{Y: -1}}, false, 0, tu.Vec3{}, tu.Vec3{}},
+		{"would hit untransformed", Ray{Origin: tu.Vec3{Z: 10}, Dir: tu.Vec3{Z: -1}}, false, 0, tu.Vec3{}, tu.Vec3{}},
+	}
+
+	for _, test := range tests {
+		hit, h := inst.Hit(test.ray, all)
+		if hit != test.hit {
+			t.Errorf("%s: hit = %v, want %v", test.name, hit, test.hit)
+			continue
+		}
+
+		if !hit {
+			continue
+		}
+
+		if math.Abs(h.T-test.t) > 1e-9 || !vecNear(h.Pos, test.pos) || !vecNear(h.Normal, test.normal) {
+			t.Errorf("%s: got t=%v pos=%v normal=%v, want t=%v pos=%v normal=%v",
+				test.name, h.T, h.Pos, h.Normal, test.t, test.pos, test.normal)
+		}
+	}
+
+	box := inst.BoundingBox()
+	min, max := tu.Vec3{X: 4, Y: -2, Z: -1}, tu.Vec3{X: 6, Y: 2, Z: 1}
+	for axis := 0; axis < 3; axis++ {
+		if box.Min.Axis(axis) > min.Axis(axis)+1e-9 || box.Max.Axis(axis) < max.Axis(axis)-1e-9 {
+			t.Errorf("bounding box %v doesn't contain ellipsoid %v to %v", box, min, max)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package raytrace
+
+import (
+	t "nanoray/lib/tuples"
+	"sort"
+	"strconv"
+)
+
+// Mesh is a collection of triangles sharing a single material
+// The triangles have their own BVH, so large meshes are fast to hit test
+type Mesh struct {
+	Object
+	Triangles []Hitable
+	bvh       Hitable
+
+	// Running total of triangle areas, used to pick triangles when sampling as a light
+	areaCDF []float64
+}
+
+// -
+// Create a new mesh from a set of triangles
+// -
+func NewMesh(triangles []Hitable) (*Mesh, error) {
+	if len(triangles) == 0 {
+		return nil, ErrEmptyMesh
+	}
+
+	bvh := NewBVH(triangles)
+
+	areaCDF := make([]float64, len(triangles))
+	total := 0.0
+	for i, tri := range triangles {
+		total += tri.(*Triangle).Area()
+		areaCDF[i] = total
+	}
+
+	return &Mesh{
+		Object: Object{
+			Position: bvh.BoundingBox().Centroid(),
+			ID:       "mesh_" + GenerateID("mesh"+strconv.Itoa(len(triangles))+bvh.BoundingBox().Min.String()),
+		},
+
+		Triangles: triangles,
+		bvh:       bvh,
+		areaCDF:   areaCDF,
+	}, nil
+}
+
+// -
+// Implement the Hitable interface for a mesh object
+// -
+func (m Mesh) Hit(r Ray, interval Interval) (bool, Hit) {
+	didHit, hit := m.bvh.Hit(r, interval)
+	if !didHit {
+		return false, Hit{}
+	}
+
+	// Triangles carry no material, the mesh is the object that was hit
+	hit.Obj = m.Object
+
+	return true, hit
+}
+
+func (m Mesh) BoundingBox() AABB {
+	return m.bvh.BoundingBox()
+}
+
+// -
+// Implement the Emitter interface, sample a point uniformly over the mesh surface
+// -
+func (m Mesh) sampleDir(from t.Vec3, smp Sampler) (t.Vec3, float64, float64) {
+	area := m.areaCDF[len(m.areaCDF)-1]
+	i := sort.SearchFloat64s(m.areaCDF, smp.Get1D()*area)
+	if i >= len(m.Triangles) {
+		i = len(m.Triangles) - 1
+	}
+
+	point, normal := m.Triangles[i].(*Triangle).samplePoint(smp)
+	dir := point.SubNew(from)
+
+	return dir.NormalizeNew(), dir.Length(), areaToSolidAngle(1/area, from, point, normal)
+}
+
+func (m Mesh) pdfDir(from t.Vec3, hit Hit) float64 {
+	area := m.areaCDF[len(m.areaCDF)-1]
+	return areaToSolidAngle(1/area, from, hit.Pos, hit.Normal)
+}
@@ -0,0 +1,70 @@
+package raytrace
+
+import (
+	"math"
+	t "nanoray/lib/tuples"
+)
+
+// Plane is an infinite flat plane, defined by a point on it and a normal
+type Plane struct {
+	Object
+	Normal t.Vec3
+
+	// Directions along the plane, used for texture coordinates
+	tangent   t.Vec3
+	bitangent t.Vec3
+}
+
+// -
+// Create a new plane passing through position, facing along normal
+// -
+func NewPlane(position t.Vec3, normal t.Vec3) (*Plane, error) {
+	if normal.IsNearZero() {
+		return nil, ErrInvalidNormal
+	}
+
+	basis := newONB(normal)
+
+	return &Plane{
+		Object: Object{
+			Position: position,
+			ID:       "plane_" + GenerateID("plane"+position.String()+normal.String()),
+		},
+
+		Normal:    normal.NormalizeNew(),
+		tangent:   basis.u,
+		bitangent: basis.v,
+	}, nil
+}
+
+// -
+// Implement the Hitable interface for a plane object
+// -
+func (p Plane) Hit(r Ray, interval Interval) (bool, Hit) {
+	denom := p.Normal.Dot(r.Dir)
+
+	// Ray is parallel to the plane
+	if math.Abs(denom) < 1e-12 {
+		return false, Hit{}
+	}
+
+	t := p.Position.SubNew(r.Origin).Dot(p.Normal) / denom
+	if !interval.Surrounds(t) {
+		return false, Hit{}
+	}
+
+	hit := r.MakeHit(t, p.Normal, p.Object)
+
+	// Texture coordinates are distances across the plane from its position
+	offset := hit.Pos.SubNew(p.Position)
+	hit.UV = UV{offset.Dot(p.tangent), offset.Dot(p.bitangent)}
+
+	return true, hit
+}
+
+// -
+// Planes are infinite, so the box is too, they are kept out of the BVH
+// -
+func (p Plane) BoundingBox() AABB {
+	return InfiniteAABB()
+}
@@ -0,0 +1,59 @@
+package raytrace
+
+import (
+	"math"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+)
+
+func TestPlaneHit(t *testing.T) {
+	plane, err := NewPlane(tu.Vec3{Y: -1}, tu.Vec3{Y: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := Interval{Min: 0.001, Max: math.Inf(1)}
+
+	tests := []struct {
+		name     string
+		ray      Ray
+		interval Interval
+		hit      bool
+		t        float64
+		front    bool
+	}{
+		{"from above", Ray{Origin: tu.Vec3{X: 7, Y: 3}, Dir: tu.Vec3{Y: -1}}, all, true, 4, true},
+		{"from below", Ray{Origin: tu.Vec3{Y: -3}, Dir: tu.Vec3{Y: 1}}, all, true, 2, false},
+		{"oblique", Ray{Origin: tu.Vec3{}, Dir: tu.Vec3{X: 1, Y: -1}}, all, true, 1, true},
+		{"parallel", Ray{Origin: tu.Vec3{}, Dir: tu.Vec3{X: 1}}, all, false, 0, false},
+		{"away", Ray{Origin: tu.Vec3{}, Dir: tu.Vec3{Y: 1}}, all, false, 0, false},
+		{"beyond interval", Ray{Origin: tu.Vec3{Y: 3}, Dir: tu.Vec3{Y: -1}}, Interval{Min: 0.001, Max: 2}, false, 0, false},
+	}
+
+	for _, test := range tests {
+		hit, h := plane.Hit(test.ray, test.interval)
+		if hit != test.hit {
+			t.Errorf("%s: hit = %v, want %v", test.name, hit, test.hit)
+			continue
+		}
+
+		if !hit {
+			continue
+		}
+
+		if math.Abs(h.T-test.t) > 1e-9 || h.Front != test.front || h.Pos.Y != -1 {
+			t.Errorf("%s: got t=%v pos=%v front=%v, want t=%v front=%v", test.name, h.T, h.Pos, h.Front, test.t, test.front)
+		}
+	}
+
+	if plane.BoundingBox().IsBounded() {
+		t.Error("plane bounding box should be unbounded")
+	}
+}
+
+func TestNewPlaneInvalid(t *testing.T) {
+	if _, err := NewPlane(tu.Vec3{}, tu.Vec3{}); err != ErrInvalidNormal {
+		t.Errorf("zero normal: got error %v, want %v", err, ErrInvalidNormal)
+	}
+}
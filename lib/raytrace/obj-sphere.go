@@ -57,9 +57,80 @@ func (s Sphere) Hit(r Ray, interval Interval) (bool, Hit) {
 	if t > interval.Min && t < interval.Max {
 		normal := r.GetPoint(t).SubNew(s.Position).NormalizeNew()
 		hit := r.MakeHit(t, normal, s.Object)
+		hit.UV = sphereUV(normal)
 
 		return true, hit
 	}
 
 	return false, Hit{}
 }
+
+// -
+// Texture coordinates from a point on the unit sphere, U goes around the
+// equator starting from -X and V goes from the bottom to the top
+// -
+func sphereUV(p t.Vec3) UV {
+	return UV{
+		U: (math.Atan2(-p.Z, p.X) + math.Pi) / (2 * math.Pi),
+		V: math.Acos(math.Max(-1, math.Min(1, -p.Y))) / math.Pi,
+	}
+}
+
+// -
+// Bounding box of the sphere, used to build the BVH
+// -
+func (s Sphere) BoundingBox() AABB {
+	radius := t.Vec3{X: s.Radius, Y: s.Radius, Z: s.Radius}
+	return NewAABB(s.Position.SubNew(radius), s.Position.AddNew(radius))
+}
+
+// -
+// Implement the Emitter interface, sample a direction within the cone the
+// sphere covers as seen from the point
+// -
+func (s Sphere) sampleDir(from t.Vec3, smp Sampler) (t.Vec3, float64, float64) {
+	toCenter := s.Position.SubNew(from)
+	dist2 := toCenter.SquaredLength()
+	radius2 := s.Radius * s.Radius
+
+	// Points inside the sphere can't see it as a cone
+	if dist2 <= radius2 {
+		return t.Zero(), 0, 0
+	}
+
+	cosMax := math.Sqrt(1 - radius2/dist2)
+	u1, u2 := smp.Get2D()
+	z := 1 + u1*(cosMax-1)
+	phi := 2 * math.Pi * u2
+	sinTheta := math.Sqrt(math.Max(0, 1-z*z))
+
+	dir := newONB(toCenter).local(math.Cos(phi)*sinTheta, math.Sin(phi)*sinTheta, z)
+
+	// Nearest point where the direction meets the sphere, it grazes it at the cone's edge
+	along := dir.Dot(toCenter)
+	dist := along - math.Sqrt(math.Max(0, along*along-dist2+radius2))
+
+	return dir, dist, s.conePdf(dist2)
+}
+
+// -
+// Every direction in the cone is equally likely, so the hit itself doesn't matter
+// -
+func (s Sphere) pdfDir(from t.Vec3, hit Hit) float64 {
+	dist2 := s.Position.SubNew(from).SquaredLength()
+	if dist2 <= s.Radius*s.Radius {
+		return 0
+	}
+
+	return s.conePdf(dist2)
+}
+
+func (s Sphere) conePdf(dist2 float64) float64 {
+	radius2 := s.Radius * s.Radius
+	cosMax := math.Sqrt(1 - radius2/dist2)
+
+	// Same as 1 - cosMax but stable when the sphere is small or far away
+	solidAngle := 2 * math.Pi * (radius2 / dist2) / (1 + cosMax)
+
+	return 1 / solidAngle
+}
@@ -0,0 +1,167 @@
+package raytrace
+
+import (
+	"math"
+	t "nanoray/lib/tuples"
+)
+
+// Triangle is a single flat triangle, the building block of meshes
+type Triangle struct {
+	Object
+	V0, V1, V2 t.Vec3
+
+	// Optional per-vertex normals for smooth shading, see SetNormals
+	N0, N1, N2 t.Vec3
+	smooth     bool
+
+	// Optional per-vertex texture coordinates, see SetUVs
+	UV0, UV1, UV2 UV
+	textured      bool
+
+	edge1  t.Vec3
+	edge2  t.Vec3
+	normal t.Vec3
+}
+
+// -
+// Create a new triangle from three vertices, in counter-clockwise order
+// -
+func NewTriangle(v0, v1, v2 t.Vec3) (*Triangle, error) {
+	edge1 := v1.SubNew(v0)
+	edge2 := v2.SubNew(v0)
+
+	normal := edge1.Cross(edge2)
+	if normal.IsNearZero() {
+		return nil, ErrDegenerateTriangle
+	}
+
+	centroid := v0.AddNew(v1).AddNew(v2).DivNew(3)
+
+	return &Triangle{
+		Object: Object{
+			Position: centroid,
+			ID:       "triangle_" + GenerateID("triangle"+v0.String()+v1.String()+v2.String()),
+		},
+
+		V0:     v0,
+		V1:     v1,
+		V2:     v2,
+		edge1:  edge1,
+		edge2:  edge2,
+		normal: normal.NormalizeNew(),
+	}, nil
+}
+
+// -
+// Set vertex normals, these are interpolated across the triangle when shading
+// -
+func (tri *Triangle) SetNormals(n0, n1, n2 t.Vec3) {
+	tri.N0 = n0.NormalizeNew()
+	tri.N1 = n1.NormalizeNew()
+	tri.N2 = n2.NormalizeNew()
+	tri.smooth = true
+}
+
+// -
+// Set vertex texture coordinates
+// -
+func (tri *Triangle) SetUVs(uv0, uv1, uv2 UV) {
+	tri.UV0 = uv0
+	tri.UV1 = uv1
+	tri.UV2 = uv2
+	tri.textured = true
+}
+
+// -
+// Implement the Hitable interface for a triangle, using Möller–Trumbore
+// -
+func (tri Triangle) Hit(r Ray, interval Interval) (bool, Hit) {
+	pvec := r.Dir.Cross(tri.edge2)
+	det := tri.edge1.Dot(pvec)
+
+	// Ray is parallel to the triangle
+	if math.Abs(det) < 1e-12 {
+		return false, Hit{}
+	}
+
+	invDet := 1.0 / det
+	tvec := r.Origin.SubNew(tri.V0)
+
+	u := tvec.Dot(pvec) * invDet
+	if u < 0 || u > 1 {
+		return false, Hit{}
+	}
+
+	qvec := tvec.Cross(tri.edge1)
+	v := r.Dir.Dot(qvec) * invDet
+	if v < 0 || u+v > 1 {
+		return false, Hit{}
+	}
+
+	t := tri.edge2.Dot(qvec) * invDet
+	if !interval.Surrounds(t) {
+		return false, Hit{}
+	}
+
+	w := 1 - u - v
+	normal := tri.normal
+	if tri.smooth {
+		normal = tri.N0.MultScalarNew(w).AddNew(tri.N1.MultScalarNew(u)).AddNew(tri.N2.MultScalarNew(v))
+		normal.Normalize()
+	}
+
+	hit := r.MakeHit(t, normal, tri.Object)
+
+	// Without texture coordinates fall back to the barycentric coordinates
+	hit.UV = UV{u, v}
+	if tri.textured {
+		hit.UV = UV{
+			U: w*tri.UV0.U + u*tri.UV1.U + v*tri.UV2.U,
+			V: w*tri.UV0.V + u*tri.UV1.V + v*tri.UV2.V,
+		}
+	}
+
+	return true, hit
+}
+
+// -
+// Bounding box of the triangle, padded slightly so axis aligned triangles
+// don't produce a box with zero thickness
+// -
+func (tri Triangle) BoundingBox() AABB {
+	const pad = 1e-4
+
+	min := t.Vec3{
+		X: math.Min(tri.V0.X, math.Min(tri.V1.X, tri.V2.X)) - pad,
+		Y: math.Min(tri.V0.Y, math.Min(tri.V1.Y, tri.V2.Y)) - pad,
+		Z: math.Min(tri.V0.Z, math.Min(tri.V1.Z, tri.V2.Z)) - pad,
+	}
+
+	max := t.Vec3{
+		X: math.Max(tri.V0.X, math.Max(tri.V1.X, tri.V2.X)) + pad,
+		Y: math.Max(tri.V0.Y, math.Max(tri.V1.Y, tri.V2.Y)) + pad,
+		Z: math.Max(tri.V0.Z, math.Max(tri.V1.Z, tri.V2.Z)) + pad,
+	}
+
+	return NewAABB(min, max)
+}
+
+// -
+// Surface area of the triangle
+// -
+func (tri Triangle) Area() float64 {
+	return tri.edge1.Cross(tri.edge2).Length() / 2
+}
+
+// -
+// Pick a uniformly distributed random point on the triangle, with its normal
+// -
+func (tri Triangle) samplePoint(smp Sampler) (t.Vec3, t.Vec3) {
+	u1, u2 := smp.Get2D()
+	su := math.Sqrt(u1)
+	v := u2 * su
+
+	point := tri.V0.AddNew(tri.edge1.MultScalarNew(su - v)).AddNew(tri.edge2.MultScalarNew(v))
+
+	return point, tri.normal
+}
@@ -9,11 +9,23 @@ type Object struct {
 	ID       string
 	Position t.Vec3
 	Material Material
+
+	emitter int // Position in the scene's emitters plus one, zero if not sampled for direct lighting
+}
+
+// Implemented by all objects via the embedded Object, gives access to its fields
+type objectBase interface {
+	base() *Object
+}
+
+func (o *Object) base() *Object {
+	return o
 }
 
 // All objects must implement this interface
 type Hitable interface {
 	Hit(r Ray, i Interval) (bool, Hit)
+	BoundingBox() AABB
 }
 
 // Hit represents a ray hit against an object
@@ -23,6 +35,12 @@ type Hit struct {
 	Normal t.Vec3  // Normal at hit point
 	Obj    Object  // Ref to object that was hit
 	Front  bool    // Is the hit on the front/outside of the object
+	UV     UV      // Texture coordinates on the surface of the object
+}
+
+// UV is a 2D texture coordinate
+type UV struct {
+	U, V float64
 }
 
 func (h Hit) String() string {
@@ -0,0 +1,90 @@
+package raytrace
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+)
+
+// RadianceBuffer accumulates linear colour over many passes, with the total filter
+// weight per pixel, so the image can be averaged correctly at any point
+type RadianceBuffer struct {
+	Width   int
+	Height  int
+	Sum     []float32 // RGB triples, weighted by the reconstruction filter
+	Weight  []float32 // Filter weight of all the samples added to each pixel
+	Samples []int32
+}
+
+// -
+// Create an empty radiance buffer for the whole image
+// -
+func NewRadianceBuffer(width, height int) *RadianceBuffer {
+	return &RadianceBuffer{
+		Width:   width,
+		Height:  height,
+		Sum:     make([]float32, width*height*3),
+		Weight:  make([]float32, width*height),
+		Samples: make([]int32, width*height),
+	}
+}
+
+// -
+// Add a tile of weighted radiance from RenderRadiance, rendered with the given samples per pixel
+// -
+func (rb *RadianceBuffer) AddTile(x, y, width, height int, radiance []float32, samples int) {
+	for ty := 0; ty < height; ty++ {
+		for tx := 0; tx < width; tx++ {
+			src := (ty*width + tx) * 4
+			dst := (y+ty)*rb.Width + x + tx
+
+			rb.Sum[dst*3] += radiance[src]
+			rb.Sum[dst*3+1] += radiance[src+1]
+			rb.Sum[dst*3+2] += radiance[src+2]
+			rb.Weight[dst] += radiance[src+3]
+			rb.Samples[dst] += int32(samples)
+		}
+	}
+}
+
+// -
+// Average the samples so far into an image, pixels without samples are black
+// -
+func (rb *RadianceBuffer) Image(gamma float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, rb.Width, rb.Height))
+
+	for i, w := range rb.Weight {
+		pixel := resolvePixel(float64(rb.Sum[i*3]), float64(rb.Sum[i*3+1]), float64(rb.Sum[i*3+2]), float64(w))
+		img.Set(i%rb.Width, i/rb.Width, pixel.ToRGBA(gamma))
+	}
+
+	return img
+}
+
+// -
+// Encode radiance for sending, as little endian float32 values
+// -
+func EncodeRadiance(radiance []float32) []byte {
+	data := make([]byte, len(radiance)*4)
+	for i, v := range radiance {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+
+	return data
+}
+
+// -
+// Decode radiance sent by EncodeRadiance, checking it matches the tile size
+// -
+func DecodeRadiance(data []byte, width, height int) ([]float32, error) {
+	if len(data) != width*height*4*4 {
+		return nil, ErrTileSize
+	}
+
+	radiance := make([]float32, width*height*4)
+	for i := range radiance {
+		radiance[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+
+	return radiance, nil
+}
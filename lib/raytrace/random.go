@@ -0,0 +1,37 @@
+package raytrace
+
+import (
+	"math/rand/v2"
+)
+
+// SampleRand is the random source for rendering, reseeded for every camera sample
+// so each sample only depends on the render seed, the pixel and the sample index
+// That way the image comes out the same however it is split into jobs
+type SampleRand struct {
+	*rand.Rand
+	pcg *rand.PCG
+}
+
+// -
+// Create a random source for rendering, call Seed before each sample
+// -
+func NewSampleRand() *SampleRand {
+	pcg := rand.NewPCG(0, 0)
+	return &SampleRand{Rand: rand.New(pcg), pcg: pcg}
+}
+
+// -
+// Reseed for one sample of a pixel, cheap enough to do for every sample
+// -
+func (r *SampleRand) Seed(seed int64, pixelX, pixelY, sample int) {
+	pixel := uint64(uint32(pixelX))<<32 | uint64(uint32(pixelY))
+	r.pcg.Seed(mix64(uint64(seed)^mix64(pixel)), mix64(uint64(sample)))
+}
+
+// SplitMix64 finaliser, spreads nearby inputs across the whole 64 bits
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
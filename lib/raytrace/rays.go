@@ -22,45 +22,65 @@ func NewRay(origin, direction t.Vec3) Ray {
 // Cast a ray into the scene and return the colour it hits
 // This is the core of the entire raytracing algorithm and is recursive
 // -
-func (r Ray) Shade(scene Scene, depth int, maxDepth int) t.RGB {
+func (r Ray) Shade(scene Scene, depth int, maxDepth int, smp Sampler) t.RGB {
+	return r.shade(scene, depth, maxDepth, 0, smp)
+}
+
+// -
+// Recursive part of Shade, bsdfPdf is the pdf the previous bounce chose this
+// ray's direction with, zero for camera rays and after specular bounces
+// -
+func (r Ray) shade(scene Scene, depth int, maxDepth int, bsdfPdf float64, smp Sampler) t.RGB {
 	if depth > maxDepth {
 		return t.Black()
 	}
 
-	interval := Interval{0.001, math.MaxFloat64}
-	var hit *Hit = nil
-
-	// Main ray collision loop against all objects
-	for _, obj := range scene.Objects {
-		// Find the closest hit
-		didHit, objHit := obj.Hit(r, interval)
-		if didHit {
-			interval.Max = objHit.T
-			hit = &objHit
-		}
-	}
+	// Main ray collision test, find the closest hit against all objects
+	hit := scene.closestHit(r, Interval{0.001, math.MaxFloat64})
 
 	if hit != nil {
 		emissionColour := hit.Obj.Material.emitted(r, *hit)
 
+		// This light may also have been sampled directly at the previous bounce
+		// so weight it with MIS, to avoid counting the same light twice
+		if bsdfPdf > 0 && !emissionColour.IsBlack() {
+			lightPdf := scene.emitterPdf(r.Origin, *hit)
+			emissionColour.MultScalar(powerHeuristic(bsdfPdf, lightPdf))
+		}
+
 		// Hit something, scatter a new ray from surface based on material
-		scattered, scatterRay, attenColour := hit.Obj.Material.scatter(r, *hit)
+		scattered, scatterRay, attenColour := hit.Obj.Material.scatter(r, *hit, smp)
 		if !scattered {
 			return emissionColour
 		}
 
+		// On the last bounce the scattered ray won't be traced, so there is
+		// nothing to weight the direct light against
+		if depth == maxDepth {
+			direct := scene.sampleEmitters(r, *hit, false, smp)
+			direct.Add(scene.sampleBackground(r, *hit, false, smp))
+			direct.Add(scene.sampleLights(r, *hit))
+			return emissionColour.AddNew(direct)
+		}
+
+		// Direct lighting from a randomly chosen emissive object, the background
+		// and all lights
+		direct := scene.sampleEmitters(r, *hit, true, smp)
+		direct.Add(scene.sampleBackground(r, *hit, true, smp))
+		direct.Add(scene.sampleLights(r, *hit))
+
 		// Recurse and shade the scattered ray
-		scatterColour := scatterRay.Shade(scene, depth+1, maxDepth)
+		scatterPdf := hit.Obj.Material.pdf(r, *hit, scatterRay.Dir)
+		scatterColour := scatterRay.shade(scene, depth+1, maxDepth, scatterPdf, smp)
 		// Magic to blend the scattered colour with the attenuation colour
 		scatterColour.Mult(attenColour)
 
-		// Return the emission colour + scattered colour
-		return emissionColour.AddNew(scatterColour)
-
+		// Return the emission colour + direct light + scattered colour
+		return emissionColour.AddNew(direct).AddNew(scatterColour)
 	}
 
-	// On miss return the background colour
-	return scene.Background
+	// On miss return the light from the background
+	return scene.missColour(r, bsdfPdf)
 }
 
 // -
@@ -1,10 +1,12 @@
 package raytrace
 
 import (
+	"context"
 	"image"
 	"log"
 	"nanoray/lib/proto"
 	t "nanoray/lib/tuples"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,20 +20,464 @@ type Statistics struct {
 
 type NetworkRender struct {
 	Lock         sync.Mutex
-	JobQueue     sync.Map
+	ID           string                   // Unique ID for this render, carried on all jobs and results
+	SceneData    string                   // Scene source, sent to workers when they first pick up a job
+	SceneHash    string                   // Identifies the scene, so workers can reuse one they have already parsed
+	Assets       AssetManifest            // Files the scene references, fetched by workers from the controller
+	Details      *proto.ImageDetails      // Output image details, sent along with the scene
+	Image        *image.RGBA              // Output image, built up as results arrive
+	Prepared     map[string]bool          // Workers which have been sent this scene
+	Preparing    map[string]bool          // Workers being sent this scene right now
+	State        RenderState              // Only running renders have jobs dispatched
+	JobQueue     []*proto.JobRequest      // Jobs waiting for a worker, in render order
+	InFlight     map[int32]*InFlightJob   // Jobs sent to a worker and not yet complete, keyed by job ID
+	Copies       map[int32][]*InFlightJob // Every worker sent each job, several once it is requeued
+	Done         map[int32]bool           // Jobs with a result, used to drop late duplicates
 	JobsTotal    int
 	JobsComplete int
 	Start        time.Time
 	OutputName   string
+	Progressive  *Progressive // Only set for progressive renders
+	Heatmap      bool         // Save a heatmap of samples per pixel with the output
+	SampleCounts []int32      // Samples per pixel, kept for the heatmap of non progressive renders
+}
+
+// Progressive rendering state, the image is built up over passes of a few samples each
+type Progressive struct {
+	Radiance    *RadianceBuffer // Accumulated results of every pass
+	Gamma       float64         // Needed to turn radiance into an image
+	PassSamples int             // Samples per pixel in each pass
+	Target      int             // Samples per pixel to stop at, when there is no deadline
+	Deadline    time.Time       // No more passes are started after this, zero for no time limit
+	Passes      int             // Passes completed
+	Samples     int             // Samples per pixel covered by the completed passes
+
+	tiles     []*proto.JobRequest // Jobs from the first pass, copied for each later pass
+	nextJobID int32
+}
+
+// Lifecycle of a network render
+type RenderState string
+
+const (
+	RenderRunning   RenderState = "running"
+	RenderPaused    RenderState = "paused"
+	RenderCancelled RenderState = "cancelled"
+	RenderComplete  RenderState = "complete"
+)
+
+// A job that has been handed to a worker, and when
+type InFlightJob struct {
+	Job      *proto.JobRequest
+	WorkerID string
+	Issued   time.Time
+}
+
+// -
+// Create a network render with all jobs queued, ready to be dispatched
+// -
+func NewNetworkRender(id string, sceneData string, assets AssetManifest, r Render, jobs []*proto.JobRequest, outputName string) *NetworkRender {
+	for _, job := range jobs {
+		job.RenderID = id
+	}
+
+	return &NetworkRender{
+		ID:         id,
+		SceneData:  sceneData,
+		SceneHash:  SceneHash(sceneData, r.Width, r.Height, assets),
+		Assets:     assets,
+		Details:    r.ImageDetails(),
+		Image:      r.MakeImage(),
+		Prepared:   make(map[string]bool),
+		Preparing:  make(map[string]bool),
+		State:      RenderRunning,
+		JobQueue:   jobs,
+		InFlight:   make(map[int32]*InFlightJob),
+		Copies:     make(map[int32][]*InFlightJob),
+		Done:       make(map[int32]bool),
+		JobsTotal:  len(jobs),
+		Start:      time.Now(),
+		OutputName: outputName,
+	}
+}
+
+// -
+// Switch a new render to progressive passes of a few samples per pixel each
+// Passes continue until the render samples per pixel are reached, or with a
+// time limit until it runs out. Must be called before the render is shared
+// -
+func (nr *NetworkRender) MakeProgressive(passSamples int, timeLimit time.Duration, gamma float64) {
+	p := &Progressive{
+		Radiance:    NewRadianceBuffer(int(nr.Details.Width), int(nr.Details.Height)),
+		Gamma:       gamma,
+		PassSamples: passSamples,
+		tiles:       nr.JobQueue,
+	}
+
+	if len(nr.JobQueue) > 0 {
+		p.Target = int(nr.JobQueue[0].SamplesPerPixel)
+	}
+
+	for _, job := range nr.JobQueue {
+		p.nextJobID = max(p.nextJobID, job.Id+1)
+	}
+
+	if timeLimit > 0 {
+		p.Deadline = nr.Start.Add(timeLimit)
+	} else {
+		passes := (p.Target + passSamples - 1) / passSamples
+		nr.JobsTotal = len(nr.JobQueue) * passes
+	}
+
+	nr.Progressive = p
+	nr.JobQueue = p.passJobs()
+}
+
+// -
+// Jobs for the next pass over every tile, each with a new job ID
+// -
+func (p *Progressive) passJobs() []*proto.JobRequest {
+	samples := p.PassSamples
+	if p.Deadline.IsZero() {
+		samples = min(samples, p.Target-p.Samples)
+	}
+
+	jobs := make([]*proto.JobRequest, 0, len(p.tiles))
+	for _, tile := range p.tiles {
+		jobs = append(jobs, &proto.JobRequest{
+			Id:              p.nextJobID,
+			ImageDetails:    tile.ImageDetails,
+			X:               tile.X,
+			Y:               tile.Y,
+			Width:           tile.Width,
+			Height:          tile.Height,
+			SamplesPerPixel: int32(samples),
+			MaxDepth:        tile.MaxDepth,
+			RenderID:        tile.RenderID,
+			ResultEncoding:  string(TileRadiance),
+			Pass:            int32(p.Passes),
+			Seed:            tile.Seed,
+			SampleOffset:    int32(p.Samples),
+			Sampler:         tile.Sampler,
+			Filter:          tile.Filter,
+			FilterRadius:    tile.FilterRadius,
+		})
+
+		p.nextJobID++
+	}
+
+	return jobs
+}
+
+// -
+// Check if another pass should be started once the current one is done
+// -
+func (p *Progressive) morePasses() bool {
+	if !p.Deadline.IsZero() {
+		return time.Now().Before(p.Deadline)
+	}
+
+	return p.Samples < p.Target
+}
+
+// -
+// Mark a progressive pass job as complete and add its radiance to the render
+// When it's the last job in the pass, the image is updated and the next pass queued
+// Returns false if the job already has a result, the Lock must be held by the caller
+// -
+func (nr *NetworkRender) CompletePass(job *proto.JobRequest, radiance []float32) bool {
+	if !nr.CompleteJob(job.Id) {
+		return false
+	}
+
+	p := nr.Progressive
+	p.Radiance.AddTile(int(job.X), int(job.Y), int(job.Width), int(job.Height), radiance, int(job.SamplesPerPixel))
+
+	// Passes run one after another, so nothing left queued or in flight means this pass is done
+	if len(nr.JobQueue) > 0 || len(nr.InFlight) > 0 {
+		return true
+	}
+
+	p.Passes++
+	p.Samples += int(job.SamplesPerPixel)
+	nr.Image = p.Radiance.Image(p.Gamma)
+
+	if !p.morePasses() {
+		nr.State = RenderComplete
+		return true
+	}
+
+	jobs := p.passJobs()
+	nr.JobQueue = jobs
+	if !p.Deadline.IsZero() {
+		nr.JobsTotal += len(jobs)
+	}
+
+	return true
+}
+
+// -
+// Stop a progressive render early, keeping what has been rendered so far
+// The image is averaged from the samples each pixel has, which may vary if a pass was
+// part way through. Returns the jobs in flight, the Lock must be held by the caller
+// -
+func (nr *NetworkRender) Finish() []*InFlightJob {
+	inFlight := nr.Cancel()
+
+	nr.State = RenderComplete
+	nr.Image = nr.Progressive.Radiance.Image(nr.Progressive.Gamma)
+
+	return inFlight
+}
+
+// -
+// Heatmap of the samples taken per pixel so far
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) SampleHeatmap() *image.RGBA {
+	counts := nr.SampleCounts
+	if nr.Progressive != nil && nr.Progressive.Radiance != nil {
+		counts = nr.Progressive.Radiance.Samples
+	}
+
+	return SampleHeatmap(counts, int(nr.Details.Width), int(nr.Details.Height))
+}
+
+// -
+// Check if every job in the render has a result
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) IsComplete() bool {
+	return nr.JobsComplete == nr.JobsTotal
+}
+
+// -
+// Check if the render is still going, paused renders count as active
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) IsActive() bool {
+	return nr.State == RenderRunning || nr.State == RenderPaused
+}
+
+// -
+// Stop the render for good, dropping all queued jobs
+// Returns the jobs that were in flight so workers can be told to abort them
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) Cancel() []*InFlightJob {
+	inFlight := make([]*InFlightJob, 0, len(nr.InFlight))
+	for _, f := range nr.InFlight {
+		inFlight = append(inFlight, f)
+	}
+
+	nr.State = RenderCancelled
+	nr.JobQueue = nil
+	nr.InFlight = make(map[int32]*InFlightJob)
+	nr.Copies = make(map[int32][]*InFlightJob)
+
+	return inFlight
+}
+
+// -
+// Take the next job off the front of the queue, nil when the queue is empty
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) NextJob() *proto.JobRequest {
+	if len(nr.JobQueue) == 0 {
+		return nil
+	}
+
+	job := nr.JobQueue[0]
+	nr.JobQueue = nr.JobQueue[1:]
+
+	return job
+}
+
+// -
+// Take the next job off the queue and record it as in flight on the given worker
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) AssignJob(workerID string) *proto.JobRequest {
+	job := nr.NextJob()
+	if job == nil {
+		return nil
+	}
+
+	f := &InFlightJob{
+		Job:      job,
+		WorkerID: workerID,
+		Issued:   time.Now(),
+	}
+
+	nr.InFlight[job.Id] = f
+	nr.Copies[job.Id] = append(nr.Copies[job.Id], f)
+
+	return job
+}
+
+// -
+// Count the jobs currently in flight on the given worker
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) WorkerJobs(workerID string) int {
+	count := 0
+	for _, f := range nr.InFlight {
+		if f.WorkerID == workerID {
+			count++
+		}
+	}
+
+	return count
+}
+
+// -
+// Put an in flight job back on the front of the queue, so it is reissued next
+// The worker no longer has the job, e.g. it failed to send or sent a bad result
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) Requeue(jobID int32) {
+	f, ok := nr.InFlight[jobID]
+	if !ok {
+		return
+	}
+
+	delete(nr.InFlight, jobID)
+	nr.dropCopy(f)
+	nr.JobQueue = append([]*proto.JobRequest{f.Job}, nr.JobQueue...)
+}
+
+// -
+// Requeue every job in flight on a worker, e.g. when the worker has gone away
+// Returns the number of jobs requeued, the Lock must be held by the caller
+// -
+func (nr *NetworkRender) RequeueWorker(workerID string) int {
+	return nr.requeueWhere(func(f *InFlightJob) bool {
+		return f.WorkerID == workerID
+	}, false)
+}
+
+// -
+// Requeue every job that has been in flight for longer than the timeout, unless the
+// worker it's on is still alive, as slow jobs on a working worker are better left to run
+// The expired copies may still finish, so they are kept until one of the copies has a result
+// Returns the number of jobs requeued, the Lock must be held by the caller
+// -
+func (nr *NetworkRender) RequeueExpired(timeout time.Duration, alive func(workerID string) bool) int {
+	return nr.requeueWhere(func(f *InFlightJob) bool {
+		return time.Since(f.Issued) > timeout && !alive(f.WorkerID)
+	}, true)
+}
+
+func (nr *NetworkRender) requeueWhere(match func(*InFlightJob) bool, stillRunning bool) int {
+	var jobs []*proto.JobRequest
+
+	for id, f := range nr.InFlight {
+		if match(f) {
+			jobs = append(jobs, f.Job)
+			delete(nr.InFlight, id)
+
+			if !stillRunning {
+				nr.dropCopy(f)
+			}
+		}
+	}
+
+	// Keep the original render order at the front of the queue
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Id < jobs[j].Id
+	})
+
+	nr.JobQueue = append(jobs, nr.JobQueue...)
+
+	return len(jobs)
+}
+
+// Forget one copy of a job, once the worker it was sent to no longer has it
+func (nr *NetworkRender) dropCopy(f *InFlightJob) {
+	copies := nr.Copies[f.Job.Id]
+	for i, c := range copies {
+		if c == f {
+			nr.Copies[f.Job.Id] = append(copies[:i], copies[i+1:]...)
+			break
+		}
+	}
+
+	if len(nr.Copies[f.Job.Id]) == 0 {
+		delete(nr.Copies, f.Job.Id)
+	}
+}
+
+// -
+// Copies of a job sent to workers other than the given one, these can be cancelled
+// once that worker has sent a result. Call before CompleteJob, which forgets them
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) OtherCopies(jobID int32, workerID string) []*InFlightJob {
+	others := []*InFlightJob{}
+	for _, f := range nr.Copies[jobID] {
+		if f.WorkerID != workerID {
+			others = append(others, f)
+		}
+	}
+
+	return others
+}
+
+// -
+// Mark a job as complete, returns false if the job already has a result, or is not
+// one the render is waiting for, i.e. neither queued nor in flight
+// A late result for a requeued job is still accepted if it arrives first
+// The Lock must be held by the caller
+// -
+func (nr *NetworkRender) CompleteJob(jobID int32) bool {
+	if nr.Done[jobID] {
+		return false
+	}
+
+	queued := -1
+	for i, job := range nr.JobQueue {
+		if job.Id == jobID {
+			queued = i
+			break
+		}
+	}
+
+	if _, inFlight := nr.InFlight[jobID]; !inFlight && queued < 0 {
+		return false
+	}
+
+	if queued >= 0 {
+		nr.JobQueue = append(nr.JobQueue[:queued], nr.JobQueue[queued+1:]...)
+	}
+
+	nr.Done[jobID] = true
+	delete(nr.InFlight, jobID)
+	delete(nr.Copies, jobID)
+
+	nr.JobsComplete++
+
+	// Progressive renders only know they are done at the end of a pass
+	if nr.Progressive == nil && nr.IsComplete() {
+		nr.State = RenderComplete
+	}
+
+	return true
 }
 
 // Output image details and other shared parameters for rendering
 type Render struct {
-	Width           int     `yaml:"width"`
-	Height          int     `yaml:"height"` // Do not set this directly
-	AspectRatio     float64 `yaml:"aspectRatio"`
-	SamplesPerPixel int     `yaml:"samplesPerPixel"`
-	MaxDepth        int     `yaml:"maxDepth"`
+	Width           int         `yaml:"width"`
+	Height          int         `yaml:"height"` // Do not set this directly
+	AspectRatio     float64     `yaml:"aspectRatio"`
+	SamplesPerPixel int         `yaml:"samplesPerPixel"`
+	MaxDepth        int         `yaml:"maxDepth"`
+	Seed            int64       `yaml:"seed"`           // Renders with the same seed and settings are identical
+	Sampler         SamplerType `yaml:"sampler"`        // Empty for independent random samples
+	Filter          FilterType  `yaml:"filter"`         // Empty for a box filter
+	FilterRadius    float64     `yaml:"filterRadius"`   // Zero for the filter's default
+	MinSamples      int         `yaml:"minSamples"`     // Adaptive sampling only, 0 for the default
+	MaxSamples      int         `yaml:"maxSamples"`     // Adaptive sampling only, 0 for SamplesPerPixel
+	NoiseThreshold  float64     `yaml:"noiseThreshold"` // Adaptive sampling is off when zero
 }
 
 var (
@@ -69,40 +515,114 @@ func (r Render) ImageDetails() *proto.ImageDetails {
 	}
 }
 
+// -
+// Create a job to render one tile of the image
+// -
+func (r Render) NewJob(id int, tile Tile) *proto.JobRequest {
+	return &proto.JobRequest{
+		Id:              int32(id),
+		Width:           int32(tile.Width),
+		Height:          int32(tile.Height),
+		X:               int32(tile.X),
+		Y:               int32(tile.Y),
+		SamplesPerPixel: int32(r.SamplesPerPixel),
+		MaxDepth:        int32(r.MaxDepth),
+		ImageDetails:    r.ImageDetails(),
+		MinSamples:      int32(r.MinSamples),
+		MaxSamples:      int32(r.MaxSamples),
+		NoiseThreshold:  r.NoiseThreshold,
+		Seed:            r.Seed,
+		Sampler:         string(r.Sampler),
+		Filter:          string(r.Filter),
+		FilterRadius:    r.FilterRadius,
+	}
+}
+
 // -
 // Heart of the raytracing engine, render a job and return the result
 // A job is essentially a subsection of the image to render
+// Cancelling the context aborts the job between rows
 // -
-func RenderJob(job *proto.JobRequest, s Scene, c Camera) *proto.JobResult {
-	log.Printf("Rendering job %4d: slice:%4d/%4d samp:%d", job.Id, job.Height, job.Y, job.SamplesPerPixel)
-
-	samples := int(job.SamplesPerPixel)
-	sampleScale := 1.0 / float64(samples)
+func RenderJob(ctx context.Context, job *proto.JobRequest, s Scene, c Camera) (*proto.JobResult, error) {
+	radiance, counts, err := RenderRadiance(ctx, job, s, c)
+	if err != nil {
+		return nil, err
+	}
 
 	jobImg := image.NewRGBA(image.Rect(0, 0, int(job.Width), int(job.Height)))
 
-	for y := 0; y < int(job.Height); y += 1 {
-		for x := 0; x < int(job.Width); x += 1 {
-			// Note that x and y are relative to the job, NOT the image
-			pixelX := int(job.X) + x
-			pixelY := int(job.Y) + y
+	for i := 0; i < len(radiance)/4; i++ {
+		pixel := resolvePixel(float64(radiance[i*4]), float64(radiance[i*4+1]), float64(radiance[i*4+2]), float64(radiance[i*4+3]))
+
+		// TODO: Remove hard-coded gamma
+		jobImg.Set(i%int(job.Width), i/int(job.Width), pixel.ToRGBA(s.Gamma))
+	}
+
+	res := &proto.JobResult{
+		ImageData: jobImg.Pix,
+		Job:       job,
+	}
 
-			pixel := t.Black()
+	if counts != nil {
+		res.SampleCounts = EncodeSampleCounts(counts)
+	}
 
+	return res, nil
+}
+
+// -
+// Render a job to linear radiance, as RGB sums weighted by the reconstruction filter
+// and the total filter weight for each pixel of the tile. Progressive passes send this
+// as is, so they can be accumulated across passes
+// With adaptive sampling the samples taken per pixel are also returned, otherwise nil
+// -
+func RenderRadiance(ctx context.Context, job *proto.JobRequest, s Scene, c Camera) ([]float32, []int32, error) {
+	log.Printf("Rendering job %4d: tile:%d,%d %dx%d samp:%d", job.Id, job.X, job.Y, job.Width, job.Height, job.SamplesPerPixel)
+
+	smp, err := NewSampler(SamplerType(job.Sampler), job.Seed, int(job.SamplesPerPixel))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter, err := NewFilter(FilterType(job.Filter), job.FilterRadius)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	film := newTileFilm(job, filter)
+
+	if job.NoiseThreshold > 0 {
+		return renderAdaptive(ctx, job, s, c, smp, film)
+	}
+
+	// Pixels in the apron around the tile are sampled too, their samples spill into the tile
+	for y := film.region.Min.Y; y < film.region.Max.Y; y++ {
+		if ctx.Err() != nil {
+			log.Printf("Job %d cancelled", job.Id)
+			return nil, nil, ctx.Err()
+		}
+
+		for x := film.region.Min.X; x < film.region.Max.X; x++ {
 			// Path tracing uses many, many samples!
-			for i := 0; i < samples; i++ {
-				ray := c.MakeRay(pixelX, pixelY)
-				sample := ray.Shade(s, 0, int(job.MaxDepth))
-				pixel.AddSome(sample, sampleScale)
+			for i := 0; i < int(job.SamplesPerPixel); i++ {
+				film.addSample(renderSample(job, s, c, smp, x, y, int(job.SampleOffset)+i))
 			}
-
-			// TODO: Remove hard-coded gamma
-			jobImg.Set(x, y, pixel.ToRGBA(s.Gamma))
 		}
 	}
 
-	return &proto.JobResult{
-		ImageData: jobImg.Pix,
-		Job:       job,
-	}
+	return film.radiance(), nil, nil
+}
+
+// -
+// Trace one sample of a pixel, returning where it landed on the film and its radiance
+// -
+func renderSample(job *proto.JobRequest, s Scene, c Camera, smp Sampler, pixelX, pixelY, index int) (float64, float64, t.RGB) {
+	smp.StartSample(pixelX, pixelY, index)
+
+	offsetX, offsetY := smp.Get2D()
+	filmX := float64(pixelX) + offsetX - 0.5
+	filmY := float64(pixelY) + offsetY - 0.5
+
+	ray := c.MakeRay(filmX, filmY, smp)
+	return filmX, filmY, ray.Shade(s, 0, int(job.MaxDepth), smp)
 }
@@ -0,0 +1,699 @@
+package raytrace
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"nanoray/lib/proto"
+)
+
+func makeJobs(count int) []*proto.JobRequest {
+	jobs := []*proto.JobRequest{}
+	for i := 0; i < count; i++ {
+		jobs = append(jobs, &proto.JobRequest{Id: int32(i)})
+	}
+
+	return jobs
+}
+
+func queueIDs(nr *NetworkRender) []int32 {
+	ids := []int32{}
+	for _, job := range nr.JobQueue {
+		ids = append(ids, job.Id)
+	}
+
+	return ids
+}
+
+func inFlightWorkers(nr *NetworkRender) map[int32]string {
+	workers := map[int32]string{}
+	for id, f := range nr.InFlight {
+		workers[id] = f.WorkerID
+	}
+
+	return workers
+}
+
+// Workers sent each job, in the order they were sent it
+func copyWorkers(nr *NetworkRender) map[int32][]string {
+	workers := map[int32][]string{}
+	for id, copies := range nr.Copies {
+		for _, f := range copies {
+			workers[id] = append(workers[id], f.WorkerID)
+		}
+	}
+
+	return workers
+}
+
+// Pretend a job was sent long ago, so it's past any deadline
+func expire(nr *NetworkRender, jobID int32) {
+	nr.InFlight[jobID].Issued = time.Now().Add(-time.Hour)
+}
+
+func aliveOnly(workers ...string) func(string) bool {
+	return func(workerID string) bool {
+		for _, w := range workers {
+			if w == workerID {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func TestNetworkRenderJobs(t *testing.T) {
+	tests := []struct {
+		name     string
+		run      func(t *testing.T, nr *NetworkRender)
+		queue    []int32
+		inFlight map[int32]string
+		copies   map[int32][]string
+		complete int
+	}{
+		{
+			name: "assign in order",
+			run: func(t *testing.T, nr *NetworkRender) {
+				for i, worker := range []string{"a", "a", "b"} {
+					if job := nr.AssignJob(worker); job.Id != int32(i) {
+						t.Errorf("assigned job %d, want %d", job.Id, i)
+					}
+				}
+
+				if n := nr.WorkerJobs("a"); n != 2 {
+					t.Errorf("worker a has %d jobs, want 2", n)
+				}
+			},
+			queue:    []int32{3},
+			inFlight: map[int32]string{0: "a", 1: "a", 2: "b"},
+			copies:   map[int32][]string{0: {"a"}, 1: {"a"}, 2: {"b"}},
+		},
+		{
+			name: "empty queue",
+			run: func(t *testing.T, nr *NetworkRender) {
+				for i := 0; i < 4; i++ {
+					nr.AssignJob("a")
+				}
+
+				if job := nr.AssignJob("a"); job != nil {
+					t.Errorf("assigned job %d from an empty queue", job.Id)
+				}
+			},
+			queue:    []int32{},
+			inFlight: map[int32]string{0: "a", 1: "a", 2: "a", 3: "a"},
+			copies:   map[int32][]string{0: {"a"}, 1: {"a"}, 2: {"a"}, 3: {"a"}},
+		},
+		{
+			name: "requeue goes to the front",
+			run: func(t *testing.T, nr *NetworkRender) {
+				nr.AssignJob("a")
+				nr.AssignJob("a")
+				nr.AssignJob("b")
+				nr.Requeue(1)
+				nr.Requeue(7)
+			},
+			queue:    []int32{1, 3},
+			inFlight: map[int32]string{0: "a", 2: "b"},
+			copies:   map[int32][]string{0: {"a"}, 2: {"b"}},
+		},
+		{
+			name: "requeue worker keeps render order",
+			run: func(t *testing.T, nr *NetworkRender) {
+				for _, worker := range []string{"a", "b", "a", "b"} {
+					nr.AssignJob(worker)
+				}
+
+				nr.Requeue(3)
+				if n := nr.RequeueWorker("a"); n != 2 {
+					t.Errorf("requeued %d jobs, want 2", n)
+				}
+
+				if n := nr.RequeueWorker("c"); n != 0 {
+					t.Errorf("requeued %d jobs for an unknown worker", n)
+				}
+			},
+			queue:    []int32{0, 2, 3},
+			inFlight: map[int32]string{1: "b"},
+			copies:   map[int32][]string{1: {"b"}},
+		},
+		{
+			name: "requeue expired",
+			run: func(t *testing.T, nr *NetworkRender) {
+				nr.AssignJob("a")
+				nr.AssignJob("b")
+				nr.AssignJob("b")
+				expire(nr, 0)
+				expire(nr, 1)
+
+				// Worker b is still heartbeating, so its slow job is left alone
+				if n := nr.RequeueExpired(time.Minute, aliveOnly("b")); n != 1 {
+					t.Errorf("requeued %d jobs, want 1", n)
+				}
+			},
+			queue:    []int32{0, 3},
+			inFlight: map[int32]string{1: "b", 2: "b"},
+			copies:   map[int32][]string{0: {"a"}, 1: {"b"}, 2: {"b"}},
+		},
+		{
+			name: "late duplicate after requeue",
+			run: func(t *testing.T, nr *NetworkRender) {
+				nr.AssignJob("a")
+				expire(nr, 0)
+				nr.RequeueExpired(time.Minute, aliveOnly())
+				nr.AssignJob("b")
+
+				// Worker a finishes first, so b's copy is the one to cancel
+				others := nr.OtherCopies(0, "a")
+				if len(others) != 1 || others[0].WorkerID != "b" || others[0].Job.Id != 0 {
+					t.Errorf("other copies are %v, want job 0 on b", others)
+				}
+
+				if !nr.CompleteJob(0) {
+					t.Error("first result was rejected")
+				}
+
+				if nr.CompleteJob(0) {
+					t.Error("duplicate result was accepted")
+				}
+			},
+			queue:    []int32{1, 2, 3},
+			inFlight: map[int32]string{},
+			copies:   map[int32][]string{},
+			complete: 1,
+		},
+		{
+			name: "every copy is tracked",
+			run: func(t *testing.T, nr *NetworkRender) {
+				for _, worker := range []string{"a", "b", "c"} {
+					if job := nr.AssignJob(worker); job.Id != 0 {
+						t.Fatalf("assigned job %d, want 0", job.Id)
+					}
+
+					expire(nr, 0)
+					nr.RequeueExpired(time.Minute, aliveOnly())
+				}
+
+				// Sent back to b, which has it twice now
+				nr.AssignJob("b")
+
+				others := []string{}
+				for _, f := range nr.OtherCopies(0, "b") {
+					others = append(others, f.WorkerID)
+				}
+
+				if !reflect.DeepEqual(others, []string{"a", "c"}) {
+					t.Errorf("other copies are on %v, want a & c", others)
+				}
+			},
+			queue:    []int32{1, 2, 3},
+			inFlight: map[int32]string{0: "b"},
+			copies:   map[int32][]string{0: {"a", "b", "c", "b"}},
+		},
+		{
+			name: "unknown jobs are rejected",
+			run: func(t *testing.T, nr *NetworkRender) {
+				nr.AssignJob("a")
+
+				if nr.CompleteJob(9) || nr.CompleteJob(-1) {
+					t.Error("result for a job the render doesn't have was accepted")
+				}
+			},
+			queue:    []int32{1, 2, 3},
+			inFlight: map[int32]string{0: "a"},
+			copies:   map[int32][]string{0: {"a"}},
+		},
+		{
+			name: "late result before reissue",
+			run: func(t *testing.T, nr *NetworkRender) {
+				nr.AssignJob("a")
+				nr.AssignJob("a")
+				nr.RequeueWorker("a")
+
+				// Result arrives while the job is still queued, so it is never reissued
+				if !nr.CompleteJob(1) {
+					t.Error("queued job result was rejected")
+				}
+
+				if job := nr.AssignJob("b"); job.Id != 0 {
+					t.Errorf("assigned job %d, want 0", job.Id)
+				}
+			},
+			queue:    []int32{2, 3},
+			inFlight: map[int32]string{0: "b"},
+			copies:   map[int32][]string{0: {"b"}},
+			complete: 1,
+		},
+		{
+			name: "complete all",
+			run: func(t *testing.T, nr *NetworkRender) {
+				for i := int32(0); i < 4; i++ {
+					if nr.IsComplete() {
+						t.Errorf("render complete after %d jobs", i)
+					}
+
+					if job := nr.AssignJob("a"); job.RenderID != "test" {
+						t.Errorf("job %d has render ID %q", job.Id, job.RenderID)
+					}
+
+					nr.CompleteJob(i)
+				}
+
+				if !nr.IsComplete() {
+					t.Error("render not complete after all jobs")
+				}
+			},
+			queue:    []int32{},
+			inFlight: map[int32]string{},
+			copies:   map[int32][]string{},
+			complete: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nr := NewNetworkRender("test", "", nil, NewRender(8, 1), makeJobs(4), "test")
+			test.run(t, nr)
+
+			if got := queueIDs(nr); !reflect.DeepEqual(got, test.queue) {
+				t.Errorf("queue is %v, want %v", got, test.queue)
+			}
+
+			if got := inFlightWorkers(nr); !reflect.DeepEqual(got, test.inFlight) {
+				t.Errorf("in flight is %v, want %v", got, test.inFlight)
+			}
+
+			if got := copyWorkers(nr); !reflect.DeepEqual(got, test.copies) {
+				t.Errorf("copies are on %v, want %v", got, test.copies)
+			}
+
+			if nr.JobsComplete != test.complete {
+				t.Errorf("%d jobs complete, want %d", nr.JobsComplete, test.complete)
+			}
+		})
+	}
+}
+
+func TestNetworkRenderCancel(t *testing.T) {
+	nr := NewNetworkRender("test", "", nil, NewRender(8, 1), makeJobs(5), "test")
+
+	nr.AssignJob("a")
+	nr.AssignJob("b")
+	nr.AssignJob("a")
+	nr.CompleteJob(1)
+
+	if !nr.IsActive() {
+		t.Fatalf("new render is %s, want active", nr.State)
+	}
+
+	aborted := nr.Cancel()
+
+	abortedWorkers := map[int32]string{}
+	for _, f := range aborted {
+		abortedWorkers[f.Job.Id] = f.WorkerID
+	}
+
+	if want := map[int32]string{0: "a", 2: "a"}; !reflect.DeepEqual(abortedWorkers, want) {
+		t.Errorf("cancel returned in flight jobs %v, want %v", abortedWorkers, want)
+	}
+
+	if nr.State != RenderCancelled || nr.IsActive() {
+		t.Errorf("cancelled render is %s, active %v", nr.State, nr.IsActive())
+	}
+
+	if len(nr.JobQueue) != 0 || len(nr.InFlight) != 0 {
+		t.Errorf("cancelled render still has %d queued and %d in flight jobs", len(nr.JobQueue), len(nr.InFlight))
+	}
+
+	if job := nr.AssignJob("a"); job != nil {
+		t.Errorf("cancelled render assigned job %d", job.Id)
+	}
+
+	if nr.CompleteJob(0) || nr.CompleteJob(3) {
+		t.Error("cancelled render accepted a late result")
+	}
+
+	if nr.IsComplete() {
+		t.Error("cancelled render is complete")
+	}
+}
+
+func TestNetworkRenderCompleteState(t *testing.T) {
+	nr := NewNetworkRender("test", "", nil, NewRender(8, 1), makeJobs(2), "test")
+
+	nr.AssignJob("a")
+	nr.AssignJob("a")
+	nr.CompleteJob(0)
+
+	if nr.State != RenderRunning {
+		t.Errorf("part complete render is %s, want %s", nr.State, RenderRunning)
+	}
+
+	nr.CompleteJob(1)
+
+	if nr.State != RenderComplete || nr.IsActive() {
+		t.Errorf("finished render is %s, active %v", nr.State, nr.IsActive())
+	}
+}
+
+// Render of an 8x4 image split into two 4x4 tiles
+func newTestRender(t *testing.T, samples int) *NetworkRender {
+	t.Helper()
+
+	r := Render{Width: 8, Height: 4, AspectRatio: 2, SamplesPerPixel: samples, MaxDepth: 2}
+	tiles, err := MakeTiles(r.Width, r.Height, 4, 4, TileScanline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := []*proto.JobRequest{}
+	for i, tile := range tiles {
+		jobs = append(jobs, r.NewJob(i, tile))
+	}
+
+	return NewNetworkRender("test", "", nil, r, jobs, "test")
+}
+
+func flatRadiance(job *proto.JobRequest, value float32) []float32 {
+	samples := float32(job.SamplesPerPixel)
+
+	// Weighted sums as a box filter gives them, every sample has a weight of one
+	radiance := make([]float32, job.Width*job.Height*4)
+	for i := range radiance {
+		radiance[i] = value * samples
+		if i%4 == 3 {
+			radiance[i] = samples
+		}
+	}
+
+	return radiance
+}
+
+// -
+// Hand out every queued job, so the next pass isn't started part way through
+// -
+func assignAll(nr *NetworkRender) []*proto.JobRequest {
+	jobs := []*proto.JobRequest{}
+	for job := nr.AssignJob("a"); job != nil; job = nr.AssignJob("a") {
+		jobs = append(jobs, job)
+	}
+
+	return jobs
+}
+
+// -
+// Complete one whole pass with the same radiance everywhere, returns the pass jobs
+// -
+func runPass(t *testing.T, nr *NetworkRender, value float32) []*proto.JobRequest {
+	t.Helper()
+
+	jobs := assignAll(nr)
+	for _, job := range jobs {
+		if !nr.CompletePass(job, flatRadiance(job, value)) {
+			t.Fatalf("job %d of pass %d was rejected", job.Id, job.Pass)
+		}
+	}
+
+	return jobs
+}
+
+func TestProgressiveTargetSamples(t *testing.T) {
+	nr := newTestRender(t, 10)
+	nr.MakeProgressive(4, 0, 1)
+
+	// 10 samples in passes of 4 needs a short last pass
+	if nr.JobsTotal != 6 {
+		t.Errorf("jobs total is %d, want 6", nr.JobsTotal)
+	}
+
+	seen := map[int32]bool{}
+	for pass, want := range []int32{4, 4, 2} {
+		if nr.State != RenderRunning {
+			t.Fatalf("render is %s before pass %d", nr.State, pass)
+		}
+
+		jobs := runPass(t, nr, float32(pass+1)*0.1)
+		if len(jobs) != 2 {
+			t.Fatalf("pass %d has %d jobs, want 2", pass, len(jobs))
+		}
+
+		for _, job := range jobs {
+			if job.SamplesPerPixel != want || job.Pass != int32(pass) || job.ResultEncoding != string(TileRadiance) {
+				t.Errorf("pass %d job %d has %d samples, pass %d, encoding %q", pass, job.Id, job.SamplesPerPixel, job.Pass, job.ResultEncoding)
+			}
+
+			if seen[job.Id] {
+				t.Errorf("job ID %d used twice", job.Id)
+			}
+			seen[job.Id] = true
+		}
+
+		if nr.Progressive.Passes != pass+1 || nr.Image == nil {
+			t.Errorf("after pass %d, %d passes done, image %v", pass, nr.Progressive.Passes, nr.Image != nil)
+		}
+	}
+
+	p := nr.Progressive
+	if nr.State != RenderComplete || p.Samples != 10 || nr.JobsComplete != nr.JobsTotal || len(nr.JobQueue) != 0 {
+		t.Errorf("finished render is %s with %d samples, %d of %d jobs, %d queued", nr.State, p.Samples, nr.JobsComplete, nr.JobsTotal, len(nr.JobQueue))
+	}
+
+	// Each pass is weighted by its samples, (4*0.1 + 4*0.2 + 2*0.3) / 10
+	for i, n := range p.Radiance.Samples {
+		if n != 10 || math.Abs(float64(p.Radiance.Sum[i*3])/10-0.18) > 1e-6 {
+			t.Fatalf("pixel %d has %d samples, average %v", i, n, p.Radiance.Sum[i*3]/10)
+		}
+	}
+}
+
+func TestProgressiveTimeLimit(t *testing.T) {
+	nr := newTestRender(t, 4)
+	nr.MakeProgressive(4, time.Hour, 1)
+
+	if nr.JobsTotal != 2 {
+		t.Errorf("jobs total is %d, want one pass of 2", nr.JobsTotal)
+	}
+
+	// With time left, passes carry on past the render samples per pixel
+	for pass := 0; pass < 3; pass++ {
+		runPass(t, nr, 0.5)
+	}
+
+	p := nr.Progressive
+	if nr.State != RenderRunning || p.Samples != 12 || nr.JobsTotal != 8 || len(nr.JobQueue) != 2 {
+		t.Fatalf("render is %s with %d samples, %d jobs total, %d queued", nr.State, p.Samples, nr.JobsTotal, len(nr.JobQueue))
+	}
+
+	// Time runs out part way through a pass, that pass is still finished
+	p.Deadline = time.Now().Add(-time.Second)
+	runPass(t, nr, 0.5)
+
+	if nr.State != RenderComplete || p.Samples != 16 || len(nr.JobQueue) != 0 || nr.JobsComplete != nr.JobsTotal {
+		t.Errorf("render is %s with %d samples, %d of %d jobs, %d queued", nr.State, p.Samples, nr.JobsComplete, nr.JobsTotal, len(nr.JobQueue))
+	}
+}
+
+func TestProgressiveFinishPartWay(t *testing.T) {
+	nr := newTestRender(t, 100)
+	nr.MakeProgressive(4, 0, 1)
+
+	runPass(t, nr, 0.25)
+
+	// Second pass, only the left tile gets done before finishing
+	jobs := assignAll(nr)
+	nr.CompletePass(jobs[0], flatRadiance(jobs[0], 0.75))
+
+	inFlight := nr.Finish()
+	if len(inFlight) != 1 || inFlight[0].Job.Id != jobs[1].Id {
+		t.Errorf("finish returned %d jobs in flight, want job %d", len(inFlight), jobs[1].Id)
+	}
+
+	if nr.State != RenderComplete || nr.Image == nil || len(nr.JobQueue) != 0 {
+		t.Fatalf("finished render is %s, image %v, %d queued", nr.State, nr.Image != nil, len(nr.JobQueue))
+	}
+
+	// Left tile averages both passes, the right tile only has the first
+	rb := nr.Progressive.Radiance
+	for _, tc := range []struct {
+		x       int
+		samples int32
+		average float64
+	}{
+		{0, 8, 0.5},
+		{3, 8, 0.5},
+		{4, 4, 0.25},
+		{7, 4, 0.25},
+	} {
+		i := 2*rb.Width + tc.x
+		if rb.Samples[i] != tc.samples || math.Abs(float64(rb.Sum[i*3])/float64(tc.samples)-tc.average) > 1e-6 {
+			t.Errorf("pixel %d has %d samples averaging %v, want %d averaging %v", tc.x, rb.Samples[i], rb.Sum[i*3], tc.samples, tc.average)
+		}
+	}
+
+	// Image is gamma corrected, with gamma 1 the average maps straight to 8 bits
+	if left, right := nr.Image.RGBAAt(1, 1).R, nr.Image.RGBAAt(6, 1).R; left <= right {
+		t.Errorf("image left %d should be brighter than right %d", left, right)
+	}
+
+	if nr.IsActive() {
+		t.Error("finished render is still active")
+	}
+
+	// Results for the job still running elsewhere are dropped, rather than starting another pass
+	if nr.CompletePass(jobs[1], flatRadiance(jobs[1], 0.75)) || len(nr.JobQueue) != 0 || rb.Samples[2*rb.Width+4] != 4 {
+		t.Errorf("late result after finishing was used, %d jobs queued", len(nr.JobQueue))
+	}
+}
+
+func TestProgressiveLateDuplicate(t *testing.T) {
+	nr := newTestRender(t, 12)
+	nr.MakeProgressive(4, 0, 1)
+
+	first := runPass(t, nr, 0.5)
+	second := assignAll(nr)
+
+	// A job from the first pass was requeued, and the other copy reports late
+	if nr.CompletePass(first[1], flatRadiance(first[1], 9)) {
+		t.Error("late duplicate from the previous pass was accepted")
+	}
+
+	for i, n := range nr.Progressive.Radiance.Samples {
+		if n != 4 || nr.Progressive.Radiance.Sum[i*3] != 2 {
+			t.Fatalf("pixel %d changed by a duplicate, %d samples sum %v", i, n, nr.Progressive.Radiance.Sum[i*3])
+		}
+	}
+
+	// A duplicate within a pass must not end the pass early either
+	nr.CompletePass(second[0], flatRadiance(second[0], 0.5))
+	if nr.CompletePass(second[0], flatRadiance(second[0], 0.5)) {
+		t.Error("duplicate within a pass was accepted")
+	}
+
+	if nr.Progressive.Passes != 1 {
+		t.Errorf("%d passes done, want 1", nr.Progressive.Passes)
+	}
+
+	nr.CompletePass(second[1], flatRadiance(second[1], 0.5))
+	if nr.Progressive.Passes != 2 || nr.JobsComplete != 4 {
+		t.Errorf("%d passes and %d jobs done, want 2 and 4", nr.Progressive.Passes, nr.JobsComplete)
+	}
+}
+
+const testScene = `
+name: Test
+background: [0.1, 0.1, 0.15]
+
+camera:
+  position: [0, 3, 10]
+  lookAt: [0, 1, 0]
+  fov: 40
+
+objects:
+  - type: sphere
+    position: [-1, 1, 0]
+    radius: 1
+    material:
+      diffuse:
+        albedo: [0.8, 0.3, 0.2]
+
+  - type: sphere
+    position: [1.5, 0.75, 0.5]
+    radius: 0.75
+    material:
+      metal:
+        albedo: [0.9, 0.9, 0.9]
+        fuzz: 0.1
+
+  - type: sphere
+    position: [0, 5, 2]
+    radius: 1
+    material:
+      light:
+        emission: [6, 6, 6]
+
+  - type: plane
+    position: [0, 0, 0]
+    normal: [0, 1, 0]
+    material:
+      diffuse:
+        albedo: [0.7, 0.7, 0.7]
+`
+
+// Render the test scene as radiance for the whole image, split into square tiles
+func renderTiled(t *testing.T, render Render, tileSize int) []float32 {
+	t.Helper()
+
+	scene, camera, err := ParseScene(testScene, render.Width, render.Height, DirResolver("."))
+	if err != nil {
+		t.Fatalf("parsing scene: %v", err)
+	}
+
+	tiles, err := MakeTiles(render.Width, render.Height, tileSize, tileSize, TileHilbert)
+	if err != nil {
+		t.Fatalf("making tiles: %v", err)
+	}
+
+	out := make([]float32, render.Width*render.Height*4)
+	for i, tile := range tiles {
+		radiance, _, err := RenderRadiance(context.Background(), render.NewJob(i, tile), *scene, *camera)
+		if err != nil {
+			t.Fatalf("rendering tile %d: %v", i, err)
+		}
+
+		for y := 0; y < tile.Height; y++ {
+			row := radiance[y*tile.Width*4 : (y+1)*tile.Width*4]
+			copy(out[((tile.Y+y)*render.Width+tile.X)*4:], row)
+		}
+	}
+
+	return out
+}
+
+func TestRenderSameForAnyTileSize(t *testing.T) {
+	cases := []struct {
+		name    string
+		sampler SamplerType
+		filter  FilterType
+		noise   float64
+		min     int
+	}{
+		{"independent box", SamplerIndependent, FilterBox, 0, 0},
+		{"stratified tent", SamplerStratified, FilterTent, 0, 0},
+		{"halton mitchell", SamplerHalton, FilterMitchell, 0, 0},
+		{"sobol gaussian", SamplerSobol, FilterGaussian, 0, 0},
+		{"sobol blackman-harris", SamplerSobol, FilterBlackmanHarris, 0, 0},
+		{"independent box adaptive", SamplerIndependent, FilterBox, 0.02, 2},
+		{"halton mitchell adaptive", SamplerHalton, FilterMitchell, 0.02, 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			render := NewRender(48, 4.0/3)
+			render.SamplesPerPixel = 4
+			render.Seed = 42
+			render.Sampler = tc.sampler
+			render.Filter = tc.filter
+			render.NoiseThreshold = tc.noise
+			render.MinSamples = tc.min
+
+			small := renderTiled(t, render, 8)
+			large := renderTiled(t, render, 64)
+
+			lit := false
+			for i := range small {
+				if math.Float32bits(small[i]) != math.Float32bits(large[i]) {
+					t.Fatalf("pixel %d channel %d is %v with 8px tiles, %v with 64px tiles", i/4, i%4, small[i], large[i])
+				}
+
+				lit = lit || (i%4 != 3 && small[i] > 0)
+			}
+
+			if !lit {
+				t.Fatalf("render is black")
+			}
+		})
+	}
+}
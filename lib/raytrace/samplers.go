@@ -0,0 +1,277 @@
+package raytrace
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Sampler supplies the numbers used to pick each sample of a pixel: the position in
+// the pixel, on the lens, the BSDF directions etc. Every value is a dimension, which
+// are used in the same order for each sample. Better samplers spread the values for
+// each dimension evenly over the samples of a pixel, so images converge faster
+type Sampler interface {
+	// Start a sample of a pixel, dimensions restart from the first one
+	StartSample(pixelX, pixelY, index int)
+
+	// Next dimension, a number in [0, 1)
+	Get1D() float64
+
+	// Next two dimensions together, which are better distributed than two Get1D calls
+	Get2D() (float64, float64)
+}
+
+// SamplerType names a sampler, for scene files and render requests
+type SamplerType string
+
+const (
+	SamplerIndependent SamplerType = "independent" // Uniform random numbers, the default
+	SamplerStratified  SamplerType = "stratified"  // Jittered strata, shuffled for each dimension
+	SamplerHalton      SamplerType = "halton"      // Halton sequence, digits Owen scrambled for each pixel
+	SamplerSobol       SamplerType = "sobol"       // Owen scrambled Sobol sequence
+)
+
+// -
+// Create a sampler, seeded so renders are repeatable. The stratified sampler needs
+// to know the samples per pixel it's spreading values over, others ignore it
+// -
+func NewSampler(kind SamplerType, seed int64, samplesPerPixel int) (Sampler, error) {
+	base := sampleBase{rng: NewSampleRand(), seed: seed}
+
+	switch kind {
+	case SamplerIndependent, "":
+		return &independentSampler{base}, nil
+	case SamplerStratified:
+		return &stratifiedSampler{sampleBase: base, samples: max(samplesPerPixel, 1)}, nil
+	case SamplerHalton:
+		return &haltonSampler{base}, nil
+	case SamplerSobol:
+		return &sobolSampler{base}, nil
+	}
+
+	return nil, ErrUnknownSampler
+}
+
+// Shared by all samplers, tracks the sample being taken and the dimension reached
+// The random source is seeded per sample, and is used once a sampler runs out of dimensions
+type sampleBase struct {
+	rng       *SampleRand
+	seed      int64
+	pixelHash uint64
+	index     int
+	dim       int
+}
+
+func (b *sampleBase) StartSample(pixelX, pixelY, index int) {
+	b.rng.Seed(b.seed, pixelX, pixelY, index)
+	b.pixelHash = mix64(uint64(b.seed) ^ mix64(uint64(uint32(pixelX))<<32|uint64(uint32(pixelY))))
+	b.index = index
+	b.dim = 0
+}
+
+// Random value fixed for this pixel & dimension, the same for every sample
+func (b *sampleBase) dimHash(dim int) uint64 {
+	return mix64(b.pixelHash ^ uint64(dim)*0x9e3779b97f4a7c15)
+}
+
+// ============================================================
+// Independent uniform random numbers
+// ============================================================
+
+type independentSampler struct {
+	sampleBase
+}
+
+func (s *independentSampler) Get1D() float64 {
+	return s.rng.Float64()
+}
+
+func (s *independentSampler) Get2D() (float64, float64) {
+	return s.rng.Float64(), s.rng.Float64()
+}
+
+// ============================================================
+// Stratified, each dimension is split into one stratum per sample with a random
+// point in each. Strata are shuffled per dimension so dimensions don't correlate
+// ============================================================
+
+type stratifiedSampler struct {
+	sampleBase
+	samples int
+}
+
+// Stratum for the current sample in a dimension. When more samples are taken than the
+// sampler was made for, each further round through the strata gets a new shuffle
+func (s *stratifiedSampler) stratum(dim int) int {
+	round := uint64(s.index / s.samples)
+	return permute(s.index%s.samples, s.samples, s.dimHash(dim)^mix64(round))
+}
+
+func (s *stratifiedSampler) Get1D() float64 {
+	stratum := s.stratum(s.dim)
+	s.dim++
+
+	return (float64(stratum) + s.rng.Float64()) / float64(s.samples)
+}
+
+func (s *stratifiedSampler) Get2D() (float64, float64) {
+	stratum := s.stratum(s.dim)
+	s.dim += 2
+
+	// Square grid when possible, other counts fall back to one axis of strata
+	nx := int(math.Sqrt(float64(s.samples)))
+	if nx*nx != s.samples {
+		return (float64(stratum) + s.rng.Float64()) / float64(s.samples), s.rng.Float64()
+	}
+
+	x, y := stratum%nx, stratum/nx
+	return (float64(x) + s.rng.Float64()) / float64(nx), (float64(y) + s.rng.Float64()) / float64(nx)
+}
+
+// -
+// Random permutation of [0, n) looked up one element at a time, using cycle walking
+// over a hashed bijection on the next power of two (Kensler, "Correlated Multi-Jittered Sampling")
+// -
+func permute(i, n int, seed uint64) int {
+	if n <= 1 {
+		return 0
+	}
+
+	mask := uint32(1)<<bits.Len32(uint32(n-1)) - 1
+	p := uint32(seed)
+	x := uint32(i)
+
+	for {
+		x ^= p
+		x *= 0xe170893d
+		x ^= p >> 16
+		x ^= (x & mask) >> 4
+		x ^= p >> 8
+		x *= 0x0929eb3f
+		x ^= p >> 23
+		x ^= (x & mask) >> 1
+		x *= 1 | p>>27
+		x *= 0x6935fa69
+		x ^= (x & mask) >> 11
+		x *= 0x74dcb303
+		x ^= (x & mask) >> 2
+		x *= 0x9e501cc3
+		x ^= (x & mask) >> 2
+		x *= 0xc860a3df
+		x &= mask
+		x ^= x >> 5
+
+		if int(x) < n {
+			return int((x + p) % uint32(n))
+		}
+	}
+}
+
+// ============================================================
+// Halton sequence, one prime base per dimension. Digits are randomly permuted for
+// each pixel & dimension (Owen scrambling), which breaks up the patterns higher bases
+// make at low sample counts, and stops neighbouring pixels correlating
+// ============================================================
+
+var haltonPrimes = []int{
+	2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53,
+	59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131,
+}
+
+type haltonSampler struct {
+	sampleBase
+}
+
+func (s *haltonSampler) Get1D() float64 {
+	dim := s.dim
+	s.dim++
+
+	// Dimensions past the primes table fall back to random numbers
+	if dim >= len(haltonPrimes) {
+		return s.rng.Float64()
+	}
+
+	return scrambledRadicalInverse(haltonPrimes[dim], s.index, s.dimHash(dim))
+}
+
+func (s *haltonSampler) Get2D() (float64, float64) {
+	return s.Get1D(), s.Get1D()
+}
+
+// -
+// Mirror the digits of n in the given base around the decimal point, permuting each
+// digit based on the digits before it. Runs until float64 precision is used up, so
+// the trailing zero digits get scrambled too
+// -
+func scrambledRadicalInverse(base, n int, seed uint64) float64 {
+	invBase := 1.0 / float64(base)
+	invBaseM := 1.0
+	reversed := uint64(0)
+
+	for 1-float64(base-1)*invBaseM < 1 {
+		digit := permute(n%base, base, mix64(seed^reversed))
+		reversed = reversed*uint64(base) + uint64(digit)
+		invBaseM *= invBase
+		n /= base
+	}
+
+	return min(float64(reversed)*invBaseM, 1-0x1p-53)
+}
+
+// ============================================================
+// Owen scrambled Sobol, the first two Sobol dimensions are used for every pair of
+// dimensions with a different scramble & shuffle for each, following Burley
+// "Practical Hash-based Owen Scrambling" (JCGT 2020)
+// ============================================================
+
+type sobolSampler struct {
+	sampleBase
+}
+
+func (s *sobolSampler) Get1D() float64 {
+	seed := uint32(s.dimHash(s.dim))
+	s.dim++
+
+	i := nestedUniformScramble(uint32(s.index), seed)
+	return float64(nestedUniformScramble(bits.Reverse32(i), seed^0x5bd1e995)) / (1 << 32)
+}
+
+func (s *sobolSampler) Get2D() (float64, float64) {
+	hash := s.dimHash(s.dim)
+	s.dim += 2
+
+	i := nestedUniformScramble(uint32(s.index), uint32(hash))
+	x := nestedUniformScramble(bits.Reverse32(i), uint32(hash>>32))
+	y := nestedUniformScramble(sobolDim1(i), uint32(hash>>16)^0x68bc21eb)
+
+	return float64(x) / (1 << 32), float64(y) / (1 << 32)
+}
+
+// Second Sobol dimension, from the generator matrix for the polynomial x + 1
+func sobolDim1(i uint32) uint32 {
+	v := uint32(1) << 31
+	result := uint32(0)
+
+	for ; i != 0; i >>= 1 {
+		if i&1 != 0 {
+			result ^= v
+		}
+		v ^= v >> 1
+	}
+
+	return result
+}
+
+// Owen scramble a base 2 fraction, by hashing its bits from the most significant down
+func nestedUniformScramble(x, seed uint32) uint32 {
+	return bits.Reverse32(laineKarras(bits.Reverse32(x), seed))
+}
+
+// Laine-Karras style permutation, with Burley's improved constants
+func laineKarras(x, seed uint32) uint32 {
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return x
+}
@@ -0,0 +1,148 @@
+package raytrace
+
+import (
+	"fmt"
+	"testing"
+)
+
+var testSamplers = []SamplerType{SamplerIndependent, SamplerStratified, SamplerHalton, SamplerSobol}
+
+func TestSamplerRange(t *testing.T) {
+	for _, kind := range testSamplers {
+		t.Run(string(kind), func(t *testing.T) {
+			smp, err := NewSampler(kind, 42, 16)
+			if err != nil {
+				t.Fatalf("creating sampler: %v", err)
+			}
+
+			// More samples than the sampler was made for, and more dimensions than Halton has primes
+			for pixel := 0; pixel < 8; pixel++ {
+				for i := 0; i < 40; i++ {
+					smp.StartSample(pixel, 3*pixel, i)
+
+					for d := 0; d < 24; d++ {
+						x, y := smp.Get2D()
+						z := smp.Get1D()
+
+						for _, v := range []float64{x, y, z} {
+							if v < 0 || v >= 1 {
+								t.Fatalf("pixel %d sample %d dimension %d gave %v", pixel, i, d, v)
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSamplerRepeatable(t *testing.T) {
+	for _, kind := range testSamplers {
+		t.Run(string(kind), func(t *testing.T) {
+			a, _ := NewSampler(kind, 7, 4)
+			b, _ := NewSampler(kind, 7, 4)
+
+			// Out of order on purpose, a sample mustn't depend on the ones before it
+			for _, i := range []int{3, 0, 2, 1} {
+				a.StartSample(5, 9, i)
+				b.StartSample(5, 9, i)
+				b.StartSample(5, 9, i)
+
+				for d := 0; d < 8; d++ {
+					if va, vb := a.Get1D(), b.Get1D(); va != vb {
+						t.Fatalf("sample %d dimension %d gave %v then %v", i, d, va, vb)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPermute(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 16, 17, 100, 1000} {
+		for _, seed := range []uint64{0, 1, 0xdeadbeef, mix64(99)} {
+			t.Run(fmt.Sprintf("n=%d seed=%x", n, seed), func(t *testing.T) {
+				seen := make([]bool, n)
+
+				for i := 0; i < n; i++ {
+					p := permute(i, n, seed)
+					if p < 0 || p >= n {
+						t.Fatalf("permute(%d) = %d, out of range", i, p)
+					}
+
+					if seen[p] {
+						t.Fatalf("permute(%d) = %d, which was already used", i, p)
+					}
+
+					seen[p] = true
+				}
+			})
+		}
+	}
+}
+
+func TestSamplerStratification(t *testing.T) {
+	cases := []struct {
+		name    string
+		kind    SamplerType
+		samples int
+		skip    int      // Dimensions taken with Get1D before the ones checked
+		twoD    bool     // Check a Get2D pair rather than a single Get1D
+		grids   [][2]int // Each cell of every grid should hold exactly one sample
+	}{
+		{"stratified 1D", SamplerStratified, 16, 0, false, [][2]int{{16, 1}}},
+		{"stratified 1D later dimension", SamplerStratified, 10, 5, false, [][2]int{{10, 1}}},
+		{"stratified 2D", SamplerStratified, 16, 0, true, [][2]int{{4, 4}}},
+		{"stratified 2D later dimension", SamplerStratified, 9, 3, true, [][2]int{{3, 3}}},
+		{"halton base 2", SamplerHalton, 16, 0, false, [][2]int{{16, 1}}},
+		{"halton base 3", SamplerHalton, 27, 1, false, [][2]int{{27, 1}}},
+		{"halton bases 2 & 3", SamplerHalton, 36, 0, true, [][2]int{{4, 9}}},
+		{"sobol 1D", SamplerSobol, 32, 0, false, [][2]int{{32, 1}}},
+		{"sobol 2D", SamplerSobol, 16, 0, true, [][2]int{{16, 1}, {8, 2}, {4, 4}, {2, 8}, {1, 16}}},
+		{"sobol 2D later dimension", SamplerSobol, 64, 6, true, [][2]int{{64, 1}, {8, 8}, {2, 32}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			smp, err := NewSampler(tc.kind, 42, tc.samples)
+			if err != nil {
+				t.Fatalf("creating sampler: %v", err)
+			}
+
+			for _, pixel := range [][2]int{{0, 0}, {17, 4}} {
+				xs, ys := make([]float64, tc.samples), make([]float64, tc.samples)
+
+				for i := 0; i < tc.samples; i++ {
+					smp.StartSample(pixel[0], pixel[1], i)
+					for d := 0; d < tc.skip; d++ {
+						smp.Get1D()
+					}
+
+					if tc.twoD {
+						xs[i], ys[i] = smp.Get2D()
+					} else {
+						xs[i] = smp.Get1D()
+					}
+				}
+
+				for _, grid := range tc.grids {
+					cells := map[int]int{}
+					for i := range xs {
+						cells[int(xs[i]*float64(grid[0]))+int(ys[i]*float64(grid[1]))*grid[0]]++
+					}
+
+					if len(cells) != tc.samples {
+						t.Errorf("pixel %v: %d of %d cells in a %dx%d grid have samples", pixel, len(cells), tc.samples, grid[0], grid[1])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewSamplerUnknown(t *testing.T) {
+	_, err := NewSampler("blue noise", 0, 16)
+	if err != ErrUnknownSampler {
+		t.Fatalf("got error %v, want %v", err, ErrUnknownSampler)
+	}
+}
@@ -1,6 +1,7 @@
 package raytrace
 
 import (
+	"fmt"
 	"log"
 	t "nanoray/lib/tuples"
 
@@ -8,25 +9,153 @@ import (
 )
 
 type Scene struct {
-	Name       string
-	Background t.RGB
-	Gamma      float64
-	Objects    []Hitable
+	Name         string
+	Background   Background
+	Gamma        float64
+	Sampler      SamplerType
+	Filter       FilterType
+	FilterRadius float64
+	Objects      []Hitable
+	Lights       []Light
+
+	bvh       Hitable   // Built from Objects by BuildBVH, used to accelerate hit testing
+	unbounded []Hitable // Objects with infinite bounds, e.g. planes, nil until BuildBVH is called
+
+	emitters []Emitter // Emissive objects sampled for direct lighting, see CollectEmitters
 }
 
 type File struct {
-	Name       string       `yaml:"name"`
-	Background t.RGB        `yaml:"background"`
-	Gamma      float64      `yaml:"gamma"`
-	Camera     FileCamera   `yaml:"camera"`
-	Objects    []FileObject `yaml:"objects"`
+	Name         string         `yaml:"name"`
+	Background   FileBackground `yaml:"background"`
+	Gamma        float64        `yaml:"gamma"`
+	Sampler      SamplerType    `yaml:"sampler"`
+	Filter       FilterType     `yaml:"filter"`
+	FilterRadius float64        `yaml:"filterRadius"`
+	Camera       FileCamera     `yaml:"camera"`
+	Objects      []FileObject   `yaml:"objects"`
+	Lights       []FileLight    `yaml:"lights"`
+
+	// Named objects that are not rendered directly, only via instances
+	Definitions map[string]FileObject `yaml:"definitions"`
 }
 
 type FileObject struct {
 	Type     string         `yaml:"type"`
-	Position t.Vec3         `yaml:"position"`
-	Radius   float64        `yaml:"radius"`
-	Material map[string]any `yaml:"material"`
+	Position t.Vec3         `yaml:"position,omitempty"`
+	Radius   float64        `yaml:"radius,omitempty"`
+	Normal   t.Vec3         `yaml:"normal,omitempty"`
+	Min      t.Vec3         `yaml:"min,omitempty"`
+	Max      t.Vec3         `yaml:"max,omitempty"`
+	Size     t.Vec3         `yaml:"size,omitempty"`
+	File     string         `yaml:"file,omitempty"`
+	Ref      string         `yaml:"ref,omitempty"`
+	Material map[string]any `yaml:"material,omitempty"`
+
+	Transform *FileTransform `yaml:"transform,omitempty"`
+}
+
+// General transform that can be applied to any object
+type FileTransform struct {
+	Translate t.Vec3    `yaml:"translate"`
+	Rotate    t.Vec3    `yaml:"rotate"`
+	Scale     FileScale `yaml:"scale"`
+}
+
+func (ft FileTransform) toTransform() (Transform, error) {
+	return NewTransform(ft.Translate, ft.Rotate, ft.Scale.vec())
+}
+
+// Scale can be a single number for uniform scaling, or a Vec3
+type FileScale t.Vec3
+
+func (s *FileScale) UnmarshalYAML(unmarshal func(any) error) error {
+	var uniform float64
+	if err := unmarshal(&uniform); err == nil {
+		*s = FileScale{X: uniform, Y: uniform, Z: uniform}
+		return nil
+	}
+
+	var v t.Vec3
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+
+	*s = FileScale(v)
+	return nil
+}
+
+func (s FileScale) MarshalYAML() (any, error) {
+	if s.X == s.Y && s.Y == s.Z {
+		return s.X, nil
+	}
+
+	return t.Vec3(s), nil
+}
+
+// -
+// Get the scale as a Vec3, where not set in the file the default scale is 1
+// -
+func (s FileScale) vec() t.Vec3 {
+	if t.Vec3(s).IsZero() {
+		return t.Vec3{X: 1, Y: 1, Z: 1}
+	}
+
+	return t.Vec3(s)
+}
+
+// Background can be a plain RGB colour, or a block describing an environment or sky
+type FileBackground struct {
+	Colour      t.RGB            `yaml:"-"`
+	Environment *FileEnvironment `yaml:"environment,omitempty"`
+	Sky         *FileSky         `yaml:"sky,omitempty"`
+}
+
+type FileEnvironment struct {
+	File      string   `yaml:"file"`
+	Rotation  float64  `yaml:"rotation,omitempty"`
+	Intensity *float64 `yaml:"intensity,omitempty"` // Nil when missing
+}
+
+type FileSky struct {
+	Mode      string   `yaml:"mode"`
+	Horizon   t.RGB    `yaml:"horizon,omitempty"`
+	Zenith    t.RGB    `yaml:"zenith,omitempty"`
+	Ground    t.RGB    `yaml:"ground,omitempty"`
+	Sun       t.Vec3   `yaml:"sun,omitempty"`
+	SunAngle  float64  `yaml:"sunAngle,omitempty"`
+	Turbidity float64  `yaml:"turbidity,omitempty"`
+	Intensity *float64 `yaml:"intensity,omitempty"` // Nil when missing
+}
+
+func (b *FileBackground) UnmarshalYAML(unmarshal func(any) error) error {
+	var colour t.RGB
+	if err := unmarshal(&colour); err == nil {
+		*b = FileBackground{Colour: colour}
+		return nil
+	}
+
+	// Alias avoids recursing back into this method
+	type plain FileBackground
+	return unmarshal((*plain)(b))
+}
+
+func (b FileBackground) MarshalYAML() (any, error) {
+	if b.Environment == nil && b.Sky == nil {
+		return b.Colour, nil
+	}
+
+	type plain FileBackground
+	return plain(b), nil
+}
+
+type FileLight struct {
+	Type      string   `yaml:"type"`
+	Position  t.Vec3   `yaml:"position,omitempty"`
+	Direction t.Vec3   `yaml:"direction,omitempty"`
+	Colour    t.RGB    `yaml:"colour,omitempty"`
+	Intensity *float64 `yaml:"intensity,omitempty"` // Nil when missing, so zero can turn a light off
+	Angle     float64  `yaml:"angle,omitempty"`
+	Falloff   float64  `yaml:"falloff,omitempty"`
 }
 
 type FileMaterial struct {
@@ -60,8 +189,10 @@ type FileCamera struct {
 
 // -
 // Parse a scene & camera from a YAML string
+// Any files referenced by the scene are opened through the asset resolver,
+// when nil they are loaded relative to the current directory
 // -
-func ParseScene(sceneData string, imgW, imgH int) (*Scene, *Camera, error) {
+func ParseScene(sceneData string, imgW, imgH int, assets AssetResolver) (*Scene, *Camera, error) {
 	log.Printf("Parsing scene data: %d bytes", len(sceneData))
 
 	var File File
@@ -88,38 +219,319 @@ func ParseScene(sceneData string, imgW, imgH int) (*Scene, *Camera, error) {
 		gamma = 2.2
 	}
 
+	if _, err := NewSampler(File.Sampler, 0, 1); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", err, File.Sampler)
+	}
+
+	if _, err := NewFilter(File.Filter, File.FilterRadius); err != nil {
+		return nil, nil, fmt.Errorf("filter %s: %w", File.Filter, err)
+	}
+
 	scene := &Scene{
-		Name:       File.Name,
-		Objects:    []Hitable{},
-		Background: File.Background,
-		Gamma:      gamma,
+		Name:         File.Name,
+		Objects:      []Hitable{},
+		Lights:       []Light{},
+		Gamma:        gamma,
+		Sampler:      File.Sampler,
+		Filter:       File.Filter,
+		FilterRadius: File.FilterRadius,
+	}
+
+	if assets == nil {
+		assets = DirResolver("")
+	}
+
+	scene.Background, err = parseBackground(File.Background, assets)
+	if err != nil {
+		log.Printf("Failed to create background: %s", err.Error())
+		scene.Background = SolidBackground{}
+	}
+
+	parser := objectParser{
+		assets:      assets,
+		definitions: File.Definitions,
+		built:       map[string]Hitable{},
+		building:    map[string]bool{},
+		images:      map[string]*HDRImage{},
 	}
 
 	for _, obj := range File.Objects {
-		switch obj.Type {
-		case "sphere":
-			worldObj, err := NewSphere(obj.Position, obj.Radius)
+		worldObj, err := parser.parse(obj, true)
+		if err != nil {
+			log.Printf("Failed to create %s object: %s", obj.Type, err.Error())
+			continue
+		}
+
+		scene.AddObject(worldObj)
+	}
+
+	for _, fileLight := range File.Lights {
+		light, err := parseLight(fileLight)
+		if err != nil {
+			log.Printf("Failed to create %s light: %s", fileLight.Type, err.Error())
+			continue
+		}
+
+		log.Printf("Added %s light", light.Type())
+		scene.Lights = append(scene.Lights, light)
+	}
+
+	scene.BuildBVH()
+	scene.CollectEmitters()
+
+	return scene, &camera, nil
+}
+
+// Used while parsing scene objects, holds what's needed to resolve instances
+type objectParser struct {
+	assets      AssetResolver
+	definitions map[string]FileObject
+	built       map[string]Hitable   // Definitions are built once, then shared by all instances
+	building    map[string]bool      // Guards against definitions that reference each other
+	images      map[string]*HDRImage // Texture images, loaded once however many times used
+}
+
+// -
+// Create a world object from a scene file object, with its material & transform
+// Definitions don't require a material, as instances can provide one
+// -
+func (p *objectParser) parse(obj FileObject, requireMaterial bool) (Hitable, error) {
+	if obj.Type == "instance" {
+		return p.parseInstance(obj, requireMaterial)
+	}
+
+	worldObj, err := p.parseShape(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	m := p.parseMaterial(obj.Material)
+	if m == nil && requireMaterial {
+		return nil, ErrNoMaterial
+	}
+
+	if m != nil {
+		worldObj.(objectBase).base().Material = m
+		log.Printf("Added %s at %v, material type: %s", obj.Type, obj.Position, m.Type())
+	}
+
+	// Meshes have the transform baked into their vertices, see parseShape
+	if obj.Transform != nil && obj.Type != "mesh" {
+		transform, err := obj.Transform.toTransform()
+		if err != nil {
+			return nil, err
+		}
+
+		// Wrap the object, moving rays into its space when hit testing
+		instance := NewInstance(worldObj, transform)
+		instance.Material = m
+		worldObj = instance
+	}
+
+	return worldObj, nil
+}
+
+// -
+// Create the basic geometry for an object, without material or transform
+// -
+func (p *objectParser) parseShape(obj FileObject) (Hitable, error) {
+	switch obj.Type {
+	case "sphere":
+		return NewSphere(obj.Position, obj.Radius)
+
+	case "plane":
+		return NewPlane(obj.Position, obj.Normal)
+
+	case "box":
+		// Boxes can be given as center position & size, or min & max corners
+		if !obj.Size.IsZero() {
+			return NewBoxCentered(obj.Position, obj.Size)
+		}
+
+		return NewBox(obj.Min, obj.Max)
+
+	case "mesh":
+		if obj.File == "" {
+			return nil, ErrNoMeshFile
+		}
+
+		transform := IdentityTransform()
+		if obj.Transform != nil {
+			var err error
+			transform, err = obj.Transform.toTransform()
 			if err != nil {
-				log.Printf("Failed to create sphere: %s", err.Error())
-				continue
+				return nil, err
 			}
+		}
 
-			m := parseMaterial(obj.Material)
-			if m != nil {
-				log.Printf("Added sphere at %v with radius %.1f, material type: %s", obj.Position, obj.Radius, m.Type())
-				worldObj.Material = m
-				scene.AddObject(worldObj)
-			}
+		f, err := p.assets.Open(obj.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
 
-		default:
-			log.Printf("Unknown object type: %s", obj.Type)
+		mesh, err := ParseOBJ(f, transform)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", obj.File, err)
 		}
+
+		log.Printf("Loaded mesh from %s with %d triangles", obj.File, len(mesh.Triangles))
+		return mesh, nil
 	}
 
-	return scene, &camera, nil
+	return nil, fmt.Errorf("%w: %s", ErrUnknownObject, obj.Type)
 }
 
-func parseMaterial(material map[string]any) Material {
+// -
+// Create an instance of a named definition, the definition is only built once
+// -
+func (p *objectParser) parseInstance(obj FileObject, requireMaterial bool) (Hitable, error) {
+	def, ok := p.definitions[obj.Ref]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDefinition, obj.Ref)
+	}
+
+	shared, ok := p.built[obj.Ref]
+	if !ok {
+		if p.building[obj.Ref] {
+			return nil, ErrInstanceCycle
+		}
+
+		p.building[obj.Ref] = true
+		var err error
+		shared, err = p.parse(def, false)
+		delete(p.building, obj.Ref)
+
+		if err != nil {
+			return nil, fmt.Errorf("definition %s: %w", obj.Ref, err)
+		}
+
+		p.built[obj.Ref] = shared
+	}
+
+	transform := IdentityTransform()
+	if obj.Transform != nil {
+		var err error
+		transform, err = obj.Transform.toTransform()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	instance := NewInstance(shared, transform)
+
+	// Instance material overrides the definition's, but one of them must be set
+	m := p.parseMaterial(obj.Material)
+	if m == nil {
+		m = shared.(objectBase).base().Material
+	}
+
+	if m == nil && requireMaterial {
+		return nil, ErrNoMaterial
+	}
+
+	instance.Material = m
+	log.Printf("Added instance of %s at %v", obj.Ref, instance.Position)
+
+	return instance, nil
+}
+
+func parseBackground(fileBackground FileBackground, assets AssetResolver) (Background, error) {
+	if fileBackground.Sky != nil {
+		return parseSky(*fileBackground.Sky)
+	}
+
+	env := fileBackground.Environment
+	if env == nil {
+		return SolidBackground{Colour: fileBackground.Colour}, nil
+	}
+
+	if env.File == "" {
+		return nil, ErrNoEnvironmentFile
+	}
+
+	f, err := assets.Open(env.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	image, err := DecodeHDR(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", env.File, err)
+	}
+
+	log.Printf("Loaded environment map from %s, %dx%d", env.File, image.Width, image.Height)
+
+	return NewEnvironmentMap(image, env.Rotation, optional(env.Intensity, 1)), nil
+}
+
+func parseSky(sky FileSky) (Background, error) {
+	switch sky.Mode {
+	case "gradient":
+		if sky.Horizon.IsBlack() && sky.Zenith.IsBlack() {
+			sky.Horizon = t.White()
+			sky.Zenith = t.RGB{R: 0.5, G: 0.7, B: 1.0}
+		}
+
+		if sky.Ground.IsBlack() {
+			sky.Ground = sky.Horizon
+		}
+
+		log.Printf("Added gradient sky")
+		return NewGradientSky(sky.Horizon, sky.Zenith, sky.Ground), nil
+
+	case "daylight":
+		if sky.Sun.IsZero() {
+			log.Printf("No sun direction specified, defaulting to overhead")
+			sky.Sun = t.Vec3{Y: 1}
+		}
+
+		if sky.Turbidity == 0 {
+			sky.Turbidity = 3
+		}
+
+		if sky.SunAngle == 0 {
+			sky.SunAngle = 0.27
+		}
+
+		if sky.Ground.IsBlack() {
+			sky.Ground = t.RGB{R: 0.3, G: 0.3, B: 0.3}
+		}
+
+		log.Printf("Added daylight sky, turbidity %.1f", sky.Turbidity)
+		return NewDaylightSky(sky.Sun, sky.Turbidity, sky.SunAngle, optional(sky.Intensity, 1), sky.Ground)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSky, sky.Mode)
+}
+
+func parseLight(fileLight FileLight) (Light, error) {
+	if fileLight.Colour.IsBlack() {
+		fileLight.Colour = t.White()
+	}
+
+	if fileLight.Intensity == nil {
+		log.Printf("No light intensity specified, defaulting to 1")
+	}
+	intensity := optional(fileLight.Intensity, 1)
+
+	switch fileLight.Type {
+	case "point":
+		return NewPointLight(fileLight.Position, fileLight.Colour, intensity), nil
+
+	case "spot":
+		return NewSpotLight(fileLight.Position, fileLight.Direction, fileLight.Colour,
+			intensity, fileLight.Angle, fileLight.Falloff)
+
+	case "directional":
+		return NewDirectionalLight(fileLight.Direction, fileLight.Colour, intensity)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownLight, fileLight.Type)
+}
+
+func (p *objectParser) parseMaterial(material map[string]any) Material {
 	if material == nil {
 		return nil
 	}
@@ -127,19 +539,15 @@ func parseMaterial(material map[string]any) Material {
 	if props, ok := material["dielectric"]; ok {
 		if props == nil {
 			log.Printf("Warning, dielectric material was empty")
-			return DielectricMaterial{}
+			return DielectricMaterial{Tint: NewSolidTexture(t.Black())}
 		}
 
 		propMap := props.(map[string]any)
 
 		m := DielectricMaterial{}
-		m.Tint = t.RGB{0.95, 0.95, 0.95}
+		m.Tint = NewSolidTexture(t.RGB{R: 0.95, G: 0.95, B: 0.95})
 		if propMap["tint"] != nil {
-			var err error
-			m.Tint, err = t.ParseRGB(propMap["tint"])
-			if err != nil {
-				log.Printf("Failed to parse tint: %s", err.Error())
-			}
+			m.Tint = p.parseColour(propMap["tint"], "tint")
 		}
 
 		m.Fuzz = parseFloatOrInt(propMap["fuzz"])
@@ -150,18 +558,13 @@ func parseMaterial(material map[string]any) Material {
 	if props, ok := material["diffuse"]; ok {
 		if props == nil {
 			log.Printf("Warning, diffuse material was empty")
-			return DiffuseMaterial{}
+			return DiffuseMaterial{Albedo: NewSolidTexture(t.Black())}
 		}
 
 		propMap := props.(map[string]any)
 
 		m := DiffuseMaterial{}
-
-		var err error
-		m.Albedo, err = t.ParseRGB(propMap["albedo"])
-		if err != nil {
-			log.Printf("Failed to parse albedo: %s", err.Error())
-		}
+		m.Albedo = p.parseColour(propMap["albedo"], "albedo")
 
 		return &m
 	}
@@ -169,17 +572,13 @@ func parseMaterial(material map[string]any) Material {
 	if props, ok := material["metal"]; ok {
 		if props == nil {
 			log.Printf("Warning, metal material was empty")
-			return MetalMaterial{}
+			return MetalMaterial{Albedo: NewSolidTexture(t.Black())}
 		}
 
 		propMap := props.(map[string]any)
 
 		m := MetalMaterial{}
-		var err error
-		m.Albedo, err = t.ParseRGB(propMap["albedo"])
-		if err != nil {
-			log.Printf("Failed to parse albedo: %s", err.Error())
-		}
+		m.Albedo = p.parseColour(propMap["albedo"], "albedo")
 		m.Fuzz = parseFloatOrInt(propMap["fuzz"])
 		return &m
 	}
@@ -187,17 +586,40 @@ func parseMaterial(material map[string]any) Material {
 	if props, ok := material["light"]; ok {
 		if props == nil {
 			log.Printf("Warning, light material was empty")
-			return LightMaterial{}
+			return LightMaterial{Emission: NewSolidTexture(t.Black())}
 		}
 
 		propMap := props.(map[string]any)
 
 		m := LightMaterial{}
-		var err error
-		m.Emission, err = t.ParseRGB(propMap["emission"])
-		if err != nil {
-			log.Printf("Failed to parse emission: %s", err.Error())
+		m.Emission = p.parseColour(propMap["emission"], "emission")
+		return &m
+	}
+
+	if props, ok := material["pbr"]; ok {
+		if props == nil {
+			log.Printf("Warning, pbr material was empty")
+			return NewPBRMaterial(NewSolidTexture(t.Black()), 0, 0.5, 0.5, nil)
+		}
+
+		propMap := props.(map[string]any)
+
+		baseColour := p.parseColour(propMap["baseColour"], "baseColour")
+
+		roughness, specular := 0.5, 0.5
+		if propMap["roughness"] != nil {
+			roughness = parseFloatOrInt(propMap["roughness"])
+		}
+		if propMap["specular"] != nil {
+			specular = parseFloatOrInt(propMap["specular"])
 		}
+
+		var emission Texture
+		if propMap["emission"] != nil {
+			emission = p.parseColour(propMap["emission"], "emission")
+		}
+
+		m := NewPBRMaterial(baseColour, parseFloatOrInt(propMap["metallic"]), roughness, specular, emission)
 		return &m
 	}
 
@@ -205,6 +627,145 @@ func parseMaterial(material map[string]any) Material {
 	return nil
 }
 
+// -
+// Parse a material colour field, which is either an RGB or a texture block
+// Failures are logged and give black, the same as a bad RGB always has
+// -
+func (p *objectParser) parseColour(data any, field string) Texture {
+	tex, err := p.parseTexture(data)
+	if err != nil {
+		log.Printf("Failed to parse %s: %s", field, err.Error())
+		return NewSolidTexture(t.Black())
+	}
+
+	return tex
+}
+
+// -
+// Parse an RGB into a solid texture, or a block with one of the texture types
+// -
+func (p *objectParser) parseTexture(data any) (Texture, error) {
+	block, ok := data.(map[string]any)
+	if !ok {
+		colour, err := t.ParseRGB(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewSolidTexture(colour), nil
+	}
+
+	if props, ok := block["checker"]; ok {
+		propMap, _ := props.(map[string]any)
+
+		even := Texture(NewSolidTexture(t.White()))
+		odd := Texture(NewSolidTexture(t.Black()))
+
+		var err error
+		if propMap["even"] != nil {
+			if even, err = p.parseTexture(propMap["even"]); err != nil {
+				return nil, fmt.Errorf("checker even: %w", err)
+			}
+		}
+
+		if propMap["odd"] != nil {
+			if odd, err = p.parseTexture(propMap["odd"]); err != nil {
+				return nil, fmt.Errorf("checker odd: %w", err)
+			}
+		}
+
+		scale := parseFloatOrInt(propMap["scale"])
+		if scale == 0 {
+			scale = 1
+		}
+
+		return NewCheckerTexture(even, odd, scale), nil
+	}
+
+	if props, ok := block["image"]; ok {
+		propMap, _ := props.(map[string]any)
+
+		file, _ := propMap["file"].(string)
+		if file == "" {
+			return nil, ErrNoImageFile
+		}
+
+		image, err := p.loadImage(file)
+		if err != nil {
+			return nil, err
+		}
+
+		wrap, _ := propMap["wrap"].(string)
+		if wrap == "" {
+			wrap = string(WrapRepeat)
+		}
+
+		scale := parseFloatOrInt(propMap["scale"])
+		if scale == 0 {
+			scale = 1
+		}
+
+		return NewImageTexture(image, WrapMode(wrap), scale)
+	}
+
+	if props, ok := block["noise"]; ok {
+		propMap, _ := props.(map[string]any)
+
+		var err error
+		low, high := t.Black(), t.White()
+		if propMap["low"] != nil {
+			if low, err = t.ParseRGB(propMap["low"]); err != nil {
+				return nil, fmt.Errorf("noise low: %w", err)
+			}
+		}
+
+		if propMap["high"] != nil {
+			if high, err = t.ParseRGB(propMap["high"]); err != nil {
+				return nil, fmt.Errorf("noise high: %w", err)
+			}
+		}
+
+		style, _ := propMap["style"].(string)
+		if style == "" {
+			style = string(NoiseSmooth)
+		}
+
+		scale := parseFloatOrInt(propMap["scale"])
+		if scale == 0 {
+			scale = 1
+		}
+
+		return NewNoiseTexture(low, high, scale, NoiseStyle(style))
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrUnknownTexture, block)
+}
+
+// -
+// Load an image for a texture, each file is only loaded once
+// -
+func (p *objectParser) loadImage(file string) (*HDRImage, error) {
+	if image, ok := p.images[file]; ok {
+		return image, nil
+	}
+
+	f, err := p.assets.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	image, err := DecodeImage(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	log.Printf("Loaded texture image from %s, %dx%d", file, image.Width, image.Height)
+	p.images[file] = image
+
+	return image, nil
+}
+
 func parseFloatOrInt(data any) float64 {
 	if data == nil {
 		return 0
@@ -226,4 +787,70 @@ func parseFloatOrInt(data any) float64 {
 // -
 func (s *Scene) AddObject(o Hitable) {
 	s.Objects = append(s.Objects, o)
+	// BVH is now stale, BuildBVH needs to be called again
+	s.bvh = nil
+	s.unbounded = nil
+}
+
+// -
+// Build the BVH over all objects in the scene, call after adding objects
+// -
+func (s *Scene) BuildBVH() {
+	bounded := []Hitable{}
+	s.unbounded = []Hitable{}
+
+	for _, obj := range s.Objects {
+		if obj.BoundingBox().IsBounded() {
+			bounded = append(bounded, obj)
+		} else {
+			s.unbounded = append(s.unbounded, obj)
+		}
+	}
+
+	s.bvh = NewBVH(bounded)
+	log.Printf("Built BVH over %d objects, %d unbounded objects", len(bounded), len(s.unbounded))
+}
+
+// -
+// Find the closest hit of a ray against the scene, nil if nothing was hit
+// Uses the BVH when it has been built, otherwise tests every object
+// -
+func (s Scene) closestHit(r Ray, interval Interval) *Hit {
+	objects := s.Objects
+	var hit *Hit = nil
+
+	// Once built, test the BVH and then the few objects that can't be in it
+	if s.bvh != nil || s.unbounded != nil {
+		objects = s.unbounded
+
+		if s.bvh != nil {
+			didHit, bvhHit := s.bvh.Hit(r, interval)
+			if didHit {
+				interval.Max = bvhHit.T
+				hit = &bvhHit
+			}
+		}
+	}
+
+	for _, obj := range objects {
+		didHit, objHit := obj.Hit(r, interval)
+		if didHit {
+			interval.Max = objHit.T
+			hit = &objHit
+		}
+	}
+
+	return hit
+}
+
+// -
+// Value of an optional number from a scene file, or the default when it's missing
+// Zero is a value like any other, e.g. a light can be turned off with an intensity of 0
+// -
+func optional(value *float64, def float64) float64 {
+	if value == nil {
+		return def
+	}
+
+	return *value
 }
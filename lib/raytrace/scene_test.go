@@ -0,0 +1,130 @@
+package raytrace
+
+import (
+	"reflect"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+
+	"gopkg.in/yaml.v3"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestSceneFileRoundTrip(t *testing.T) {
+	diffuse := map[string]any{"diffuse": map[string]any{"albedo": []any{0.5, 0.25, 0.75}}}
+
+	file := File{
+		Name:       "round trip",
+		Background: FileBackground{Colour: tu.RGB{R: 0.5, G: 0.5, B: 0.5}},
+		Camera:     FileCamera{Position: tu.Vec3{Z: 10}, Fov: 45},
+
+		Definitions: map[string]FileObject{
+			"ball": {Type: "sphere", Radius: 1.5, Material: diffuse},
+			"crate": {
+				Type:      "box",
+				Size:      tu.Vec3{X: 2, Y: 2, Z: 2},
+				Transform: &FileTransform{Rotate: tu.Vec3{Y: 45}, Scale: FileScale{X: 1.5, Y: 1.5, Z: 1.5}},
+			},
+		},
+
+		Objects: []FileObject{
+			{
+				Type: "instance",
+				Ref:  "ball",
+				Transform: &FileTransform{
+					Translate: tu.Vec3{X: -3, Y: 1.5},
+					Rotate:    tu.Vec3{X: 10, Y: 20, Z: 30},
+					Scale:     FileScale{X: 2, Y: 0.5, Z: 1},
+				},
+			},
+			{Type: "instance", Ref: "crate", Material: diffuse, Transform: &FileTransform{Translate: tu.Vec3{X: 3}}},
+			{Type: "plane", Normal: tu.Vec3{Y: 1}, Material: diffuse},
+		},
+
+		Lights: []FileLight{
+			{Type: "point", Position: tu.Vec3{Y: 5}, Colour: tu.White(), Intensity: floatPtr(2.5)},
+			{Type: "spot", Position: tu.Vec3{Y: 5}, Direction: tu.Vec3{Y: -1}, Colour: tu.White(), Intensity: floatPtr(10), Angle: 30, Falloff: 5},
+			{Type: "directional", Direction: tu.Vec3{Y: -1}, Intensity: floatPtr(0)},
+		},
+	}
+
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got File
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, file) {
+		t.Fatalf("round trip mismatch\ngot:  %+v\nwant: %+v\nyaml:\n%s", got, file, data)
+	}
+
+	scene, _, err := ParseScene(string(data), 64, 48, DirResolver(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scene.Objects) != 3 {
+		t.Fatalf("parsed %d objects, want 3", len(scene.Objects))
+	}
+
+	if len(scene.Lights) != 3 {
+		t.Fatalf("parsed %d lights, want 3", len(scene.Lights))
+	}
+
+	// An intensity of zero is kept, not mistaken for a missing one
+	if sun, ok := scene.Lights[2].(DirectionalLight); !ok || sun.Intensity != 0 {
+		t.Errorf("directional light is %+v, want intensity 0", scene.Lights[2])
+	}
+
+	for i, obj := range scene.Objects[:2] {
+		inst, ok := obj.(*Instance)
+		if !ok {
+			t.Fatalf("object %d is %T, want *Instance", i, obj)
+		}
+
+		if inst.Material == nil {
+			t.Errorf("object %d has no material", i)
+		}
+	}
+
+	// The crate definition has its own transform, so is itself wrapped in an instance
+	if _, ok := scene.Objects[1].(*Instance).Hitable.(*Instance); !ok {
+		t.Errorf("crate definition transform was not applied")
+	}
+}
+
+func TestParseSkyIntensity(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		intensity float64
+	}{
+		{"missing", "sky:\n  mode: daylight", 1},
+		{"zero", "sky:\n  mode: daylight\n  intensity: 0", 0},
+		{"given", "sky:\n  mode: daylight\n  intensity: 0.5", 0.5},
+	}
+
+	for _, test := range tests {
+		var fileBackground FileBackground
+		if err := yaml.Unmarshal([]byte(test.source), &fileBackground); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		background, err := parseBackground(fileBackground, DirResolver(""))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		sky := background.(*DaylightSky)
+		if sky.Intensity != test.intensity {
+			t.Errorf("%s: intensity is %v, want %v", test.name, sky.Intensity, test.intensity)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package raytrace
+
+import (
+	"math"
+	t "nanoray/lib/tuples"
+)
+
+// ============================================================
+// Gradient sky, blends from horizon to zenith colour by height
+// ============================================================
+
+type GradientSky struct {
+	Horizon t.RGB
+	Zenith  t.RGB
+	Ground  t.RGB // Colour of everything below the horizon
+}
+
+func NewGradientSky(horizon, zenith, ground t.RGB) GradientSky {
+	return GradientSky{
+		Horizon: horizon,
+		Zenith:  zenith,
+		Ground:  ground,
+	}
+}
+
+func (s GradientSky) colour(dir t.Vec3) t.RGB {
+	height := dir.NormalizeNew().Y
+	if height < 0 {
+		return s.Ground
+	}
+
+	return s.Horizon.Blend(s.Zenith, height)
+}
+
+func (s GradientSky) Type() string {
+	return "gradient"
+}
+
+// ============================================================
+// Daylight sky, the Preetham analytic model of a clear sky with a sun
+// See "A Practical Analytic Model for Daylight", Preetham et al. 1999
+// ============================================================
+
+const (
+	// Scale from the model's luminance in kcd/m² to radiance used in renders
+	skyScale = 0.05
+
+	// Illuminance from the sun before the atmosphere, in klux
+	sunIlluminance = 128.0
+)
+
+type DaylightSky struct {
+	Sun       t.Vec3 // Direction towards the sun
+	Turbidity float64
+	Intensity float64
+	Ground    t.RGB // Reflectance of the ground, lit by the sky at the horizon
+
+	thetaSun  float64       // Sun angle from the zenith, limited to the horizon
+	perez     [3][5]float64 // Distribution coefficients for Y, x & y
+	zenith    [3]float64    // Y, x & y at the zenith
+	sunColour t.RGB         // Radiance of the sun disc, zero when it has set
+	cosSun    float64       // Cosine of the sun's angular radius
+	sunPdf    float64       // Solid angle pdf of sampling the sun disc
+}
+
+// -
+// Create a new daylight sky, sunAngle is the angular radius of the sun in degrees
+// -
+func NewDaylightSky(sun t.Vec3, turbidity, sunAngle, intensity float64, ground t.RGB) (*DaylightSky, error) {
+	if sun.IsNearZero() {
+		return nil, ErrInvalidDirection
+	}
+
+	if turbidity < 1.7 || turbidity > 10 {
+		return nil, ErrInvalidTurbidity
+	}
+
+	if sunAngle <= 0 || sunAngle >= 90 {
+		return nil, ErrInvalidAngle
+	}
+
+	sun = sun.NormalizeNew()
+	T := turbidity
+	thetaSun := math.Acos(math.Max(0, sun.Y))
+
+	s := &DaylightSky{
+		Sun:       sun,
+		Turbidity: turbidity,
+		Intensity: intensity,
+		Ground:    ground,
+		thetaSun:  thetaSun,
+	}
+
+	s.perez = [3][5]float64{
+		{0.1787*T - 1.4630, -0.3554*T + 0.4275, -0.0227*T + 5.3251, 0.1206*T - 2.5771, -0.0670*T + 0.3703},
+		{-0.0193*T - 0.2592, -0.0665*T + 0.0008, -0.0004*T + 0.2125, -0.0641*T - 0.8989, -0.0033*T + 0.0452},
+		{-0.0167*T - 0.2608, -0.0950*T + 0.0092, -0.0079*T + 0.2102, -0.0441*T - 1.6537, -0.0109*T + 0.0529},
+	}
+
+	chi := (4.0/9.0 - T/120.0) * (math.Pi - 2*thetaSun)
+	th, th2, th3 := thetaSun, thetaSun*thetaSun, thetaSun*thetaSun*thetaSun
+
+	s.zenith[0] = (4.0453*T-4.9710)*math.Tan(chi) - 0.2155*T + 2.4192
+	s.zenith[1] = T*T*(0.00166*th3-0.00375*th2+0.00209*th) +
+		T*(-0.02903*th3+0.06377*th2-0.03202*th+0.00394) +
+		(0.11693*th3 - 0.21196*th2 + 0.06052*th + 0.25886)
+	s.zenith[2] = T*T*(0.00275*th3-0.00610*th2+0.00317*th) +
+		T*(-0.04214*th3+0.08970*th2-0.04153*th+0.00516) +
+		(0.15346*th3 - 0.26756*th2 + 0.06670*th + 0.26688)
+
+	rad := sunAngle * math.Pi / 180.0
+	s.cosSun = math.Cos(rad)
+
+	// Same as 1 - cosSun, but stable for small angles
+	solidAngle := 4 * math.Pi * math.Pow(math.Sin(rad/2), 2)
+	s.sunPdf = 1 / solidAngle
+
+	if sun.Y > 0 {
+		s.sunColour = sunTransmittance(thetaSun, T).MultScalarNew(sunIlluminance * skyScale / solidAngle)
+	}
+
+	return s, nil
+}
+
+// -
+// Fraction of sunlight in each channel that makes it through the atmosphere,
+// from Rayleigh scattering by air plus scattering by aerosols (haze)
+// -
+func sunTransmittance(thetaSun, turbidity float64) t.RGB {
+	degrees := thetaSun * 180.0 / math.Pi
+	mass := 1 / (math.Cos(thetaSun) + 0.15*math.Pow(93.885-degrees, -1.253))
+
+	beta := 0.04608*turbidity - 0.04586
+	channel := func(lambda float64) float64 {
+		rayleigh := 0.008735 * math.Pow(lambda, -4.08)
+		aerosol := beta * math.Pow(lambda, -1.3)
+		return math.Exp(-mass * (rayleigh + aerosol))
+	}
+
+	// Wavelengths in micrometres, roughly red, green & blue
+	return t.RGB{R: channel(0.68), G: channel(0.55), B: channel(0.44)}
+}
+
+// -
+// Perez distribution function, relative brightness for a view angle theta from the
+// zenith, at angle gamma from the sun
+// -
+func perezF(c [5]float64, cosTheta, gamma float64) float64 {
+	cosGamma := math.Cos(gamma)
+	return (1 + c[0]*math.Exp(c[1]/cosTheta)) * (1 + c[2]*math.Exp(c[3]*gamma) + c[4]*cosGamma*cosGamma)
+}
+
+// -
+// Sky radiance above the horizon, without the sun disc
+// -
+func (s DaylightSky) skyColour(dir t.Vec3) t.RGB {
+	cosTheta := math.Max(dir.Y, 0.001)
+	gamma := math.Acos(math.Max(-1, math.Min(1, dir.Dot(s.Sun))))
+
+	var Yxy [3]float64
+	for i := 0; i < 3; i++ {
+		Yxy[i] = s.zenith[i] * perezF(s.perez[i], cosTheta, gamma) / perezF(s.perez[i], 1, s.thetaSun)
+	}
+
+	// Convert Yxy to XYZ then to linear sRGB
+	Y, x, y := Yxy[0], Yxy[1], Yxy[2]
+	X := x / y * Y
+	Z := (1 - x - y) / y * Y
+
+	rgb := t.RGB{
+		R: math.Max(0, 3.2406*X-1.5372*Y-0.4986*Z),
+		G: math.Max(0, -0.9689*X+1.8758*Y+0.0415*Z),
+		B: math.Max(0, 0.0557*X-0.2040*Y+1.0570*Z),
+	}
+
+	return rgb.MultScalarNew(skyScale)
+}
+
+func (s DaylightSky) colour(dir t.Vec3) t.RGB {
+	dir = dir.NormalizeNew()
+
+	// Below the horizon we see the ground, lit by the sky near the horizon
+	if dir.Y < 0 {
+		horizon := t.Vec3{X: dir.X, Z: dir.Z}
+		if horizon.IsNearZero() {
+			horizon = t.Vec3{X: 1}
+		}
+
+		c := s.skyColour(horizon.NormalizeNew())
+		c.Mult(s.Ground)
+		return c.MultScalarNew(s.Intensity)
+	}
+
+	c := s.skyColour(dir)
+	if dir.Dot(s.Sun) >= s.cosSun {
+		c.Add(s.sunColour)
+	}
+
+	return c.MultScalarNew(s.Intensity)
+}
+
+func (s DaylightSky) Type() string {
+	return "daylight"
+}
+
+// -
+// Implement SampledBackground, only the sun is sampled as it is small & bright,
+// the rest of the sky is found by BSDF sampling
+// -
+func (s DaylightSky) sampleDir(smp Sampler) (t.Vec3, float64) {
+	if s.sunColour.IsBlack() {
+		return t.Zero(), 0
+	}
+
+	u1, u2 := smp.Get2D()
+	z := 1 + u1*(s.cosSun-1)
+	phi := 2 * math.Pi * u2
+	sinTheta := math.Sqrt(math.Max(0, 1-z*z))
+
+	return newONB(s.Sun).local(math.Cos(phi)*sinTheta, math.Sin(phi)*sinTheta, z), s.sunPdf
+}
+
+func (s DaylightSky) pdfDir(dir t.Vec3) float64 {
+	if s.sunColour.IsBlack() || dir.NormalizeNew().Dot(s.Sun) < s.cosSun {
+		return 0
+	}
+
+	return s.sunPdf
+}
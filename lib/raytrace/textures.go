@@ -0,0 +1,338 @@
+package raytrace
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"math/rand"
+	t "nanoray/lib/tuples"
+
+	// Image formats supported by image textures
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Texture gives a colour that can vary over the surface of an object
+type Texture interface {
+	// Colour at a hit, from its texture coordinates and/or position in space
+	value(uv UV, pos t.Vec3) t.RGB
+
+	Type() string
+}
+
+// -
+// True only for textures which are black everywhere
+// -
+func isBlack(tex Texture) bool {
+	solid, ok := tex.(SolidTexture)
+	return tex == nil || (ok && solid.Colour.IsBlack())
+}
+
+// ============================================================
+// Solid texture, the same colour everywhere
+// ============================================================
+
+type SolidTexture struct {
+	Colour t.RGB
+}
+
+func NewSolidTexture(colour t.RGB) SolidTexture {
+	return SolidTexture{
+		Colour: colour,
+	}
+}
+
+func (tex SolidTexture) value(uv UV, pos t.Vec3) t.RGB {
+	return tex.Colour
+}
+
+func (tex SolidTexture) Type() string {
+	return "solid"
+}
+
+// ============================================================
+// Checker texture, alternating squares of two other textures
+// ============================================================
+
+type CheckerTexture struct {
+	Even  Texture
+	Odd   Texture
+	Scale float64 // Number of squares per unit of UV
+}
+
+func NewCheckerTexture(even, odd Texture, scale float64) CheckerTexture {
+	return CheckerTexture{
+		Even:  even,
+		Odd:   odd,
+		Scale: scale,
+	}
+}
+
+func (tex CheckerTexture) value(uv UV, pos t.Vec3) t.RGB {
+	u := int(math.Floor(uv.U * tex.Scale))
+	v := int(math.Floor(uv.V * tex.Scale))
+
+	if (u+v)%2 == 0 {
+		return tex.Even.value(uv, pos)
+	}
+
+	return tex.Odd.value(uv, pos)
+}
+
+func (tex CheckerTexture) Type() string {
+	return "checker"
+}
+
+// ============================================================
+// Image texture, a PNG or JPEG image mapped over UV coordinates
+// ============================================================
+
+// How image textures behave outside the 0 to 1 UV range
+type WrapMode string
+
+const (
+	WrapRepeat WrapMode = "repeat"
+	WrapClamp  WrapMode = "clamp"
+	WrapMirror WrapMode = "mirror"
+)
+
+type ImageTexture struct {
+	Image *HDRImage
+	Wrap  WrapMode
+	Scale float64 // Number of image repeats per unit of UV
+}
+
+func NewImageTexture(image *HDRImage, wrap WrapMode, scale float64) (ImageTexture, error) {
+	switch wrap {
+	case WrapRepeat, WrapClamp, WrapMirror:
+	default:
+		return ImageTexture{}, fmt.Errorf("%w: %s", ErrUnknownWrapMode, wrap)
+	}
+
+	return ImageTexture{
+		Image: image,
+		Wrap:  wrap,
+		Scale: scale,
+	}, nil
+}
+
+// -
+// Decode a PNG or JPEG image, converting it from sRGB to linear colour
+// -
+func DecodeImage(r io.Reader) (*HDRImage, error) {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	img := &HDRImage{
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Pix:    make([]float32, bounds.Dx()*bounds.Dy()*3),
+	}
+
+	// Lookup table as there are only 256 possible values per channel
+	var linear [256]float32
+	for i := range linear {
+		linear[i] = float32(math.Pow(float64(i)/255.0, 2.2))
+	}
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			r, g, b, _ := src.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			i := (y*img.Width + x) * 3
+			img.Pix[i] = linear[r>>8]
+			img.Pix[i+1] = linear[g>>8]
+			img.Pix[i+2] = linear[b>>8]
+		}
+	}
+
+	return img, nil
+}
+
+// -
+// Apply the wrap mode to a pixel coordinate
+// -
+func (tex ImageTexture) wrap(i, size int) int {
+	switch tex.Wrap {
+	case WrapClamp:
+		return min(max(i, 0), size-1)
+
+	case WrapMirror:
+		period := 2 * size
+		i = ((i % period) + period) % period
+		if i >= size {
+			i = period - 1 - i
+		}
+		return i
+
+	default:
+		return ((i % size) + size) % size
+	}
+}
+
+// -
+// Bilinear filtered lookup, V runs up from the bottom of the image
+// -
+func (tex ImageTexture) value(uv UV, pos t.Vec3) t.RGB {
+	fx := uv.U*tex.Scale*float64(tex.Image.Width) - 0.5
+	fy := (1-uv.V*tex.Scale)*float64(tex.Image.Height) - 0.5
+
+	x0 := int(math.Floor(fx))
+	y0 := int(math.Floor(fy))
+	tx := fx - float64(x0)
+	ty := fy - float64(y0)
+
+	w, h := tex.Image.Width, tex.Image.Height
+	x1, y1 := tex.wrap(x0+1, w), tex.wrap(y0+1, h)
+	x0, y0 = tex.wrap(x0, w), tex.wrap(y0, h)
+
+	top := tex.Image.At(x0, y0).Blend(tex.Image.At(x1, y0), tx)
+	bottom := tex.Image.At(x0, y1).Blend(tex.Image.At(x1, y1), tx)
+
+	return top.Blend(bottom, ty)
+}
+
+func (tex ImageTexture) Type() string {
+	return "image"
+}
+
+// ============================================================
+// Noise texture, Perlin noise in 3D space blended between two colours
+// ============================================================
+
+// Styles of noise texture
+type NoiseStyle string
+
+const (
+	NoiseSmooth     NoiseStyle = "smooth"     // Plain Perlin noise
+	NoiseTurbulence NoiseStyle = "turbulence" // Several octaves of noise summed
+	NoiseMarble     NoiseStyle = "marble"     // Stripes disturbed by turbulence
+)
+
+type NoiseTexture struct {
+	Low   t.RGB
+	High  t.RGB
+	Scale float64
+	Style NoiseStyle
+}
+
+func NewNoiseTexture(low, high t.RGB, scale float64, style NoiseStyle) (NoiseTexture, error) {
+	switch style {
+	case NoiseSmooth, NoiseTurbulence, NoiseMarble:
+	default:
+		return NoiseTexture{}, fmt.Errorf("%w: %s", ErrUnknownNoiseStyle, style)
+	}
+
+	return NoiseTexture{
+		Low:   low,
+		High:  high,
+		Scale: scale,
+		Style: style,
+	}, nil
+}
+
+func (tex NoiseTexture) value(uv UV, pos t.Vec3) t.RGB {
+	p := pos.MultScalarNew(tex.Scale)
+
+	var n float64
+	switch tex.Style {
+	case NoiseTurbulence:
+		n = perlin.turbulence(p, 7)
+	case NoiseMarble:
+		n = 0.5 * (1 + math.Sin(p.Z+10*perlin.turbulence(p, 7)))
+	default:
+		n = 0.5 * (1 + perlin.noise(p))
+	}
+
+	return tex.Low.Blend(tex.High, math.Max(0, math.Min(1, n)))
+}
+
+func (tex NoiseTexture) Type() string {
+	return "noise"
+}
+
+// Shared noise generator, with a fixed seed so every worker renders the same pattern
+var perlin = newPerlinNoise(42)
+
+type perlinNoise struct {
+	gradients [256]t.Vec3
+	permX     [256]int
+	permY     [256]int
+	permZ     [256]int
+}
+
+func newPerlinNoise(seed int64) *perlinNoise {
+	rng := rand.New(rand.NewSource(seed))
+	p := &perlinNoise{}
+
+	for i := range p.gradients {
+		p.gradients[i] = t.Vec3{
+			X: rng.Float64()*2 - 1,
+			Y: rng.Float64()*2 - 1,
+			Z: rng.Float64()*2 - 1,
+		}.NormalizeNew()
+	}
+
+	for _, perm := range []*[256]int{&p.permX, &p.permY, &p.permZ} {
+		for i := range perm {
+			perm[i] = i
+		}
+
+		rng.Shuffle(len(perm), func(i, j int) {
+			perm[i], perm[j] = perm[j], perm[i]
+		})
+	}
+
+	return p
+}
+
+// -
+// Gradient noise at a point, roughly in the range -1 to 1
+// -
+func (p *perlinNoise) noise(pos t.Vec3) float64 {
+	fx, fy, fz := math.Floor(pos.X), math.Floor(pos.Y), math.Floor(pos.Z)
+	u, v, w := pos.X-fx, pos.Y-fy, pos.Z-fz
+	i, j, k := int(fx), int(fy), int(fz)
+
+	// Hermite smoothing of the interpolation weights
+	uu := u * u * (3 - 2*u)
+	vv := v * v * (3 - 2*v)
+	ww := w * w * (3 - 2*w)
+
+	total := 0.0
+	for di := 0; di < 2; di++ {
+		for dj := 0; dj < 2; dj++ {
+			for dk := 0; dk < 2; dk++ {
+				grad := p.gradients[p.permX[(i+di)&255]^p.permY[(j+dj)&255]^p.permZ[(k+dk)&255]]
+				weight := t.Vec3{X: u - float64(di), Y: v - float64(dj), Z: w - float64(dk)}
+
+				fi, fj, fk := float64(di), float64(dj), float64(dk)
+				total += (fi*uu + (1-fi)*(1-uu)) *
+					(fj*vv + (1-fj)*(1-vv)) *
+					(fk*ww + (1-fk)*(1-ww)) *
+					grad.Dot(weight)
+			}
+		}
+	}
+
+	return total
+}
+
+// -
+// Sum of several octaves of noise, each at double the frequency and half the weight
+// -
+func (p *perlinNoise) turbulence(pos t.Vec3, depth int) float64 {
+	total := 0.0
+	weight := 1.0
+
+	for i := 0; i < depth; i++ {
+		total += weight * p.noise(pos)
+		weight *= 0.5
+		pos = pos.MultScalarNew(2)
+	}
+
+	return math.Abs(total)
+}
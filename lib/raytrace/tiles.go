@@ -0,0 +1,232 @@
+package raytrace
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"sort"
+)
+
+// Tile is a rectangular region of the output image, rendered as a single job
+type Tile struct {
+	X, Y          int
+	Width, Height int
+}
+
+// Used when a tile size isn't given
+const DefaultTileSize = 64
+
+// TileOrder controls the order tiles are rendered in
+type TileOrder string
+
+const (
+	TileScanline TileOrder = "scanline" // Left to right, top to bottom
+	TileSpiral   TileOrder = "spiral"   // Outwards from the centre of the image
+	TileHilbert  TileOrder = "hilbert"  // Along a Hilbert curve, keeping neighbours close in time
+)
+
+// -
+// Split an image into tiles of the given size, edge tiles are clamped to the
+// image bounds so may be smaller. Tiles are returned in the given order
+// A tile size of zero uses DefaultTileSize, and the default order is scanline
+// -
+func MakeTiles(imgW, imgH, tileW, tileH int, order TileOrder) ([]Tile, error) {
+	if imgW <= 0 || imgH <= 0 {
+		return nil, ErrInvalidSize
+	}
+
+	if tileW <= 0 {
+		tileW = DefaultTileSize
+	}
+	if tileH <= 0 {
+		tileH = DefaultTileSize
+	}
+
+	tileW = min(tileW, imgW)
+	tileH = min(tileH, imgH)
+
+	cols := (imgW + tileW - 1) / tileW
+	rows := (imgH + tileH - 1) / tileH
+
+	tiles := make([]Tile, 0, cols*rows)
+	keys := make([]float64, 0, cols*rows)
+
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			x, y := col*tileW, row*tileH
+			tiles = append(tiles, Tile{
+				X:      x,
+				Y:      y,
+				Width:  min(tileW, imgW-x),
+				Height: min(tileH, imgH-y),
+			})
+
+			switch order {
+			case TileScanline, "":
+				keys = append(keys, float64(len(keys)))
+			case TileSpiral:
+				keys = append(keys, spiralKey(col, row, cols, rows))
+			case TileHilbert:
+				keys = append(keys, float64(hilbertIndex(col, row, max(cols, rows))))
+			default:
+				return nil, fmt.Errorf("%w: %s", ErrUnknownTileOrder, order)
+			}
+		}
+	}
+
+	indexes := make([]int, len(tiles))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return keys[indexes[i]] < keys[indexes[j]]
+	})
+
+	sorted := make([]Tile, len(tiles))
+	for i, index := range indexes {
+		sorted[i] = tiles[index]
+	}
+
+	return sorted, nil
+}
+
+// -
+// Sort key for the spiral order, tiles are grouped in square rings around the
+// centre, then ordered by angle within each ring
+// -
+func spiralKey(col, row, cols, rows int) float64 {
+	dx := float64(col) - float64(cols-1)/2
+	dy := float64(row) - float64(rows-1)/2
+
+	ring := math.Max(math.Abs(dx), math.Abs(dy))
+	angle := (math.Atan2(dy, dx) + math.Pi) / (2*math.Pi + 1e-9)
+
+	return ring + angle*0.5
+}
+
+// -
+// Position of a cell along a Hilbert curve filling a grid at least size cells wide
+// -
+func hilbertIndex(x, y, size int) int {
+	n := 1
+	for n < size {
+		n *= 2
+	}
+
+	d := 0
+	for s := n / 2; s > 0; s /= 2 {
+		rx, ry := 0, 0
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+
+		d += s * s * ((3 * rx) ^ ry)
+
+		// Rotate the quadrant so the curve joins up
+		if ry == 0 {
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+
+	return d
+}
+
+// TileEncoding is how the image data for a rendered tile is sent over the wire
+type TileEncoding string
+
+const (
+	TileRaw TileEncoding = "raw" // Uncompressed RGBA bytes
+	TilePNG TileEncoding = "png" // PNG compressed, much smaller for large tiles
+
+	// Linear float32 RGB radiance sums with their filter weight, only used for progressive
+	// passes which need unclamped values to average correctly, see EncodeRadiance
+	TileRadiance TileEncoding = "f32"
+)
+
+// All the RGBA encodings this build supports, in order of preference
+var TileEncodings = []TileEncoding{TilePNG, TileRaw}
+
+// -
+// Pick the most preferred encoding that the other side also supports
+// Falls back to raw, which is always understood
+// -
+func NegotiateEncoding(preferred TileEncoding, supported []string) TileEncoding {
+	for _, enc := range supported {
+		if TileEncoding(enc) == preferred {
+			return preferred
+		}
+	}
+
+	return TileRaw
+}
+
+// -
+// Encode RGBA tile pixels for sending, an empty encoding is treated as raw
+// -
+func EncodeTile(img *image.RGBA, encoding TileEncoding) ([]byte, error) {
+	switch encoding {
+	case TileRaw, "":
+		return img.Pix, nil
+
+	case TilePNG:
+		buf := bytes.Buffer{}
+		// Speed matters more than size here, tiles are encoded on every job
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		if err := enc.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	return nil, ErrUnknownEncoding
+}
+
+// -
+// Decode tile image data back to RGBA pixels, checking it matches the tile size
+// -
+func DecodeTile(data []byte, encoding TileEncoding, width, height int) (*image.RGBA, error) {
+	switch encoding {
+	case TileRaw, "":
+		if len(data) != width*height*4 {
+			return nil, ErrTileSize
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		img.Pix = data
+
+		return img, nil
+
+	case TilePNG:
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		if decoded.Bounds().Dx() != width || decoded.Bounds().Dy() != height {
+			return nil, ErrTileSize
+		}
+
+		// Encoded from RGBA, so should decode to the same, but convert if not
+		img, ok := decoded.(*image.RGBA)
+		if !ok {
+			img = image.NewRGBA(image.Rect(0, 0, width, height))
+			draw.Draw(img, img.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+		}
+
+		return img, nil
+	}
+
+	return nil, ErrUnknownEncoding
+}
@@ -0,0 +1,185 @@
+package raytrace
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestMakeTilesCoverImage(t *testing.T) {
+	cases := []struct {
+		imgW, imgH   int
+		tileW, tileH int
+	}{
+		{100, 60, 32, 32},
+		{64, 64, 64, 64},
+		{65, 33, 16, 8},
+		{10, 10, 64, 64},
+		{200, 150, 0, 0},
+	}
+
+	for _, tc := range cases {
+		for _, order := range []TileOrder{TileScanline, TileSpiral, TileHilbert} {
+			tiles, err := MakeTiles(tc.imgW, tc.imgH, tc.tileW, tc.tileH, order)
+			if err != nil {
+				t.Fatalf("%dx%d %s: %v", tc.imgW, tc.imgH, order, err)
+			}
+
+			// Every pixel belongs to exactly one tile
+			covered := make([]int, tc.imgW*tc.imgH)
+			for _, tile := range tiles {
+				if tile.Width <= 0 || tile.Height <= 0 || tile.X+tile.Width > tc.imgW || tile.Y+tile.Height > tc.imgH {
+					t.Fatalf("%dx%d %s: tile %+v outside the image", tc.imgW, tc.imgH, order, tile)
+				}
+
+				for y := tile.Y; y < tile.Y+tile.Height; y++ {
+					for x := tile.X; x < tile.X+tile.Width; x++ {
+						covered[y*tc.imgW+x]++
+					}
+				}
+			}
+
+			for i, n := range covered {
+				if n != 1 {
+					t.Fatalf("%dx%d %s: pixel %d,%d is in %d tiles", tc.imgW, tc.imgH, order, i%tc.imgW, i/tc.imgW, n)
+				}
+			}
+		}
+	}
+}
+
+func TestMakeTilesClampEdges(t *testing.T) {
+	tiles, err := MakeTiles(100, 50, 32, 32, TileScanline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tiles) != 8 {
+		t.Fatalf("got %d tiles, want 8", len(tiles))
+	}
+
+	last := tiles[len(tiles)-1]
+	if last != (Tile{X: 96, Y: 32, Width: 4, Height: 18}) {
+		t.Errorf("last tile is %+v", last)
+	}
+}
+
+func TestMakeTilesDefaultSize(t *testing.T) {
+	tiles, err := MakeTiles(DefaultTileSize*2, DefaultTileSize, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tiles) != 2 || tiles[0].Width != DefaultTileSize || tiles[0].Height != DefaultTileSize {
+		t.Errorf("got %+v", tiles)
+	}
+}
+
+func TestMakeTilesOrder(t *testing.T) {
+	scanline, _ := MakeTiles(40, 30, 10, 10, TileScanline)
+	for i, tile := range scanline {
+		if tile.X != i%4*10 || tile.Y != i/4*10 {
+			t.Fatalf("scanline tile %d is at %d,%d", i, tile.X, tile.Y)
+		}
+	}
+
+	// The centre tile of a 5x5 grid comes first
+	spiral, _ := MakeTiles(50, 50, 10, 10, TileSpiral)
+	if spiral[0].X != 20 || spiral[0].Y != 20 {
+		t.Errorf("spiral starts at %d,%d", spiral[0].X, spiral[0].Y)
+	}
+
+	// Each tile along a Hilbert curve is next to the one before
+	hilbert, _ := MakeTiles(80, 80, 10, 10, TileHilbert)
+	for i := 1; i < len(hilbert); i++ {
+		dx := abs(hilbert[i].X - hilbert[i-1].X)
+		dy := abs(hilbert[i].Y - hilbert[i-1].Y)
+		if dx+dy != 10 {
+			t.Fatalf("hilbert tile %d at %d,%d is not next to %d,%d", i, hilbert[i].X, hilbert[i].Y, hilbert[i-1].X, hilbert[i-1].Y)
+		}
+	}
+}
+
+func TestMakeTilesErrors(t *testing.T) {
+	if _, err := MakeTiles(0, 10, 8, 8, TileScanline); !errors.Is(err, ErrInvalidSize) {
+		t.Errorf("zero width gave %v", err)
+	}
+
+	if _, err := MakeTiles(10, 10, 8, 8, "zigzag"); !errors.Is(err, ErrUnknownTileOrder) {
+		t.Errorf("unknown order gave %v", err)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+func TestEncodeDecodeTile(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 37, 21))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+
+		// Rendered tiles are always opaque, PNG can't round trip premultiplied alpha
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+
+	for _, enc := range []TileEncoding{TileRaw, TilePNG, ""} {
+		data, err := EncodeTile(img, enc)
+		if err != nil {
+			t.Fatalf("%q: encode failed: %v", enc, err)
+		}
+
+		got, err := DecodeTile(data, enc, 37, 21)
+		if err != nil {
+			t.Fatalf("%q: decode failed: %v", enc, err)
+		}
+
+		if !bytes.Equal(got.Pix, img.Pix) {
+			t.Errorf("%q: decoded pixels differ", enc)
+		}
+
+		// Wrong tile size is caught rather than drawing garbage
+		if _, err := DecodeTile(data, enc, 36, 21); !errors.Is(err, ErrTileSize) {
+			t.Errorf("%q: wrong size gave error %v, want %v", enc, err, ErrTileSize)
+		}
+	}
+
+	if _, err := EncodeTile(img, "jpeg"); !errors.Is(err, ErrUnknownEncoding) {
+		t.Errorf("unknown encoding gave error %v", err)
+	}
+
+	if _, err := DecodeTile(img.Pix, "jpeg", 37, 21); !errors.Is(err, ErrUnknownEncoding) {
+		t.Errorf("unknown decoding gave error %v", err)
+	}
+
+	if _, err := DecodeTile([]byte("not a png"), TilePNG, 37, 21); err == nil {
+		t.Error("corrupt PNG decoded without error")
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		preferred TileEncoding
+		supported []string
+		want      TileEncoding
+	}{
+		{TilePNG, []string{"png", "raw"}, TilePNG},
+		{TilePNG, []string{"raw"}, TileRaw},
+		{TilePNG, nil, TileRaw}, // Old workers don't report encodings
+		{TileRaw, []string{"png", "raw"}, TileRaw},
+		{"zstd", []string{"png", "raw"}, TileRaw},
+	}
+
+	for _, tc := range cases {
+		if got := NegotiateEncoding(tc.preferred, tc.supported); got != tc.want {
+			t.Errorf("prefer %q from %v: got %q, want %q", tc.preferred, tc.supported, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package raytrace
+
+import (
+	"math"
+	t "nanoray/lib/tuples"
+)
+
+// Transform is an affine transformation, a 3x3 linear part plus a translation
+// Applied in the order: scale, rotate about X, then Y, then Z, then translate
+type Transform struct {
+	m     [3][3]float64
+	inv   [3][3]float64
+	trans t.Vec3
+}
+
+// -
+// Create a new transform from a translation, rotation in degrees and scale
+// -
+func NewTransform(translate, rotate, scale t.Vec3) (Transform, error) {
+	if scale.X == 0 || scale.Y == 0 || scale.Z == 0 {
+		return Transform{}, ErrInvalidScale
+	}
+
+	sx, cx := math.Sincos(rotate.X * math.Pi / 180.0)
+	sy, cy := math.Sincos(rotate.Y * math.Pi / 180.0)
+	sz, cz := math.Sincos(rotate.Z * math.Pi / 180.0)
+
+	rotX := [3][3]float64{{1, 0, 0}, {0, cx, -sx}, {0, sx, cx}}
+	rotY := [3][3]float64{{cy, 0, sy}, {0, 1, 0}, {-sy, 0, cy}}
+	rotZ := [3][3]float64{{cz, -sz, 0}, {sz, cz, 0}, {0, 0, 1}}
+	scaleM := [3][3]float64{{scale.X, 0, 0}, {0, scale.Y, 0}, {0, 0, scale.Z}}
+
+	m := matMult(rotZ, matMult(rotY, matMult(rotX, scaleM)))
+
+	// Inverse of a rotation is its transpose, so the inverse is cheap to build
+	invScale := [3][3]float64{{1 / scale.X, 0, 0}, {0, 1 / scale.Y, 0}, {0, 0, 1 / scale.Z}}
+	inv := matMult(invScale, matMult(matTranspose(rotX), matMult(matTranspose(rotY), matTranspose(rotZ))))
+
+	return Transform{m: m, inv: inv, trans: translate}, nil
+}
+
+// -
+// Transform that does nothing, handy as a default
+// -
+func IdentityTransform() Transform {
+	identity := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	return Transform{m: identity, inv: identity}
+}
+
+// -
+// Apply the transform to a point, including the translation
+// -
+func (tr Transform) Point(p t.Vec3) t.Vec3 {
+	return matVec(tr.m, p).AddNew(tr.trans)
+}
+
+// -
+// Apply the transform to a direction vector, translation is ignored
+// -
+func (tr Transform) Vector(v t.Vec3) t.Vec3 {
+	return matVec(tr.m, v)
+}
+
+// -
+// Apply the transform to a surface normal, uses the inverse transpose so
+// normals stay perpendicular under non-uniform scaling. Result is normalized
+// -
+func (tr Transform) Normal(n t.Vec3) t.Vec3 {
+	return matVec(matTranspose(tr.inv), n).NormalizeNew()
+}
+
+// -
+// Apply the inverse transform to a point, i.e. move it from world into object space
+// -
+func (tr Transform) InversePoint(p t.Vec3) t.Vec3 {
+	return matVec(tr.inv, p.SubNew(tr.trans))
+}
+
+// -
+// Apply the inverse transform to a direction vector
+// -
+func (tr Transform) InverseVector(v t.Vec3) t.Vec3 {
+	return matVec(tr.inv, v)
+}
+
+// -
+// Bounding box of a box after it has been transformed, unbounded boxes stay unbounded
+// -
+func (tr Transform) Bounds(box AABB) AABB {
+	if !box.IsBounded() {
+		return InfiniteAABB()
+	}
+
+	inf := math.Inf(1)
+	out := AABB{t.Vec3{X: inf, Y: inf, Z: inf}, t.Vec3{X: -inf, Y: -inf, Z: -inf}}
+
+	for i := 0; i < 8; i++ {
+		corner := box.Min
+		if i&1 != 0 {
+			corner.X = box.Max.X
+		}
+		if i&2 != 0 {
+			corner.Y = box.Max.Y
+		}
+		if i&4 != 0 {
+			corner.Z = box.Max.Z
+		}
+
+		p := tr.Point(corner)
+		out = out.SurroundingBox(AABB{p, p})
+	}
+
+	return out
+}
+
+func matMult(a, b [3][3]float64) [3][3]float64 {
+	var out [3][3]float64
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			out[i][j] = a[i][0]*b[0][j] + a[i][1]*b[1][j] + a[i][2]*b[2][j]
+		}
+	}
+
+	return out
+}
+
+func matTranspose(a [3][3]float64) [3][3]float64 {
+	var out [3][3]float64
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			out[i][j] = a[j][i]
+		}
+	}
+
+	return out
+}
+
+func matVec(m [3][3]float64, v t.Vec3) t.Vec3 {
+	return t.Vec3{
+		X: m[0][0]*v.X + m[0][1]*v.Y + m[0][2]*v.Z,
+		Y: m[1][0]*v.X + m[1][1]*v.Y + m[1][2]*v.Z,
+		Z: m[2][0]*v.X + m[2][1]*v.Y + m[2][2]*v.Z,
+	}
+}
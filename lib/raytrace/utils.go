@@ -18,6 +18,26 @@ func GenerateID(input string) string {
 	return id[:6]
 }
 
+// -
+// Content address for a blob of data, identical data always gives the same hash
+// -
+func ContentHash(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// -
+// Identify a prepared scene, the camera depends on the image size as well as the scene
+// and the same scene with different asset files must not be mistaken for a cached one
+// -
+func SceneHash(sceneData string, imgW, imgH int, assets AssetManifest) string {
+	data := fmt.Sprintf("%dx%d\n", imgW, imgH)
+	for _, a := range assets.Proto() {
+		data += fmt.Sprintf("%s=%s\n", a.Path, a.Digest)
+	}
+
+	return ContentHash([]byte(data + sceneData))
+}
+
 // ============================================================
 // Simple interval between two numbers
 // ============================================================
@@ -65,6 +85,38 @@ func reflectance(cosine float64, ior float64) float64 {
 	return r0 + (1-r0)*math.Pow((1-cosine), 5)
 }
 
+// ============================================================
+// Orthonormal basis, used to sample directions around an axis
+// ============================================================
+
+type onb struct {
+	u, v, w t.Vec3
+}
+
+// -
+// Build a basis where w points along the given direction
+// -
+func newONB(dir t.Vec3) onb {
+	w := dir.NormalizeNew()
+
+	a := t.Vec3{X: 1, Y: 0, Z: 0}
+	if math.Abs(w.X) > 0.9 {
+		a = t.Vec3{X: 0, Y: 1, Z: 0}
+	}
+
+	v := w.Cross(a).NormalizeNew()
+	u := w.Cross(v)
+
+	return onb{u, v, w}
+}
+
+// -
+// Convert a vector in the basis local coords into world space
+// -
+func (b onb) local(x, y, z float64) t.Vec3 {
+	return b.u.MultScalarNew(x).AddNew(b.v.MultScalarNew(y)).AddNew(b.w.MultScalarNew(z))
+}
+
 // ============================================================
 // Axis-aligned bounding box
 // ============================================================
@@ -78,18 +130,94 @@ func NewAABB(min, max t.Vec3) AABB {
 	return AABB{min, max}
 }
 
+// -
+// Box covering all of space, used by objects such as planes which have no bounds
+// -
+func InfiniteAABB() AABB {
+	inf := math.Inf(1)
+	return AABB{t.Vec3{X: -inf, Y: -inf, Z: -inf}, t.Vec3{X: inf, Y: inf, Z: inf}}
+}
+
+// -
+// Check the box is finite, unbounded objects can't be placed in a BVH
+// -
+func (a AABB) IsBounded() bool {
+	for axis := 0; axis < 3; axis++ {
+		if math.IsInf(a.Min.Axis(axis), 0) || math.IsInf(a.Max.Axis(axis), 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (a *AABB) SurroundingBox(other AABB) AABB {
 	small := t.Vec3{
-		math.Min(a.Min.X, other.Min.X),
-		math.Min(a.Min.Y, other.Min.Y),
-		math.Min(a.Min.Z, other.Min.Z),
+		X: math.Min(a.Min.X, other.Min.X),
+		Y: math.Min(a.Min.Y, other.Min.Y),
+		Z: math.Min(a.Min.Z, other.Min.Z),
 	}
 
 	big := t.Vec3{
-		math.Max(a.Max.X, other.Max.X),
-		math.Max(a.Max.Y, other.Max.Y),
-		math.Max(a.Max.Z, other.Max.Z),
+		X: math.Max(a.Max.X, other.Max.X),
+		Y: math.Max(a.Max.Y, other.Max.Y),
+		Z: math.Max(a.Max.Z, other.Max.Z),
 	}
 
 	return AABB{small, big}
 }
+
+// -
+// Center point of the box, used when partitioning objects into a BVH
+// -
+func (a AABB) Centroid() t.Vec3 {
+	return a.Min.AddNew(a.Max).MultScalarNew(0.5)
+}
+
+// -
+// Index of the longest axis of the box, 0 = X, 1 = Y, 2 = Z
+// -
+func (a AABB) LongestAxis() int {
+	size := a.Max.SubNew(a.Min)
+	if size.X > size.Y && size.X > size.Z {
+		return 0
+	}
+
+	if size.Y > size.Z {
+		return 1
+	}
+
+	return 2
+}
+
+// -
+// Slab test, check if a ray passes through the box within the interval
+// -
+func (a AABB) Hit(r Ray, interval Interval) bool {
+	mins := [3]float64{a.Min.X, a.Min.Y, a.Min.Z}
+	maxs := [3]float64{a.Max.X, a.Max.Y, a.Max.Z}
+	origin := [3]float64{r.Origin.X, r.Origin.Y, r.Origin.Z}
+	dir := [3]float64{r.Dir.X, r.Dir.Y, r.Dir.Z}
+
+	for axis := 0; axis < 3; axis++ {
+		invD := 1.0 / dir[axis]
+		t0 := (mins[axis] - origin[axis]) * invD
+		t1 := (maxs[axis] - origin[axis]) * invD
+		if invD < 0 {
+			t0, t1 = t1, t0
+		}
+
+		if t0 > interval.Min {
+			interval.Min = t0
+		}
+		if t1 < interval.Max {
+			interval.Max = t1
+		}
+
+		if interval.Max < interval.Min {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,181 @@
+package raytrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	t "nanoray/lib/tuples"
+	"strconv"
+	"strings"
+)
+
+// Index into the vertex, texture & normal lists for one corner of a face
+// Zero means not present, as OBJ indices start at 1
+type objIndex struct {
+	v, vt, vn int
+}
+
+// -
+// Parse Wavefront OBJ data into a mesh, applying the transform to every vertex
+// Supports vertices, faces, normals and texture coords, other statements are ignored
+// Faces with more than three vertices are triangulated as a fan
+// -
+func ParseOBJ(reader io.Reader, transform Transform) (*Mesh, error) {
+	vertices := []t.Vec3{}
+	normals := []t.Vec3{}
+	uvs := []UV{}
+	triangles := []Hitable{}
+	skipped := 0
+
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "v":
+			v, err := parseOBJFloats(fields[1:], 3)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+
+			vertices = append(vertices, transform.Point(t.Vec3{X: v[0], Y: v[1], Z: v[2]}))
+
+		case "vn":
+			n, err := parseOBJFloats(fields[1:], 3)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+
+			normals = append(normals, transform.Normal(t.Vec3{X: n[0], Y: n[1], Z: n[2]}))
+
+		case "vt":
+			uv, err := parseOBJFloats(fields[1:], 2)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+
+			uvs = append(uvs, UV{uv[0], uv[1]})
+
+		case "f":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: face needs at least 3 vertices", lineNum)
+			}
+
+			corners := make([]objIndex, len(fields)-1)
+			for i, field := range fields[1:] {
+				idx, err := parseOBJIndex(field, len(vertices), len(uvs), len(normals))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, err)
+				}
+
+				corners[i] = idx
+			}
+
+			for i := 1; i < len(corners)-1; i++ {
+				tri, err := makeOBJTriangle(corners[0], corners[i], corners[i+1], vertices, uvs, normals)
+				if err != nil {
+					// Degenerate triangles are common in real models, just skip them
+					skipped++
+					continue
+				}
+
+				triangles = append(triangles, tri)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if skipped > 0 {
+		log.Printf("Skipped %d degenerate triangles in OBJ data", skipped)
+	}
+
+	log.Printf("Parsed OBJ data: %d vertices, %d triangles", len(vertices), len(triangles))
+
+	return NewMesh(triangles)
+}
+
+func makeOBJTriangle(a, b, c objIndex, vertices []t.Vec3, uvs []UV, normals []t.Vec3) (*Triangle, error) {
+	tri, err := NewTriangle(vertices[a.v-1], vertices[b.v-1], vertices[c.v-1])
+	if err != nil {
+		return nil, err
+	}
+
+	if a.vn > 0 && b.vn > 0 && c.vn > 0 {
+		tri.SetNormals(normals[a.vn-1], normals[b.vn-1], normals[c.vn-1])
+	}
+
+	if a.vt > 0 && b.vt > 0 && c.vt > 0 {
+		tri.SetUVs(uvs[a.vt-1], uvs[b.vt-1], uvs[c.vt-1])
+	}
+
+	return tri, nil
+}
+
+func parseOBJFloats(fields []string, count int) ([]float64, error) {
+	if len(fields) < count {
+		return nil, fmt.Errorf("expected %d values, got %d", count, len(fields))
+	}
+
+	out := make([]float64, count)
+	for i := 0; i < count; i++ {
+		f, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		out[i] = f
+	}
+
+	return out, nil
+}
+
+// -
+// Parse a face corner, one of: v, v/vt, v//vn or v/vt/vn
+// Negative indices are relative to the end of the lists so far
+// -
+func parseOBJIndex(field string, numV, numVT, numVN int) (objIndex, error) {
+	parts := strings.Split(field, "/")
+	counts := []int{numV, numVT, numVN}
+	indices := [3]int{}
+
+	for i, part := range parts {
+		if i > 2 {
+			return objIndex{}, fmt.Errorf("invalid face index %q", field)
+		}
+
+		if part == "" {
+			continue
+		}
+
+		idx, err := strconv.Atoi(part)
+		if err != nil {
+			return objIndex{}, fmt.Errorf("invalid face index %q", field)
+		}
+
+		if idx < 0 {
+			idx = counts[i] + idx + 1
+		}
+
+		if idx < 1 || idx > counts[i] {
+			return objIndex{}, fmt.Errorf("face index %q out of range", field)
+		}
+
+		indices[i] = idx
+	}
+
+	if indices[0] == 0 {
+		return objIndex{}, fmt.Errorf("face index %q has no vertex", field)
+	}
+
+	return objIndex{indices[0], indices[1], indices[2]}, nil
+}
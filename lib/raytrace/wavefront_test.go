@@ -0,0 +1,153 @@
+package raytrace
+
+import (
+	"strings"
+	"testing"
+
+	tu "nanoray/lib/tuples"
+)
+
+const testQuadOBJ = `# A unit quad, with normals & texture coords
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+vn 0 0 2
+
+f 1/1/1 2/2/1 3/3/1 4/4/1
+`
+
+func TestParseOBJQuad(t *testing.T) {
+	mesh, err := ParseOBJ(strings.NewReader(testQuadOBJ), IdentityTransform())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Four sided faces are split into a fan of triangles
+	if len(mesh.Triangles) != 2 {
+		t.Fatalf("got %d triangles, want 2", len(mesh.Triangles))
+	}
+
+	tri := mesh.Triangles[1].(*Triangle)
+	if tri.V0 != (tu.Vec3{X: 0, Y: 0, Z: 0}) || tri.V1 != (tu.Vec3{X: 1, Y: 1, Z: 0}) || tri.V2 != (tu.Vec3{X: 0, Y: 1, Z: 0}) {
+		t.Errorf("second triangle is %v %v %v", tri.V0, tri.V1, tri.V2)
+	}
+
+	if !tri.smooth || tri.N0 != (tu.Vec3{X: 0, Y: 0, Z: 1}) {
+		t.Errorf("normals not set, or not normalized: %v", tri.N0)
+	}
+
+	if tri.UV0 != (UV{0, 0}) || tri.UV1 != (UV{1, 1}) || tri.UV2 != (UV{0, 1}) {
+		t.Errorf("texture coords are %v %v %v", tri.UV0, tri.UV1, tri.UV2)
+	}
+}
+
+func TestParseOBJIndexForms(t *testing.T) {
+	data := `
+v 0 0 0
+v 1 0 0
+v 0 1 0
+vt 0.5 0.5
+f 1 2 3
+f 1/1 2/1 3/1
+f -3 -2 -1
+f 1//1 2//1 3//1
+`
+	// Normal indices point past the end, so the last face fails
+	_, err := ParseOBJ(strings.NewReader(data), IdentityTransform())
+	if err == nil || !strings.Contains(err.Error(), "line 9") {
+		t.Fatalf("expected an error on line 9, got %v", err)
+	}
+
+	data = strings.Replace(data, "f 1//1 2//1 3//1", "vn 0 0 1\nf 1//1 2//1 3//1", 1)
+	mesh, err := ParseOBJ(strings.NewReader(data), IdentityTransform())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mesh.Triangles) != 4 {
+		t.Fatalf("got %d triangles, want 4", len(mesh.Triangles))
+	}
+
+	plain := mesh.Triangles[0].(*Triangle)
+	textured := mesh.Triangles[1].(*Triangle)
+	relative := mesh.Triangles[2].(*Triangle)
+	smooth := mesh.Triangles[3].(*Triangle)
+
+	if plain.smooth || plain.UV0 != (UV{}) {
+		t.Errorf("plain face has normals or texture coords")
+	}
+	if textured.UV0 != (UV{0.5, 0.5}) || textured.smooth {
+		t.Errorf("v/vt face not textured")
+	}
+	if relative.V0 != plain.V0 || relative.V2 != plain.V2 {
+		t.Errorf("negative indices gave %v %v %v", relative.V0, relative.V1, relative.V2)
+	}
+	if !smooth.smooth || smooth.UV0 != (UV{}) {
+		t.Errorf("v//vn face has no normals")
+	}
+}
+
+func TestParseOBJTransform(t *testing.T) {
+	transform, err := NewTransform(tu.Vec3{X: 10, Y: 0, Z: 0}, tu.Vec3{}, tu.Vec3{X: 2, Y: 2, Z: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mesh, err := ParseOBJ(strings.NewReader(testQuadOBJ), transform)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tri := mesh.Triangles[0].(*Triangle)
+	if tri.V1 != (tu.Vec3{X: 12, Y: 0, Z: 0}) {
+		t.Errorf("transformed vertex is %v", tri.V1)
+	}
+}
+
+func TestParseOBJSkipsDegenerate(t *testing.T) {
+	data := `
+v 0 0 0
+v 1 0 0
+v 2 0 0
+v 0 1 0
+f 1 2 3
+f 1 2 4
+`
+	mesh, err := ParseOBJ(strings.NewReader(data), IdentityTransform())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mesh.Triangles) != 1 {
+		t.Errorf("got %d triangles, want 1", len(mesh.Triangles))
+	}
+}
+
+func TestParseOBJErrors(t *testing.T) {
+	cases := map[string]string{
+		"short face":     "v 0 0 0\nv 1 0 0\nf 1 2",
+		"missing vertex": "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 4",
+		"zero index":     "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 0 1 2",
+		"no vertex":      "v 0 0 0\nv 1 0 0\nv 0 1 0\nf /1 2 3",
+		"bad number":     "v 0 zero 0",
+		"short vertex":   "v 0 0",
+		"too many parts": "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1/1/1/1 2 3",
+	}
+
+	for name, data := range cases {
+		if _, err := ParseOBJ(strings.NewReader(data), IdentityTransform()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Only degenerate triangles leaves nothing to make a mesh from
+	_, err := ParseOBJ(strings.NewReader("v 0 0 0\nv 1 0 0\nv 2 0 0\nf 1 2 3"), IdentityTransform())
+	if err != ErrEmptyMesh {
+		t.Errorf("all degenerate gave %v, want %v", err, ErrEmptyMesh)
+	}
+}
@@ -28,6 +28,10 @@ func (c *RGB) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+func (c RGB) MarshalYAML() (interface{}, error) {
+	return []float64{c.R, c.G, c.B}, nil
+}
+
 func FromHexString(hex string) RGB {
 	var r, g, b uint8
 	fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b)
@@ -153,6 +157,10 @@ func ParseRGB(data any) (RGB, error) {
 	return RGB{tuple[0].(float64), tuple[1].(float64), tuple[2].(float64)}, nil
 }
 
+func (c RGB) IsBlack() bool {
+	return c.R == 0 && c.G == 0 && c.B == 0
+}
+
 func (c RGB) Equals(c2 RGB) bool {
 	return c.R == c2.R && c.G == c2.G && c.B == c2.B
 }
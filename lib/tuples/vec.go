@@ -3,7 +3,6 @@ package tuples
 import (
 	"fmt"
 	"math"
-	"math/rand/v2"
 )
 
 type Vec3 struct {
@@ -28,6 +27,10 @@ func (v *Vec3) UnmarshalYAML(unmarshal func(in any) error) error {
 	return nil
 }
 
+func (v Vec3) MarshalYAML() (any, error) {
+	return []float64{v.X, v.Y, v.Z}, nil
+}
+
 func Zero() Vec3 {
 	return Vec3{0, 0, 0}
 }
@@ -161,6 +164,20 @@ func (v Vec3) String() string {
 	return fmt.Sprintf("[%.2f, %.2f, %.2f]", v.X, v.Y, v.Z)
 }
 
+// -
+// Get a component by index, 0 = X, 1 = Y, 2 = Z
+// -
+func (v Vec3) Axis(i int) float64 {
+	switch i {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	default:
+		return v.Z
+	}
+}
+
 func (v Vec3) Clone() Vec3 {
 	return Vec3{v.X, v.Y, v.Z}
 }
@@ -179,61 +196,64 @@ func ParseVec3(data any) (Vec3, error) {
 // ============================================================
 
 // -
-// Generate a random vector inside the unit cube
+// Map three random numbers in [0, 1) to a vector inside the unit cube
 // -
-func RandVecCube() Vec3 {
-	return Vec3{
-		rand.Float64()*2 - 1,
-		rand.Float64()*2 - 1,
-		rand.Float64()*2 - 1,
-	}
+func RandVecCube(u, v, w float64) Vec3 {
+	return Vec3{u*2 - 1, v*2 - 1, w*2 - 1}
 }
 
 // -
-// Generate a random vector inside the unit sphere
+// Map two random numbers in [0, 1) to a uniformly distributed unit vector
 // -
-func RandVecSphere(normalise bool) Vec3 {
-	var v Vec3
-	// Keep generating random vectors until we get one inside the unit sphere
-	for {
-		v = RandVecCube()
-		if v.SquaredLength() < 1 {
-			break
-		}
-	}
+func RandVecSphere(u, v float64) Vec3 {
+	z := 1 - 2*u
+	r := math.Sqrt(math.Max(0, 1-z*z))
+	phi := 2 * math.Pi * v
 
-	if normalise {
-		v.Normalize()
-	}
+	return Vec3{r * math.Cos(phi), r * math.Sin(phi), z}
+}
 
-	return v
+// -
+// Map three random numbers in [0, 1) to a uniformly distributed vector inside the
+// unit sphere, the first two pick the direction and the third the distance
+// -
+func RandVecBall(u, v, w float64) Vec3 {
+	return RandVecSphere(u, v).MultNew(math.Cbrt(w))
 }
 
 // -
-// Generate a random vector inside the unit disk
+// Map two random numbers in [0, 1) to a vector inside the unit disk, using the
+// concentric mapping which keeps stratified samples well spread (Shirley & Chiu)
 // -
-func RandVecDisk(normalise bool) Vec3 {
-	var v Vec3
-
-	for {
-		v = Vec3{rand.Float64()*2 - 1, rand.Float64()*2 - 1, 0}
-		if v.SquaredLength() < 1 {
-			break
-		}
+func RandVecDisk(u, v float64, normalise bool) Vec3 {
+	a, b := u*2-1, v*2-1
+	if a == 0 && b == 0 {
+		return Vec3{}
 	}
 
+	var r, theta float64
+	if math.Abs(a) > math.Abs(b) {
+		r, theta = a, math.Pi/4*(b/a)
+	} else {
+		r, theta = b, math.Pi/2-math.Pi/4*(a/b)
+	}
+
+	d := Vec3{r * math.Cos(theta), r * math.Sin(theta), 0}
 	if normalise {
-		v.Normalize()
+		d.Normalize()
 	}
 
-	return v
+	return d
 }
 
-func RandVecSphereHemisphere(normal Vec3) Vec3 {
-	v := RandVecSphere(true)
-	if v.Dot(normal) > 0 {
-		return v
+// -
+// Map two random numbers in [0, 1) to a unit vector in the hemisphere around normal
+// -
+func RandVecSphereHemisphere(u, v float64, normal Vec3) Vec3 {
+	d := RandVecSphere(u, v)
+	if d.Dot(normal) > 0 {
+		return d
 	} else {
-		return v.NegateNew()
+		return d.NegateNew()
 	}
 }
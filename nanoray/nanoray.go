@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"image"
@@ -10,6 +11,7 @@ import (
 	"nanoray/lib/proto"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -22,12 +24,22 @@ func main() {
 		flag.PrintDefaults()
 	}
 
-	inputFile := flag.String("file", "", "Scene file to render, in YAML format")
+	inputFile := flag.String("file", "", "Scene file to render, in YAML format, or a zip/tar bundle of the scene and its assets")
 	outputFile := flag.String("output", "render.png", "Rendered output PNG file name")
 	width := flag.Int("width", 800, "Width of the output image")
 	aspectRatio := flag.Float64("aspect", 16.0/9.0, "Aspect ratio of the output image")
 	samplesPP := flag.Int("samples", 10, "Samples per pixel, higher values give better quality but slower rendering")
 	maxDepth := flag.Int("depth", 5, "Maximum ray recursion depth")
+	tileSize := flag.Int("tile", rt.DefaultTileSize, "Size of the square tiles the image is split into")
+	tileOrder := flag.String("order", string(rt.TileSpiral), "Order tiles are rendered in: scanline, spiral or hilbert")
+	noise := flag.Float64("noise", 0, "Adaptive sampling noise threshold, e.g. 0.02, pixels stop being sampled once below it")
+	minSamples := flag.Int("minsamples", 0, "Adaptive sampling, minimum samples for every pixel")
+	maxSamples := flag.Int("maxsamples", 0, "Adaptive sampling, maximum samples for any pixel, defaults to -samples")
+	heatmapFile := flag.String("heatmap", "", "Also write a heatmap of samples per pixel to this PNG file")
+	seed := flag.Int64("seed", 0, "Random seed, renders with the same seed and settings are identical")
+	sampler := flag.String("sampler", "", "Sampler to use: independent, stratified, halton or sobol, overrides the scene")
+	filter := flag.String("filter", "", "Pixel filter: box, tent, gaussian, mitchell or blackman-harris, overrides the scene")
+	filterRadius := flag.Float64("filterradius", 0, "Pixel filter radius, 0 for the filter's default")
 
 	flag.Parse()
 
@@ -41,7 +53,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	sceneData, err := os.ReadFile(*inputFile)
+	sceneData, assets, err := readScene(*inputFile)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -49,15 +61,38 @@ func main() {
 	render := rt.NewRender(*width, *aspectRatio)
 	render.SamplesPerPixel = *samplesPP
 	render.MaxDepth = *maxDepth
+	render.MinSamples = *minSamples
+	render.MaxSamples = *maxSamples
+	render.NoiseThreshold = *noise
+	render.Seed = *seed
 
-	scene, camera, err := rt.ParseScene(string(sceneData), render.Width, render.Height)
+	scene, camera, err := rt.ParseScene(sceneData, render.Width, render.Height, assets)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	render.Sampler = scene.Sampler
+	if *sampler != "" {
+		if _, err := rt.NewSampler(rt.SamplerType(*sampler), 0, 1); err != nil {
+			log.Fatalf("%s: %s", err, *sampler)
+		}
+
+		render.Sampler = rt.SamplerType(*sampler)
+	}
+
+	render.Filter, render.FilterRadius, err = rt.ResolveFilter(scene, rt.FilterType(*filter), *filterRadius)
+	if err != nil {
+		log.Fatalf("Invalid filter %s: %s", *filter, err)
+	}
+
+	tiles, err := rt.MakeTiles(render.Width, render.Height, *tileSize, *tileSize, rt.TileOrder(*tileOrder))
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	log.Println("🚀 Rendering started...")
 
-	img := Generate(*camera, *scene, render)
+	img, counts := Generate(*camera, *scene, render, tiles)
 
 	log.Println("📷 Rendering complete")
 	log.Println("🔹 ⌚ Time:", rt.Stats.Time)
@@ -75,48 +110,74 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if *heatmapFile != "" {
+		log.Println("💾 Writing: " + *heatmapFile)
+
+		hf, err := os.Create(*heatmapFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer hf.Close()
+
+		err = png.Encode(hf, rt.SampleHeatmap(counts, render.Width, render.Height))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
-func Generate(cam rt.Camera, scene rt.Scene, render rt.Render) image.Image {
+// Read a scene file or bundle, along with where to find the files it references
+func readScene(file string) (string, rt.AssetResolver, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".zip", ".tar", ".tgz", ".gz":
+		bundle, err := rt.ReadBundle(data)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return bundle.SceneData, bundle, nil
+	}
+
+	return string(data), rt.DirResolver(filepath.Dir(file)), nil
+}
+
+func Generate(cam rt.Camera, scene rt.Scene, render rt.Render, tiles []rt.Tile) (image.Image, []int32) {
 	rt.Stats.Start = time.Now()
 	imageOut := render.MakeImage()
+	counts := make([]int32, render.Width*render.Height)
+
+	totalJobs := len(tiles)
 
-	totalJobs := 16 //runtime.NumCPU()
-	if totalJobs > render.Height {
-		totalJobs = render.Height
+	// Jobs are queued in tile order, and picked up by one goroutine per CPU
+	jobs := make(chan *proto.JobRequest, totalJobs)
+	for i, tile := range tiles {
+		jobs <- render.NewJob(i, tile)
 	}
-	jobW := render.Width
-	jobH := render.Height / totalJobs
+	close(jobs)
 
-	jobCount := 0
 	// Create a channel to receive results from the jobs and synchronize them
 	results := make(chan *proto.JobResult)
 
-	for y := 0; y < render.Height; y += jobH {
-		for x := 0; x < render.Width; x += jobW {
-			job := &proto.JobRequest{
-				Id:              int32(jobCount),
-				Width:           int32(jobW),
-				Height:          int32(jobH),
-				X:               int32(x),
-				Y:               int32(y),
-				SamplesPerPixel: int32(render.SamplesPerPixel),
-				MaxDepth:        int32(render.MaxDepth),
-				ImageDetails:    render.ImageDetails(),
-			}
-
-			jobCount++
-
-			// Use goroutines to parallelize the rendering
-			go func() {
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func() {
+			for job := range jobs {
 				// Work all happens here, with the job + scene + camera
-				results <- rt.RenderJob(job, scene, cam)
-			}()
-		}
+				// Nothing cancels the context here, so there is never an error
+				res, _ := rt.RenderJob(context.Background(), job, scene, cam)
+				results <- res
+			}
+		}()
 	}
 
 	// Wait for all jobs to complete
-	for res := range results {
+	for jobCount := totalJobs; jobCount > 0; {
+		res := <-results
 		jobCount--
 
 		// Shonky progress bar
@@ -132,15 +193,18 @@ func Generate(cam rt.Camera, scene rt.Scene, render rt.Render) image.Image {
 		// Reconstruction of the main image from each job part
 		draw.Draw(imageOut, image.Rect(int(res.Job.X), int(res.Job.Y), int(res.Job.X+res.Job.Width), int(res.Job.Y+res.Job.Height)), jobImg, image.Point{0, 0}, draw.Src)
 
-		if jobCount == 0 {
-			close(results)
-			fmt.Println()
-			break
+		// Only adaptive jobs have sample counts, they come from this process so can't be malformed
+		var tileCounts []int32
+		if len(res.SampleCounts) > 0 {
+			tileCounts, _ = rt.DecodeSampleCounts(res.SampleCounts, int(res.Job.Width), int(res.Job.Height))
 		}
+		rt.AddSampleCounts(counts, render.Width, res.Job, tileCounts)
 	}
 
+	fmt.Println()
+
 	rt.Stats.End = time.Now()
 	rt.Stats.Time = rt.Stats.End.Sub(rt.Stats.Start)
 
-	return imageOut
+	return imageOut, counts
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"nanoray/lib/controller"
+	"nanoray/lib/raytrace"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Local copies of asset files, shared between renders by digest
+var blobs *raytrace.BlobStore
+
+// Fetch any assets we don't already have from the controller
+func fetchAssets(ctx context.Context, manifest raytrace.AssetManifest) error {
+	for name, digest := range manifest {
+		if blobs.Has(digest) {
+			continue
+		}
+
+		log.Printf("Fetching asset %s (%.12s) from controller", name, digest)
+
+		data, err := fetchBlob(ctx, digest)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		// Never store data under the wrong digest, it would be trusted forever
+		if raytrace.ContentHash(data) != digest {
+			return fmt.Errorf("%s: %w: content does not match", name, raytrace.ErrInvalidDigest)
+		}
+
+		if _, err := blobs.Put(data); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func fetchBlob(ctx context.Context, digest string) ([]byte, error) {
+	stream, err := controller.Client.GetBlob(ctx, wrapperspb.String(digest))
+	if err != nil {
+		return nil, err
+	}
+
+	var data bytes.Buffer
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return data.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data.Write(chunk.Value)
+	}
+}
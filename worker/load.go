@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"time"
+
+	"nanoray/lib/controller"
+	pb "nanoray/lib/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CPU time used by the process at the last heartbeat, to work out usage between beats
+var lastCPUTime time.Duration
+var lastCPUCheck time.Time
+
+// Send a heartbeat to the controller on every tick, with the current load
+// If the controller has forgotten this worker, e.g. after a restart, register again
+func sendHeartbeats(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		_, err := controller.Client.Heartbeat(ctx, &pb.WorkerHeartbeat{
+			WorkerID:    workerInfo.Id,
+			Load:        currentLoad(),
+			SceneHashes: scenes.hashes(),
+		})
+		cancel()
+
+		if status.Code(err) == codes.NotFound {
+			reregister()
+			continue
+		}
+
+		if err != nil {
+			log.Printf("Heartbeat failed: %s", err.Error())
+		}
+	}
+}
+
+// Measure how busy this worker is
+func currentLoad() *pb.WorkerLoad {
+	runningLock.Lock()
+	jobsRunning := len(running)
+	runningLock.Unlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	now := time.Now()
+	cpuTime := processCPUTime()
+
+	usage := 0.0
+	if !lastCPUCheck.IsZero() {
+		wall := now.Sub(lastCPUCheck) * time.Duration(runtime.NumCPU())
+		usage = float64(cpuTime-lastCPUTime) / float64(wall) * 100.0
+	}
+
+	lastCPUTime = cpuTime
+	lastCPUCheck = now
+
+	return &pb.WorkerLoad{
+		JobsRunning: int32(jobsRunning),
+		CpuUsage:    usage,
+		MemoryMB:    uint32(mem.Sys / 1024 / 1024),
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// CPU time isn't available on this platform, so usage is always reported as zero
+func processCPUTime() time.Duration {
+	return 0
+}
@@ -8,21 +8,31 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"nanoray/lib/controller"
 	pb "nanoray/lib/proto"
+	"nanoray/lib/raytrace"
 
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
-	portFlag     = flag.Int("port", 4000, "The port worker will listen on")
-	hostnameFlag = flag.String("hostname", "", "Override the hostname to use for the worker")
-	maxJobsFlag  = flag.Int("maxjobs", runtime.NumCPU(), "The maximum number of jobs to run concurrently")
-	workerInfo   pb.WorkerInfo
+	portFlag      = flag.Int("port", 4000, "The port worker will listen on")
+	hostnameFlag  = flag.String("hostname", "", "Override the hostname to use for the worker")
+	maxJobsFlag   = flag.Int("maxjobs", runtime.NumCPU(), "The maximum number of jobs to run concurrently")
+	pullFlag      = flag.Bool("pull", false, "Pull jobs from the controller instead of having them pushed, for workers it can't connect to")
+	cacheFlag     = flag.Int("scenecache", 8, "How many parsed scenes to keep in memory for reuse between renders")
+	heartbeatFlag = flag.Duration("heartbeat", 5*time.Second, "Interval between heartbeats sent to the controller")
+	blobsFlag     = flag.String("blobs", filepath.Join(os.TempDir(), "nanoray-blobs"), "Directory to keep asset files fetched from the controller")
+	workerInfo    pb.WorkerInfo
+	registering   atomic.Bool
+	scenes        *sceneCache
 )
 
 func main() {
@@ -56,8 +66,36 @@ func main() {
 		MaxJobs: int32(maxJobs),
 	}
 
+	scenes = newSceneCache(*cacheFlag)
+
+	var err error
+	blobs, err = raytrace.NewBlobStore(*blobsFlag)
+	if err != nil {
+		log.Fatalf("Failed to open blob store\n%s", err.Error())
+	}
+
+	for _, enc := range raytrace.TileEncodings {
+		workerInfo.Encodings = append(workerInfo.Encodings, string(enc))
+	}
+
 	log.Printf("Starting worker, will handle max jobs: %d", maxJobs)
 
+	// Wait as long as it takes for the controller to come up
+	err = controller.Connect(0)
+	if err != nil {
+		log.Fatalf("Failed to connect to controller: %s", err.Error())
+	}
+
+	// In pull mode there is no server, so the controller never needs to reach us
+	if *pullFlag {
+		log.Printf("Worker started in pull mode")
+
+		go sendHeartbeats(*heartbeatFlag)
+		pullJobs()
+
+		return
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		log.Fatalf("Failed to bind to port\n%s", err.Error())
@@ -66,11 +104,6 @@ func main() {
 	s := grpc.NewServer()
 	pb.RegisterWorkerServer(s, &server{})
 
-	err = controller.Connect(time.Second * 20)
-	if err != nil {
-		log.Fatalf("Failed to connect to controller: %s", err.Error())
-	}
-
 	log.Printf("Worker started on port %d", port)
 
 	// Start gRPC server in a goroutine, use a channel to block until it's done
@@ -85,17 +118,57 @@ func main() {
 	}()
 
 	log.Printf("Will register worker with hostname: %s", hostname)
-	_, err = controller.Client.RegisterWorker(context.Background(), &workerInfo)
-	if err != nil {
-		log.Fatalf("Registration failed\n%s", err.Error())
-	}
+	register()
 
-	log.Printf("Registered with controller, we are ready to work!")
+	go sendHeartbeats(*heartbeatFlag)
 
 	// Block until the server is done
 	<-ch
 }
 
+// Register with the controller, retrying with backoff until it succeeds
+func register() {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := controller.Client.RegisterWorker(ctx, registration())
+		cancel()
+
+		if err == nil {
+			log.Printf("Registered with controller, we are ready to work!")
+			return
+		}
+
+		delay := controller.Backoff(attempt)
+		log.Printf("Registration failed, retrying in %s\n%s", delay, err.Error())
+		time.Sleep(delay)
+	}
+}
+
+// Worker details for registering, including the scenes we still have cached
+func registration() *pb.WorkerInfo {
+	info := proto.Clone(&workerInfo).(*pb.WorkerInfo)
+	info.SceneHashes = scenes.hashes()
+
+	return info
+}
+
+// Register again when the controller has forgotten this worker, e.g. after it restarts
+// Only one registration runs at a time, other callers return straight away
+func reregister() {
+	// Pull mode workers register each time their job stream opens
+	if *pullFlag {
+		return
+	}
+
+	if !registering.CompareAndSwap(false, true) {
+		return
+	}
+	defer registering.Store(false)
+
+	log.Printf("Controller does not know this worker, registering again")
+	register()
+}
+
 func generateID(input string, len int) string {
 	hash := sha256.New()
 	hash.Write([]byte(input))
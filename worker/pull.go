@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"nanoray/lib/controller"
+	pb "nanoray/lib/proto"
+
+	"google.golang.org/grpc/status"
+)
+
+// Job stream currently open to the controller, nil while reconnecting
+// Replies and requests for jobs are sent from different goroutines, so sends take the lock
+var pullStream struct {
+	lock   sync.Mutex
+	stream pb.Controller_RequestJobsClient
+}
+
+// Pull mode, open a job stream to the controller and work on whatever arrives
+// The stream is reopened with backoff whenever it breaks, e.g. when the controller restarts
+func pullJobs() {
+	s := &server{}
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := controller.Client.RequestJobs(ctx)
+		if err == nil {
+			err = openStream(stream)
+		}
+
+		for err == nil {
+			var item *pb.WorkItem
+
+			item, err = stream.Recv()
+			if err == nil {
+				attempt = 0
+				err = handleWorkItem(s, stream, item)
+			}
+		}
+
+		pullStream.lock.Lock()
+		pullStream.stream = nil
+		pullStream.lock.Unlock()
+
+		cancel()
+
+		delay := controller.Backoff(attempt)
+		log.Printf("Job stream closed, reconnecting in %s\n%s", delay, err.Error())
+		time.Sleep(delay)
+	}
+}
+
+// Register on a new job stream, and ask for as many jobs as there is room for
+func openStream(stream pb.Controller_RequestJobsClient) error {
+	pullStream.lock.Lock()
+	defer pullStream.lock.Unlock()
+
+	err := stream.Send(&pb.PullMessage{Register: registration()})
+	if err != nil {
+		return err
+	}
+
+	// Jobs from before a reconnect may still be running, they ask for more as they finish
+	runningLock.Lock()
+	free := int(workerInfo.MaxJobs) - len(running)
+	runningLock.Unlock()
+
+	if free > 0 {
+		err = stream.Send(&pb.PullMessage{RequestJobs: int32(free)})
+	}
+
+	if err == nil {
+		pullStream.stream = stream
+	}
+
+	return err
+}
+
+// Send a message on the job stream, dropped if the stream is being reopened
+func sendPull(msg *pb.PullMessage) error {
+	pullStream.lock.Lock()
+	defer pullStream.lock.Unlock()
+
+	if pullStream.stream == nil {
+		return nil
+	}
+
+	return pullStream.stream.Send(msg)
+}
+
+// Ask the controller for another job when one finishes, only needed in pull mode
+func requestJob() {
+	if !*pullFlag {
+		return
+	}
+
+	err := sendPull(&pb.PullMessage{RequestJobs: 1})
+	if err != nil {
+		log.Printf("Failed to ask controller for another job: %s", err.Error())
+	}
+}
+
+// Handle work from the stream exactly as if the controller had called us, and reply
+// with the outcome. Errors are only returned when the stream needs to be reopened
+// Preparing a render can take minutes fetching assets, so it runs in the background
+// and replies when done, rather than holding up the jobs & cancels behind it
+func handleWorkItem(s *server, stream pb.Controller_RequestJobsClient, item *pb.WorkItem) error {
+	ctx := context.Background()
+
+	if item.Prepare != nil {
+		go func() {
+			_, err := s.PrepareRender(ctx, item.Prepare)
+
+			// A broken stream is noticed by the receive loop, which reopens it
+			_ = replyWork(stream, item, err)
+		}()
+
+		return nil
+	}
+
+	var err error
+	switch {
+	case item.Job != nil:
+		_, err = s.NewJob(ctx, item.Job)
+	case item.Cancel != nil:
+		_, err = s.CancelJob(ctx, item.Cancel)
+	}
+
+	return replyWork(stream, item, err)
+}
+
+// Reply to a work item on the stream it came from, with the outcome of handling it
+// The reply is dropped if that stream has been replaced, as the controller numbers
+// work items afresh on each stream
+func replyWork(stream pb.Controller_RequestJobsClient, item *pb.WorkItem, err error) error {
+	if err != nil {
+		log.Printf("Failed to handle work from controller: %s", err.Error())
+	}
+
+	pullStream.lock.Lock()
+	defer pullStream.lock.Unlock()
+
+	if pullStream.stream != stream {
+		return nil
+	}
+
+	st := status.Convert(err)
+	return stream.Send(&pb.PullMessage{
+		Reply: &pb.WorkReply{Id: item.Id, Code: int32(st.Code()), Message: st.Message()},
+	})
+}
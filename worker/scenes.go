@@ -0,0 +1,136 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"nanoray/lib/raytrace"
+)
+
+// A scene and camera parsed ready for rendering jobs
+type preparedScene struct {
+	hash   string
+	scene  *raytrace.Scene
+	camera *raytrace.Camera
+}
+
+// Renders remembered at once, as we aren't told when a render is finished. Beyond this
+// the least recently used are forgotten, and the controller prepares them again if needed
+const maxRenders = 256
+
+// A render using a cached scene
+type renderBinding struct {
+	renderID string
+	hash     string
+}
+
+// Parsed scenes keyed by scene hash, the least recently used is dropped when full
+// Renders are mapped to scenes, so many renders of the same scene share one parse
+type sceneCache struct {
+	lock        sync.Mutex
+	size        int
+	maxRenders  int
+	order       *list.List               // Most recently used at the front
+	scenes      map[string]*list.Element // Scene hash to element in order
+	renderOrder *list.List               // Bindings, most recently used at the front
+	renders     map[string]*list.Element // Render ID to element in renderOrder
+}
+
+func newSceneCache(size int) *sceneCache {
+	return &sceneCache{
+		size:        max(size, 1),
+		maxRenders:  maxRenders,
+		order:       list.New(),
+		scenes:      make(map[string]*list.Element),
+		renderOrder: list.New(),
+		renders:     make(map[string]*list.Element),
+	}
+}
+
+// Add a newly parsed scene, evicting the least recently used if the cache is full
+func (c *sceneCache) add(p *preparedScene) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.scenes[p.hash]; ok {
+		el.Value = p
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.scenes[p.hash] = c.order.PushFront(p)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Remove(c.order.Back()).(*preparedScene)
+		delete(c.scenes, oldest.hash)
+
+		for renderID, el := range c.renders {
+			if el.Value.(*renderBinding).hash == oldest.hash {
+				c.renderOrder.Remove(el)
+				delete(c.renders, renderID)
+			}
+		}
+	}
+}
+
+// Link a render to a cached scene, returns false if the scene isn't cached
+func (c *sceneCache) bind(renderID, hash string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.scenes[hash]
+	if !ok {
+		return false
+	}
+
+	c.order.MoveToFront(el)
+
+	if bound, ok := c.renders[renderID]; ok {
+		bound.Value.(*renderBinding).hash = hash
+		c.renderOrder.MoveToFront(bound)
+		return true
+	}
+
+	c.renders[renderID] = c.renderOrder.PushFront(&renderBinding{renderID: renderID, hash: hash})
+
+	for c.renderOrder.Len() > c.maxRenders {
+		oldest := c.renderOrder.Remove(c.renderOrder.Back()).(*renderBinding)
+		delete(c.renders, oldest.renderID)
+	}
+
+	return true
+}
+
+// Get the scene for a render, nil if the render hasn't been prepared
+func (c *sceneCache) forRender(renderID string) *preparedScene {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	bound, ok := c.renders[renderID]
+	if !ok {
+		return nil
+	}
+
+	el, ok := c.scenes[bound.Value.(*renderBinding).hash]
+	if !ok {
+		return nil
+	}
+
+	c.renderOrder.MoveToFront(bound)
+	c.order.MoveToFront(el)
+
+	return el.Value.(*preparedScene)
+}
+
+// Hashes of all cached scenes, most recently used first
+func (c *sceneCache) hashes() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	hashes := make([]string, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		hashes = append(hashes, el.Value.(*preparedScene).hash)
+	}
+
+	return hashes
+}
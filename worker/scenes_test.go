@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSceneCacheEviction(t *testing.T) {
+	cache := newSceneCache(2)
+
+	cache.add(&preparedScene{hash: "a"})
+	cache.add(&preparedScene{hash: "b"})
+
+	if got := cache.hashes(); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Fatalf("hashes are %v, want [b a]", got)
+	}
+
+	// Using a makes b the least recently used, so b is evicted next
+	if !cache.bind("render1", "a") {
+		t.Fatal("bind to cached scene failed")
+	}
+
+	cache.add(&preparedScene{hash: "c"})
+
+	if got := cache.hashes(); !reflect.DeepEqual(got, []string{"c", "a"}) {
+		t.Fatalf("hashes after eviction are %v, want [c a]", got)
+	}
+
+	// Lookups also count as use
+	cache.bind("render2", "c")
+	if cache.forRender("render1") == nil {
+		t.Fatal("render1 scene missing")
+	}
+
+	cache.add(&preparedScene{hash: "d"})
+
+	if got := cache.hashes(); !reflect.DeepEqual(got, []string{"d", "a"}) {
+		t.Fatalf("hashes after second eviction are %v, want [d a]", got)
+	}
+
+	// Renders bound to an evicted scene need preparing again
+	if cache.forRender("render2") != nil {
+		t.Error("render2 still has a scene after it was evicted")
+	}
+
+	if _, ok := cache.renders["render2"]; ok {
+		t.Error("render2 binding was kept after its scene was evicted")
+	}
+}
+
+func TestSceneCacheBind(t *testing.T) {
+	cache := newSceneCache(4)
+
+	if cache.bind("render1", "missing") {
+		t.Error("bind to an unknown scene succeeded")
+	}
+
+	if cache.forRender("render1") != nil {
+		t.Error("unbound render has a scene")
+	}
+
+	first := &preparedScene{hash: "a"}
+	cache.add(first)
+	cache.add(&preparedScene{hash: "b"})
+
+	// Many renders can share one scene
+	cache.bind("render1", "a")
+	cache.bind("render2", "a")
+	cache.bind("render3", "b")
+
+	if cache.forRender("render1") != first || cache.forRender("render2") != first {
+		t.Error("renders of the same scene don't share it")
+	}
+
+	if got := cache.forRender("render3"); got == nil || got.hash != "b" {
+		t.Errorf("render3 has scene %v, want b", got)
+	}
+
+	// Adding a scene again replaces it, without a duplicate entry
+	second := &preparedScene{hash: "a"}
+	cache.add(second)
+
+	if cache.forRender("render1") != second {
+		t.Error("render1 doesn't see the replaced scene")
+	}
+
+	if got := cache.hashes(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("hashes are %v, want [a b]", got)
+	}
+
+	// A render can move to a different scene
+	cache.bind("render1", "b")
+	if got := cache.forRender("render1"); got == nil || got.hash != "b" {
+		t.Errorf("rebound render1 has scene %v, want b", got)
+	}
+}
+
+func TestSceneCacheMinimumSize(t *testing.T) {
+	cache := newSceneCache(0)
+
+	cache.add(&preparedScene{hash: "a"})
+	cache.add(&preparedScene{hash: "b"})
+
+	if got := cache.hashes(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("hashes are %v, want [b]", got)
+	}
+}
+
+func TestSceneCacheForgetsOldRenders(t *testing.T) {
+	cache := newSceneCache(4)
+	cache.maxRenders = 2
+
+	cache.add(&preparedScene{hash: "a"})
+	cache.bind("render1", "a")
+	cache.bind("render2", "a")
+
+	// Using render1 makes render2 the one forgotten when render3 arrives
+	cache.forRender("render1")
+	cache.bind("render3", "a")
+
+	if cache.forRender("render2") != nil {
+		t.Error("render2 was not forgotten")
+	}
+
+	if cache.forRender("render1") == nil || cache.forRender("render3") == nil {
+		t.Error("recently used renders were forgotten")
+	}
+
+	// Binding a render again doesn't count as another render
+	cache.bind("render3", "a")
+	cache.bind("render1", "a")
+
+	if len(cache.renders) != 2 || cache.renderOrder.Len() != 2 {
+		t.Errorf("%d renders, %d in order, want 2", len(cache.renders), cache.renderOrder.Len())
+	}
+
+	// The scene stays cached, so a forgotten render is quick to prepare again
+	if !cache.bind("render2", "a") || cache.forRender("render2") == nil {
+		t.Error("forgotten render could not be bound again")
+	}
+}
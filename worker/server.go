@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"image"
 	"log"
+	"sync"
 
 	"nanoray/lib/controller"
 	pb "nanoray/lib/proto"
@@ -16,43 +19,201 @@ type server struct {
 	pb.UnimplementedWorkerServer
 }
 
-var scene *raytrace.Scene
-var camera *raytrace.Camera
+// Jobs being rendered, keyed by render & job ID, so they can be cancelled
+var running = map[string]context.CancelFunc{}
+var runningLock sync.Mutex
+
+func jobKey(job *pb.JobRequest) string {
+	return fmt.Sprintf("%s/%d", job.RenderID, job.Id)
+}
 
 func (s *server) NewJob(ctx context.Context, job *pb.JobRequest) (*pb.Void, error) {
-	if scene == nil {
-		return nil, status.Errorf(codes.FailedPrecondition, "No scene loaded")
+	prepared := scenes.forRender(job.RenderID)
+	if prepared == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "No scene loaded for render '%s'", job.RenderID)
+	}
+
+	// The job outlives this RPC, so it gets its own context for cancellation
+	jobCtx, cancel := context.WithCancel(context.Background())
+
+	// The controller tracks how busy we are, but check anyway so it can't overload us
+	runningLock.Lock()
+	if len(running) >= int(workerInfo.MaxJobs) {
+		runningLock.Unlock()
+		cancel()
+		return nil, status.Errorf(codes.ResourceExhausted, "Already running the maximum of %d jobs", workerInfo.MaxJobs)
+	}
+
+	// A second copy would overwrite the cancel func of the first, which could then never be cancelled
+	if _, exists := running[jobKey(job)]; exists {
+		runningLock.Unlock()
+		cancel()
+		return nil, status.Errorf(codes.AlreadyExists, "Job %d of render '%s' is already running", job.Id, job.RenderID)
 	}
 
+	running[jobKey(job)] = cancel
+	runningLock.Unlock()
+
 	go func(job *pb.JobRequest) {
+		defer cancel()
+
 		// All the rendering work happens here
-		res := raytrace.RenderJob(job, *scene, *camera)
-		res.Worker = &workerInfo
+		res, err := renderResult(jobCtx, job, prepared, raytrace.TileEncoding(job.ResultEncoding))
+
+		// Free the slot before sending, the controller hands out the next job as the result arrives
+		runningLock.Lock()
+		delete(running, jobKey(job))
+		runningLock.Unlock()
+		requestJob()
+
+		if err != nil {
+			if jobCtx.Err() == nil {
+				log.Printf("Failed to encode job %d result: %s", job.Id, err.Error())
+			}
+			return
+		}
 
-		_, err := controller.Client.JobComplete(context.Background(), res)
+		err = sendResult(res)
 		if err != nil {
 			log.Printf("Failed to send completed job result: %s", err.Error())
 		}
+
+		if status.Code(err) == codes.NotFound {
+			reregister()
+		}
 	}(job)
 
 	return &pb.Void{}, nil
 }
 
+// Size of each message when streaming a job result to the controller
+const resultChunkSize = 1 << 20
+
+// Render a job and encode the result for sending, progressive passes are sent as radiance
+func renderResult(ctx context.Context, job *pb.JobRequest, prepared *preparedScene, encoding raytrace.TileEncoding) (*pb.JobResult, error) {
+	res := &pb.JobResult{
+		Job:      job,
+		Worker:   &workerInfo,
+		RenderID: job.RenderID,
+		Encoding: string(encoding),
+	}
+
+	if encoding == raytrace.TileRadiance {
+		radiance, counts, err := raytrace.RenderRadiance(ctx, job, *prepared.scene, *prepared.camera)
+		if err != nil {
+			return nil, err
+		}
+
+		res.ImageData = raytrace.EncodeRadiance(radiance)
+		if counts != nil {
+			res.SampleCounts = raytrace.EncodeSampleCounts(counts)
+		}
+
+		return res, nil
+	}
+
+	rendered, err := raytrace.RenderJob(ctx, job, *prepared.scene, *prepared.camera)
+	if err != nil {
+		return nil, err
+	}
+
+	tile := image.NewRGBA(image.Rect(0, 0, int(job.Width), int(job.Height)))
+	tile.Pix = rendered.ImageData
+
+	res.SampleCounts = rendered.SampleCounts
+	res.ImageData, err = raytrace.EncodeTile(tile, encoding)
+
+	return res, err
+}
+
+// Stream an encoded job result to the controller in chunks
+func sendResult(res *pb.JobResult) error {
+	stream, err := controller.Client.JobComplete(context.Background())
+	if err != nil {
+		return err
+	}
+
+	data, counts := res.ImageData, res.SampleCounts
+
+	// First message carries the job details, the rest just more image data then sample counts
+	msg := res
+	for first := true; first || len(data) > 0 || len(counts) > 0; first = false {
+		n := min(len(data), resultChunkSize)
+		msg.ImageData = data[:n]
+		data = data[n:]
+
+		m := min(len(counts), resultChunkSize-n)
+		msg.SampleCounts = counts[:m]
+		counts = counts[m:]
+
+		if err := stream.Send(msg); err != nil {
+			// The real error comes back from CloseAndRecv
+			break
+		}
+
+		msg = &pb.JobResult{}
+	}
+
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func (s *server) CancelJob(ctx context.Context, job *pb.JobRequest) (*pb.Void, error) {
+	runningLock.Lock()
+	cancel, ok := running[jobKey(job)]
+	runningLock.Unlock()
+
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Job %d of render '%s' is not running", job.Id, job.RenderID)
+	}
+
+	log.Printf("Cancelling job %d of render %s", job.Id, job.RenderID)
+	cancel()
+
+	return &pb.Void{}, nil
+}
+
 func (s *server) Ping(ctx context.Context, in *pb.Void) (*pb.Void, error) {
 	return &pb.Void{}, nil
 }
 
 func (s *server) PrepareRender(ctx context.Context, in *pb.PrepRenderRequest) (*pb.Void, error) {
-	log.Printf("Preparing render with new scene & camera data")
+	width, height := int(in.ImageDetails.Width), int(in.ImageDetails.Height)
+
+	// Only the hash is sent when the controller thinks we have the scene already
+	if in.SceneData == "" {
+		if !scenes.bind(in.RenderID, in.SceneHash) {
+			return nil, status.Errorf(codes.NotFound, "Scene %.12s is not cached", in.SceneHash)
+		}
+
+		log.Printf("Preparing render %s with cached scene %.12s", in.RenderID, in.SceneHash)
+		return &pb.Void{}, nil
+	}
+
+	// Check the hash ourselves rather than trusting it, a mismatch would poison the cache
+	assets := raytrace.ManifestFromProto(in.Assets)
+	hash := raytrace.SceneHash(in.SceneData, width, height, assets)
+	if scenes.bind(in.RenderID, hash) {
+		log.Printf("Preparing render %s with cached scene %.12s", in.RenderID, hash)
+		return &pb.Void{}, nil
+	}
+
+	log.Printf("Preparing render %s with new scene & camera data", in.RenderID)
+
+	err := fetchAssets(ctx, assets)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "Failed to fetch scene assets: %s", err.Error())
+	}
+
+	resolver := raytrace.ManifestResolver{Manifest: assets, Store: blobs}
 
-	sceneData := in.SceneData
-	sceneNew, cameraNew, err := raytrace.ParseScene(sceneData, int(in.ImageDetails.Width), int(in.ImageDetails.Height))
+	sceneNew, cameraNew, err := raytrace.ParseScene(in.SceneData, width, height, resolver)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "Failed to parse scene data: %s", err.Error())
 	}
 
-	scene = sceneNew
-	camera = cameraNew
+	scenes.add(&preparedScene{hash: hash, scene: sceneNew, camera: cameraNew})
+	scenes.bind(in.RenderID, hash)
 
 	return &pb.Void{}, nil
 }
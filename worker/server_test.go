@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+
+	"nanoray/lib/controller"
+	pb "nanoray/lib/proto"
+	"nanoray/lib/raytrace"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Controller client that records job results streamed to it
+type resultRecorder struct {
+	pb.ControllerClient
+	grpc.ClientStream
+	sent []*pb.JobResult
+}
+
+func (r *resultRecorder) JobComplete(ctx context.Context, opts ...grpc.CallOption) (pb.Controller_JobCompleteClient, error) {
+	return r, nil
+}
+
+func (r *resultRecorder) Send(res *pb.JobResult) error {
+	r.sent = append(r.sent, res)
+	return nil
+}
+
+func (r *resultRecorder) CloseAndRecv() (*pb.Void, error) {
+	return &pb.Void{}, nil
+}
+
+func TestSendResultChunks(t *testing.T) {
+	oldClient := controller.Client
+	defer func() { controller.Client = oldClient }()
+
+	cases := []struct {
+		name     string
+		w, h     int
+		encoding raytrace.TileEncoding
+		chunks   int
+	}{
+		{"small raw", 16, 16, raytrace.TileRaw, 1},
+		{"large raw", 700, 400, raytrace.TileRaw, 2},
+		{"exact chunk", 512, 512, raytrace.TileRaw, 1},
+		{"large png", 700, 400, raytrace.TilePNG, 1},
+	}
+
+	for _, tc := range cases {
+		recorder := &resultRecorder{}
+		controller.Client = recorder
+
+		job := &pb.JobRequest{Id: 3, Width: int32(tc.w), Height: int32(tc.h)}
+		pix := make([]byte, tc.w*tc.h*4)
+		for i := range pix {
+			pix[i] = byte(i % 251)
+			if i%4 == 3 {
+				pix[i] = 255
+			}
+		}
+
+		tile := image.NewRGBA(image.Rect(0, 0, tc.w, tc.h))
+		tile.Pix = append([]byte(nil), pix...)
+
+		encoded, err := raytrace.EncodeTile(tile, tc.encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := &pb.JobResult{Job: job, RenderID: "r1", Encoding: string(tc.encoding), ImageData: encoded}
+		if err := sendResult(res); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if len(recorder.sent) != tc.chunks {
+			t.Fatalf("%s: sent %d messages, want %d", tc.name, len(recorder.sent), tc.chunks)
+		}
+
+		first := recorder.sent[0]
+		if first.Job.GetId() != 3 || first.RenderID != "r1" || first.Encoding != string(tc.encoding) {
+			t.Errorf("%s: first message is missing job details: %v", tc.name, first)
+		}
+
+		data := []byte{}
+		for i, msg := range recorder.sent {
+			if len(msg.ImageData) > resultChunkSize {
+				t.Errorf("%s: message %d has %d bytes, over the chunk size", tc.name, i, len(msg.ImageData))
+			}
+
+			if i > 0 && msg.Job != nil {
+				t.Errorf("%s: message %d repeats the job details", tc.name, i)
+			}
+
+			data = append(data, msg.ImageData...)
+		}
+
+		img, err := raytrace.DecodeTile(data, tc.encoding, tc.w, tc.h)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if !bytes.Equal(img.Pix, pix) {
+			t.Errorf("%s: pixels changed in transit", tc.name)
+		}
+	}
+}
+
+func TestSendResultRadiance(t *testing.T) {
+	oldClient := controller.Client
+	defer func() { controller.Client = oldClient }()
+
+	recorder := &resultRecorder{}
+	controller.Client = recorder
+
+	// Radiance fits in the first chunk, the sample counts spill over into a second
+	w, h := 250, 250
+	radiance := make([]float32, w*h*4)
+	for i := range radiance {
+		radiance[i] = float32(i) * 0.001
+	}
+
+	counts := make([]int32, w*h)
+	for i := range counts {
+		counts[i] = int32(i % 97)
+	}
+
+	job := &pb.JobRequest{Id: 4, Width: int32(w), Height: int32(h)}
+	res := &pb.JobResult{
+		Job:          job,
+		Encoding:     string(raytrace.TileRadiance),
+		ImageData:    raytrace.EncodeRadiance(radiance),
+		SampleCounts: raytrace.EncodeSampleCounts(counts),
+	}
+
+	if err := sendResult(res); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(recorder.sent))
+	}
+
+	data, countData := []byte{}, []byte{}
+	for i, msg := range recorder.sent {
+		if len(msg.ImageData)+len(msg.SampleCounts) > resultChunkSize {
+			t.Errorf("message %d has %d bytes, over the chunk size", i, len(msg.ImageData)+len(msg.SampleCounts))
+		}
+
+		if len(msg.SampleCounts) == 0 {
+			t.Errorf("message %d has no sample counts, they should be split", i)
+		}
+
+		data = append(data, msg.ImageData...)
+		countData = append(countData, msg.SampleCounts...)
+	}
+
+	gotRadiance, err := raytrace.DecodeRadiance(data, w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range gotRadiance {
+		if gotRadiance[i] != radiance[i] {
+			t.Fatalf("radiance %d is %v, want %v", i, gotRadiance[i], radiance[i])
+		}
+	}
+
+	gotCounts, err := raytrace.DecodeSampleCounts(countData, w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range gotCounts {
+		if gotCounts[i] != counts[i] {
+			t.Fatalf("sample count %d is %v, want %v", i, gotCounts[i], counts[i])
+		}
+	}
+}
+
+func TestNewJobRejected(t *testing.T) {
+	oldScenes, oldMax := scenes, workerInfo.MaxJobs
+	defer func() {
+		scenes, workerInfo.MaxJobs = oldScenes, oldMax
+		running = map[string]context.CancelFunc{}
+	}()
+
+	scenes = newSceneCache(2)
+	scenes.add(&preparedScene{hash: "h"})
+	scenes.bind("r1", "h")
+	workerInfo.MaxJobs = 2
+
+	tests := []struct {
+		name    string
+		running []*pb.JobRequest // Already running before the new job arrives
+		job     *pb.JobRequest
+		code    codes.Code
+	}{
+		{"no scene", nil, &pb.JobRequest{RenderID: "r2", Id: 1}, codes.FailedPrecondition},
+		{"duplicate", []*pb.JobRequest{{RenderID: "r1", Id: 1}}, &pb.JobRequest{RenderID: "r1", Id: 1}, codes.AlreadyExists},
+		{"full", []*pb.JobRequest{{RenderID: "r1", Id: 1}, {RenderID: "r1", Id: 2}}, &pb.JobRequest{RenderID: "r1", Id: 3}, codes.ResourceExhausted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cancelled := 0
+			runningLock.Lock()
+			running = map[string]context.CancelFunc{}
+			for _, job := range test.running {
+				running[jobKey(job)] = func() { cancelled++ }
+			}
+			runningLock.Unlock()
+
+			_, err := (&server{}).NewJob(context.Background(), test.job)
+			if status.Code(err) != test.code {
+				t.Fatalf("got error %v, want code %s", err, test.code)
+			}
+
+			// Jobs already running must still be there to cancel
+			if len(running) != len(test.running) || cancelled != 0 {
+				t.Errorf("%d jobs running, %d cancelled, want %d running", len(running), cancelled, len(test.running))
+			}
+
+			for _, job := range test.running {
+				before := cancelled
+				if _, err := (&server{}).CancelJob(context.Background(), job); err != nil || cancelled != before+1 {
+					t.Errorf("job %d could not be cancelled: %v", job.Id, err)
+				}
+			}
+		})
+	}
+}